@@ -0,0 +1,47 @@
+#include <stdint.h>
+
+#include "pixel.h"
+
+// Accelerates RgbaCompareCropStats.
+// The mask is applied to both the haystack and the needle pixels. The stats
+// array receives the sums of absolute channel differences, then the sums of
+// squared channel differences, then the maximum absolute channel differences,
+// each in red, green, blue, alpha order. The strides are measured in pixels.
+void GoRgbaCompareCropStats(void* haystackBytes, void* needleBytes,
+    int hayStride, int needleStride, int needleWidth, int needleHeight,
+    int needleLeft, int needleTop, uint32_t pixelMask, uint64_t* stats) {
+  uint64_t absSums[4] = {0, 0, 0, 0};
+  uint64_t squareSums[4] = {0, 0, 0, 0};
+  int maxErrors[4] = {0, 0, 0, 0};
+
+  const uint32_t* haystackRow = (const uint32_t*)haystackBytes +
+      needleTop * hayStride + needleLeft;
+  const uint32_t* needleRow = (const uint32_t*)needleBytes;
+  for (int y = 0; y < needleHeight; ++y) {
+    for (int x = 0; x < needleWidth; ++x) {
+      uint32_t hayPixel = haystackRow[x] & pixelMask;
+      uint32_t needlePixel = needleRow[x] & pixelMask;
+      int errors[4] = {
+        (int)pixelRed(hayPixel) - (int)pixelRed(needlePixel),
+        (int)pixelGreen(hayPixel) - (int)pixelGreen(needlePixel),
+        (int)pixelBlue(hayPixel) - (int)pixelBlue(needlePixel),
+        (int)pixelAlpha(hayPixel) - (int)pixelAlpha(needlePixel),
+      };
+      for (int i = 0; i < 4; ++i) {
+        int diff = errors[i] < 0 ? -errors[i] : errors[i];
+        absSums[i] += (uint64_t)diff;
+        squareSums[i] += (uint64_t)(diff * diff);
+        if (diff > maxErrors[i])
+          maxErrors[i] = diff;
+      }
+    }
+    haystackRow += hayStride;
+    needleRow += needleStride;
+  }
+
+  for (int i = 0; i < 4; ++i) {
+    stats[i] = absSums[i];
+    stats[4 + i] = squareSums[i];
+    stats[8 + i] = (uint64_t)maxErrors[i];
+  }
+}
@@ -0,0 +1,28 @@
+#include <memory.h>
+#include <stdint.h>
+
+// Accelerates CropRgbaMany.
+// Each crop is described by 5 int32_t values: the left, top, right and bottom
+// edges of its rectangle, and the offset of its first pixel in the target
+// slab, measured in pixels. All the rectangles must be inside the image, and
+// must cover rows between minY (inclusive) and maxY (exclusive).
+void GoRgbaCropMany(void* rgbaBytes, void* slabBytes, int width,
+    void* cropBytes, int cropCount, int minY, int maxY) {
+  uint32_t* rgbaPixels = (uint32_t*)rgbaBytes;
+  uint32_t* slabPixels = (uint32_t*)slabBytes;
+  const int32_t* crops = (const int32_t*)cropBytes;
+
+  for (int y = minY; y < maxY; ++y) {
+    uint32_t* row = rgbaPixels + y * width;
+    const int32_t* crop = crops;
+    for (int i = cropCount; i > 0; --i, crop += 5) {
+      int32_t left = crop[0], top = crop[1], right = crop[2],
+          bottom = crop[3], offset = crop[4];
+      if (y < top || y >= bottom)
+        continue;
+      int32_t cropWidth = right - left;
+      memcpy(slabPixels + offset + (y - top) * cropWidth, row + left,
+          cropWidth * 4);
+    }
+  }
+}
@@ -1,24 +1,57 @@
 #include <stdint.h>
 
-// Accelerates MaskRgba.
-void GoMaskRgba(void* bytes, int byteCount, uint64_t mask) {
+#include "pixel.h"
+#include "simd.h"
+
+// The bitwise operations supported by GoMaskRgba.
+enum {
+  kMaskAnd = 0,
+  kMaskOr = 1,
+  kMaskXor = 2,
+  kMaskAndNot = 3,
+};
+
+// Accelerates MaskRgba, OrMaskRgba, XorMaskRgba, and AndNotMaskRgba.
+// The bytes that don't fill up a whole word are combined with the
+// corresponding bytes in the mask's in-memory representation.
+void GoMaskRgba(void* bytes, int byteCount, uint64_t mask, int operation) {
   uint64_t* words = (uint64_t*)bytes;
-  for (int wordCount = (byteCount >> 3); wordCount > 0; --wordCount) {
-    *words &= mask;
-    ++words;
+  int wordCount = byteCount >> 3;
+  switch (operation) {
+    case kMaskAnd:
+      for (int i = wordCount; i > 0; --i, ++words) *words &= mask;
+      break;
+    case kMaskOr:
+      for (int i = wordCount; i > 0; --i, ++words) *words |= mask;
+      break;
+    case kMaskXor:
+      for (int i = wordCount; i > 0; --i, ++words) *words ^= mask;
+      break;
+    case kMaskAndNot:
+      for (int i = wordCount; i > 0; --i, ++words) *words &= ~mask;
+      break;
+  }
+
+  uint8_t* tail = (uint8_t*)words;
+  const uint8_t* maskBytes = (const uint8_t*)&mask;
+  for (int i = 0; i < (byteCount & 7); ++i) {
+    switch (operation) {
+      case kMaskAnd: tail[i] &= maskBytes[i]; break;
+      case kMaskOr: tail[i] |= maskBytes[i]; break;
+      case kMaskXor: tail[i] ^= maskBytes[i]; break;
+      case kMaskAndNot: tail[i] &= ~maskBytes[i]; break;
+    }
   }
 }
 
-// Accelerates RgbaToHsla.
-void GoRgbaToHsla(void* rgbaBytes, void* hslaBytes, int byteCount) {
-  uint32_t *rgbaPixel = (uint32_t*)rgbaBytes;
-  uint32_t *hslaPixel = (uint32_t*)hslaBytes;
-  for (int i = (byteCount >> 2); i > 0; --i, ++rgbaPixel, ++hslaPixel) {
-    unsigned rgba = *rgbaPixel;
-    int r = rgba & 0xff;
-    int g = (rgba >> 8) & 0xff;
-    int b = (rgba >> 16) & 0xff;
-    unsigned unshiftedA = rgba & 0xff000000;
+// The reference RGBA -> HSLA conversion. The SIMD versions must match it.
+static void rgbaToHslaScalar(const uint32_t* rgbaPixel, uint32_t* hslaPixel,
+    int pixelCount) {
+  for (int i = pixelCount; i > 0; --i, ++rgbaPixel, ++hslaPixel) {
+    uint32_t rgba = *rgbaPixel;
+    int r = pixelRed(rgba);
+    int g = pixelGreen(rgba);
+    int b = pixelBlue(rgba);
 
     // RGB -> HSL formula lifted and adapted for 0-255 from:
     // http://www.niwa.nu/2013/05/math-behind-colorspace-conversions-rgb-hsl/
@@ -53,26 +86,183 @@ void GoRgbaToHsla(void* rgbaBytes, void* hslaBytes, int byteCount) {
       }
     }
 
-    uint32_t hsla = (uint32_t)((unsigned)h | ((unsigned)s << 8) |
-        ((unsigned)l << 16) | unshiftedA);
-    *hslaPixel = hsla;
+    *hslaPixel = pixelPack((uint8_t)h, (uint8_t)s, (uint8_t)l,
+        pixelAlpha(rgba));
   }
 }
 
-// Accelerates RgbaThreshold.
-void GoRgbaThreshold(void* rgbaBytes, int byteCount, uint8_t minR,
-    uint8_t minG, uint8_t minB, uint8_t maxR, uint8_t maxG, uint8_t maxB) {
-  uint32_t *rgbaPixel = (uint32_t*)rgbaBytes;
-  for (int i = (byteCount >> 2); i > 0; --i, ++rgbaPixel) {
-    unsigned rgba = *rgbaPixel & 0x00ffffff;
-    uint8_t r = rgba & 0xff;
-    uint8_t g = (rgba >> 8) & 0xff;
-    uint8_t b = rgba >> 16;
+// The reference RGBA threshold. The SIMD versions must match it.
+static void rgbaThresholdScalar(uint32_t* rgbaPixel, int pixelCount,
+    uint8_t minR, uint8_t minG, uint8_t minB, uint8_t maxR, uint8_t maxG,
+    uint8_t maxB) {
+  for (int i = pixelCount; i > 0; --i, ++rgbaPixel) {
+    uint32_t rgba = *rgbaPixel & ~kPixelAlphaMask;
+    uint8_t r = pixelRed(rgba);
+    uint8_t g = pixelGreen(rgba);
+    uint8_t b = pixelBlue(rgba);
 
     if (r >= minR && g >= minG && b >= minB &&
         r <= maxR && g <= maxG && b <= maxB) {
-      rgba |= 0xff000000;
+      rgba |= kPixelAlphaMask;
     }
     *rgbaPixel = rgba;
   }
 }
+
+#if SIMD_X86
+
+// The SIMD kernels below process whole vectors, and return the number of
+// pixels that they processed. The scalar kernels handle the remaining pixels.
+
+// Divides signed 32-bit integers, truncating towards zero like C does.
+// The quotients of the divisions done by the HSL conversion are small enough
+// that double-precision division always truncates to the exact result.
+SIMD_TARGET_AVX2 static inline __m256i divideAvx2(__m256i numerator,
+    __m256i denominator) {
+  __m256d lowQuotient = _mm256_div_pd(
+      _mm256_cvtepi32_pd(_mm256_castsi256_si128(numerator)),
+      _mm256_cvtepi32_pd(_mm256_castsi256_si128(denominator)));
+  __m256d highQuotient = _mm256_div_pd(
+      _mm256_cvtepi32_pd(_mm256_extracti128_si256(numerator, 1)),
+      _mm256_cvtepi32_pd(_mm256_extracti128_si256(denominator, 1)));
+  return _mm256_inserti128_si256(
+      _mm256_castsi128_si256(_mm256_cvttpd_epi32(lowQuotient)),
+      _mm256_cvttpd_epi32(highQuotient), 1);
+}
+
+SIMD_TARGET_AVX2 static int rgbaToHslaAvx2(const uint32_t* rgbaPixels,
+    uint32_t* hslaPixels, int pixelCount) {
+  const __m256i byteMask = _mm256_set1_epi32(0xff);
+  const __m256i zero = _mm256_setzero_si256();
+  const __m256i one = _mm256_set1_epi32(1);
+
+  int i = 0;
+  for (; i + 8 <= pixelCount; i += 8) {
+    __m256i rgba = _mm256_loadu_si256((const __m256i*)(rgbaPixels + i));
+    __m256i r = _mm256_and_si256(
+        _mm256_srli_epi32(rgba, PIXEL_RED_SHIFT), byteMask);
+    __m256i g = _mm256_and_si256(
+        _mm256_srli_epi32(rgba, PIXEL_GREEN_SHIFT), byteMask);
+    __m256i b = _mm256_and_si256(
+        _mm256_srli_epi32(rgba, PIXEL_BLUE_SHIFT), byteMask);
+    __m256i a = _mm256_and_si256(
+        _mm256_srli_epi32(rgba, PIXEL_ALPHA_SHIFT), byteMask);
+
+    __m256i min = _mm256_min_epi32(_mm256_min_epi32(r, g), b);
+    __m256i max = _mm256_max_epi32(_mm256_max_epi32(r, g), b);
+    __m256i sum = _mm256_add_epi32(min, max);
+    __m256i diff = _mm256_sub_epi32(max, min);
+    __m256i l = _mm256_srli_epi32(sum, 1);
+
+    // NOTE: Gray pixels have diff == 0, and their H and S are zeroed out at
+    //       the end. Their divisors are replaced with 1 to avoid dividing by
+    //       zero.
+    __m256i lightMask = _mm256_cmpgt_epi32(l, _mm256_set1_epi32(127));
+    __m256i sDivisor = _mm256_blendv_epi8(sum,
+        _mm256_sub_epi32(_mm256_set1_epi32(510), sum), lightMask);
+    __m256i s = divideAvx2(_mm256_mullo_epi32(diff, _mm256_set1_epi32(255)),
+        _mm256_max_epi32(sDivisor, one));
+
+    // NOTE: The scalar code adds 256 to negative hues when R is the maximum,
+    //       which does not change the hue's lowest byte.
+    __m256i redMask = _mm256_cmpeq_epi32(max, r);
+    __m256i greenMask = _mm256_andnot_si256(redMask,
+        _mm256_cmpeq_epi32(max, g));
+    __m256i hNumerator = _mm256_blendv_epi8(
+        _mm256_blendv_epi8(_mm256_sub_epi32(r, g), _mm256_sub_epi32(b, r),
+            greenMask),
+        _mm256_sub_epi32(g, b), redMask);
+    __m256i hBase = _mm256_blendv_epi8(
+        _mm256_blendv_epi8(_mm256_set1_epi32(168), _mm256_set1_epi32(84),
+            greenMask),
+        zero, redMask);
+    __m256i h = _mm256_add_epi32(hBase, divideAvx2(
+        _mm256_mullo_epi32(hNumerator, _mm256_set1_epi32(42)),
+        _mm256_max_epi32(diff, one)));
+
+    __m256i grayMask = _mm256_cmpeq_epi32(diff, zero);
+    h = _mm256_andnot_si256(grayMask, _mm256_and_si256(h, byteMask));
+    s = _mm256_andnot_si256(grayMask, _mm256_and_si256(s, byteMask));
+
+    __m256i hsla = _mm256_or_si256(
+        _mm256_or_si256(_mm256_slli_epi32(h, PIXEL_RED_SHIFT),
+            _mm256_slli_epi32(s, PIXEL_GREEN_SHIFT)),
+        _mm256_or_si256(_mm256_slli_epi32(l, PIXEL_BLUE_SHIFT),
+            _mm256_slli_epi32(a, PIXEL_ALPHA_SHIFT)));
+    _mm256_storeu_si256((__m256i*)(hslaPixels + i), hsla);
+  }
+  return i;
+}
+
+SIMD_TARGET_SSE2 static int rgbaThresholdSse2(uint32_t* rgbaPixels,
+    int pixelCount, uint32_t low, uint32_t high) {
+  const __m128i lowVector = _mm_set1_epi32((int)low);
+  const __m128i highVector = _mm_set1_epi32((int)high);
+  const __m128i alphaMask = _mm_set1_epi32((int)kPixelAlphaMask);
+
+  int i = 0;
+  for (; i + 4 <= pixelCount; i += 4) {
+    __m128i* rgbaVector = (__m128i*)(rgbaPixels + i);
+    __m128i rgba = _mm_loadu_si128(rgbaVector);
+    __m128i inRange = simdInRangeSse2(rgba, lowVector, highVector);
+    rgba = _mm_or_si128(_mm_andnot_si128(alphaMask, rgba),
+        _mm_and_si128(inRange, alphaMask));
+    _mm_storeu_si128(rgbaVector, rgba);
+  }
+  return i;
+}
+
+SIMD_TARGET_AVX2 static int rgbaThresholdAvx2(uint32_t* rgbaPixels,
+    int pixelCount, uint32_t low, uint32_t high) {
+  const __m256i lowVector = _mm256_set1_epi32((int)low);
+  const __m256i highVector = _mm256_set1_epi32((int)high);
+  const __m256i alphaMask = _mm256_set1_epi32((int)kPixelAlphaMask);
+
+  int i = 0;
+  for (; i + 8 <= pixelCount; i += 8) {
+    __m256i* rgbaVector = (__m256i*)(rgbaPixels + i);
+    __m256i rgba = _mm256_loadu_si256(rgbaVector);
+    __m256i inRange = simdInRangeAvx2(rgba, lowVector, highVector);
+    rgba = _mm256_or_si256(_mm256_andnot_si256(alphaMask, rgba),
+        _mm256_and_si256(inRange, alphaMask));
+    _mm256_storeu_si256(rgbaVector, rgba);
+  }
+  return i;
+}
+
+#endif  // SIMD_X86
+
+// Accelerates RgbaToHsla.
+// There is no SSE2 version, because SSE2 lacks 32-bit min, max and multiply.
+void GoRgbaToHsla(void* rgbaBytes, void* hslaBytes, int byteCount,
+    int simdLevel) {
+  const uint32_t* rgbaPixels = (const uint32_t*)rgbaBytes;
+  uint32_t* hslaPixels = (uint32_t*)hslaBytes;
+  int pixelCount = byteCount >> 2;
+  int done = 0;
+#if SIMD_X86
+  if (simdLevel >= kSimdAvx2)
+    done = rgbaToHslaAvx2(rgbaPixels, hslaPixels, pixelCount);
+#endif
+  rgbaToHslaScalar(rgbaPixels + done, hslaPixels + done, pixelCount - done);
+}
+
+// Accelerates RgbaThreshold.
+void GoRgbaThreshold(void* rgbaBytes, int byteCount, uint8_t minR,
+    uint8_t minG, uint8_t minB, uint8_t maxR, uint8_t maxG, uint8_t maxB,
+    int simdLevel) {
+  uint32_t* rgbaPixels = (uint32_t*)rgbaBytes;
+  int pixelCount = byteCount >> 2;
+  int done = 0;
+#if SIMD_X86
+  // The alpha channel bounds are set so that they always pass.
+  uint32_t low = pixelPack(minR, minG, minB, 0);
+  uint32_t high = pixelPack(maxR, maxG, maxB, 0xff);
+  if (simdLevel >= kSimdAvx2)
+    done = rgbaThresholdAvx2(rgbaPixels, pixelCount, low, high);
+  else if (simdLevel >= kSimdSse2)
+    done = rgbaThresholdSse2(rgbaPixels, pixelCount, low, high);
+#endif
+  rgbaThresholdScalar(rgbaPixels + done, pixelCount - done, minR, minG, minB,
+      maxR, maxG, maxB);
+}
@@ -0,0 +1,76 @@
+#include <memory.h>
+#include <stdint.h>
+
+#include "pixel.h"
+
+// Accelerates the grayscale conversion used by keypoint detection.
+// Gray values are computed by pixelGray. The stride is measured in pixels.
+void GoRgbaToGray(void* rgbaBytes, void* grayBytes, int width, int height,
+    int stride) {
+  uint8_t* gray = (uint8_t*)grayBytes;
+  for (int y = 0; y < height; ++y) {
+    const uint32_t* row = (const uint32_t*)rgbaBytes + y * stride;
+    for (int x = 0; x < width; ++x)
+      *gray++ = pixelGray(row[x]);
+  }
+}
+
+// The 16 pixels on a radius 3 circle, clockwise from the top.
+static const int kCircleX[16] = {
+  0, 1, 2, 3, 3, 3, 2, 1, 0, -1, -2, -3, -3, -3, -2, -1
+};
+static const int kCircleY[16] = {
+  -3, -3, -2, -1, 0, 1, 2, 3, 3, 3, 2, 1, 0, -1, -2, -3
+};
+
+// Returns 1 if a 16-bit circle mask has 9 contiguous set bits.
+// The mask wraps around, so the arc can cross the circle's start.
+static inline int fastHasArc(uint32_t mask) {
+  uint32_t circle = mask | (mask << 16);
+  uint32_t run = circle;
+  for (int i = 1; i < 9; ++i)
+    run &= circle >> i;
+  return run != 0;
+}
+
+// Accelerates the FAST-9 corner test.
+// A pixel is a corner if 9 contiguous pixels on the circle around it are all
+// brighter than the pixel plus the threshold, or all darker than the pixel
+// minus the threshold. A corner's score is the sum of the amounts by which the
+// pixels in that set exceed the threshold. Non-corners and the pixels closer
+// than border to the image's edges get a zero score. The border must be at
+// least 3.
+void GoGrayFastScores(void* grayBytes, void* scoreBytes, int width,
+    int height, int threshold, int border) {
+  const uint8_t* gray = (const uint8_t*)grayBytes;
+  int32_t* scores = (int32_t*)scoreBytes;
+  memset(scores, 0, sizeof(int32_t) * width * height);
+
+  int offsets[16];
+  for (int i = 0; i < 16; ++i)
+    offsets[i] = kCircleY[i] * width + kCircleX[i];
+
+  for (int y = border; y < height - border; ++y) {
+    for (int x = border; x < width - border; ++x) {
+      const uint8_t* center = gray + y * width + x;
+      int value = *center;
+      uint32_t bright = 0, dark = 0;
+      int32_t brightSum = 0, darkSum = 0;
+      for (int i = 0; i < 16; ++i) {
+        int circleValue = center[offsets[i]];
+        if (circleValue > value + threshold) {
+          bright |= 1u << i;
+          brightSum += circleValue - value - threshold;
+        } else if (circleValue < value - threshold) {
+          dark |= 1u << i;
+          darkSum += value - circleValue - threshold;
+        }
+      }
+
+      if (fastHasArc(bright))
+        scores[y * width + x] = brightSum;
+      else if (fastHasArc(dark))
+        scores[y * width + x] = darkSum;
+    }
+  }
+}
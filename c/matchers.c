@@ -1,119 +1,284 @@
 #include <memory.h>
 #include <stdint.h>
 
+#include "pixel.h"
+#include "simd.h"
+
 // Accelerates RgbaCheckCrop.
-int GoRgbaCheckCrop(void* haystackBytes, void* needleBytes, int hayWidth,
-    int needleWidth, int needleHeight, int needleLeft, int needleTop) {
-  uint32_t* haystackRow = (uint32_t*)haystackBytes + needleTop * hayWidth +
+// The strides are measured in pixels.
+int GoRgbaCheckCrop(void* haystackBytes, void* needleBytes, int hayStride,
+    int needleStride, int needleWidth, int needleHeight, int needleLeft,
+    int needleTop) {
+  uint32_t* haystackRow = (uint32_t*)haystackBytes + needleTop * hayStride +
       needleLeft;
   uint32_t* needleRow = (uint32_t*)needleBytes;
-  uint32_t needleStride = needleWidth * 4;
+  uint32_t rowBytes = needleWidth * 4;
   for (int y = needleHeight; y > 0; --y) {
-    if (memcmp(haystackRow, needleRow, needleStride))
+    if (memcmp(haystackRow, needleRow, rowBytes))
+      return 0;
+    haystackRow += hayStride;
+    needleRow += needleStride;
+  }
+  return 1;
+}
+
+// The row kernels below compare a haystack row with a needle row. The scalar
+// versions are the reference implementations, and the SIMD versions must
+// match them.
+
+// Returns 1 if a masked haystack row matches a needle row.
+static int rowCheckMaskedScalar(const uint32_t* haystackPtr,
+    const uint32_t* needlePtr, int width, uint32_t pixelMask) {
+  for (int x = width; x > 0; --x, ++needlePtr, ++haystackPtr) {
+    if ((*haystackPtr & pixelMask) != *needlePtr)
       return 0;
-    haystackRow += hayWidth;
-    needleRow += needleWidth;
   }
   return 1;
 }
 
+// |a - b|
+static inline int channelDiff(uint8_t a, uint8_t b) {
+  return (a >= b) ? a - b : b - a;
+}
+
+// Returns the sum of absolute channel differences between rows.
+static int64_t rowDiffMaskedScalar(const uint32_t* haystackPtr,
+    const uint32_t* needlePtr, int width, uint32_t pixelMask) {
+  int64_t diff = 0;
+  for (int x = width; x > 0; --x, ++needlePtr, ++haystackPtr) {
+    uint32_t hrgba = (*haystackPtr & pixelMask);
+    uint32_t nrgba = *needlePtr;
+    diff += channelDiff(pixelRed(hrgba), pixelRed(nrgba));
+    diff += channelDiff(pixelGreen(hrgba), pixelGreen(nrgba));
+    diff += channelDiff(pixelBlue(hrgba), pixelBlue(nrgba));
+    diff += channelDiff(pixelAlpha(hrgba), pixelAlpha(nrgba));
+  }
+  return diff;
+}
+
+// Returns the number of thresholded haystack pixels whose alpha does not
+// match the needle's alpha. The bounds are packed pixels.
+static int rowDiffThresholdScalar(const uint32_t* haystackPtr,
+    const uint32_t* needlePtr, int width, uint32_t low, uint32_t high) {
+  uint8_t minR = pixelRed(low), minG = pixelGreen(low),
+      minB = pixelBlue(low);
+  uint8_t maxR = pixelRed(high), maxG = pixelGreen(high),
+      maxB = pixelBlue(high);
+  int diff = 0;
+  for (int x = width; x > 0; --x, ++needlePtr, ++haystackPtr) {
+    uint32_t hrgba = *haystackPtr;
+    uint8_t hr = pixelRed(hrgba);
+    uint8_t hg = pixelGreen(hrgba);
+    uint8_t hb = pixelBlue(hrgba);
+    uint8_t ha = (hr >= minR && hg >= minG && hb >= minB &&
+      hr <= maxR && hg <= maxG && hb <= maxB) ? 0xff : 0;
+
+    uint8_t na = pixelAlpha(*needlePtr);
+
+    if (ha != na)
+      diff += 1;
+  }
+  return diff;
+}
+
+#if SIMD_X86
+
+// The SIMD row kernels process whole vectors, then hand the remaining pixels
+// to the scalar row kernels.
+
+SIMD_TARGET_SSE2 static int rowCheckMaskedSse2(const uint32_t* haystackPtr,
+    const uint32_t* needlePtr, int width, uint32_t pixelMask) {
+  const __m128i mask = _mm_set1_epi32((int)pixelMask);
+  int x = 0;
+  for (; x + 4 <= width; x += 4) {
+    __m128i hrgba = _mm_and_si128(
+        _mm_loadu_si128((const __m128i*)(haystackPtr + x)), mask);
+    __m128i nrgba = _mm_loadu_si128((const __m128i*)(needlePtr + x));
+    if (_mm_movemask_epi8(_mm_cmpeq_epi8(hrgba, nrgba)) != 0xffff)
+      return 0;
+  }
+  return rowCheckMaskedScalar(haystackPtr + x, needlePtr + x, width - x,
+      pixelMask);
+}
+
+SIMD_TARGET_AVX2 static int rowCheckMaskedAvx2(const uint32_t* haystackPtr,
+    const uint32_t* needlePtr, int width, uint32_t pixelMask) {
+  const __m256i mask = _mm256_set1_epi32((int)pixelMask);
+  int x = 0;
+  for (; x + 8 <= width; x += 8) {
+    __m256i hrgba = _mm256_and_si256(
+        _mm256_loadu_si256((const __m256i*)(haystackPtr + x)), mask);
+    __m256i nrgba = _mm256_loadu_si256((const __m256i*)(needlePtr + x));
+    if (_mm256_movemask_epi8(_mm256_cmpeq_epi8(hrgba, nrgba)) != -1)
+      return 0;
+  }
+  return rowCheckMaskedScalar(haystackPtr + x, needlePtr + x, width - x,
+      pixelMask);
+}
+
+SIMD_TARGET_SSE2 static int64_t rowDiffMaskedSse2(
+    const uint32_t* haystackPtr, const uint32_t* needlePtr, int width,
+    uint32_t pixelMask) {
+  const __m128i mask = _mm_set1_epi32((int)pixelMask);
+  __m128i sums = _mm_setzero_si128();
+  int x = 0;
+  for (; x + 4 <= width; x += 4) {
+    __m128i hrgba = _mm_and_si128(
+        _mm_loadu_si128((const __m128i*)(haystackPtr + x)), mask);
+    __m128i nrgba = _mm_loadu_si128((const __m128i*)(needlePtr + x));
+    sums = _mm_add_epi64(sums, _mm_sad_epu8(hrgba, nrgba));
+  }
+  uint64_t lanes[2];
+  _mm_storeu_si128((__m128i*)lanes, sums);
+  return (int64_t)(lanes[0] + lanes[1]) + rowDiffMaskedScalar(
+      haystackPtr + x, needlePtr + x, width - x, pixelMask);
+}
+
+SIMD_TARGET_AVX2 static int64_t rowDiffMaskedAvx2(
+    const uint32_t* haystackPtr, const uint32_t* needlePtr, int width,
+    uint32_t pixelMask) {
+  const __m256i mask = _mm256_set1_epi32((int)pixelMask);
+  __m256i sums = _mm256_setzero_si256();
+  int x = 0;
+  for (; x + 8 <= width; x += 8) {
+    __m256i hrgba = _mm256_and_si256(
+        _mm256_loadu_si256((const __m256i*)(haystackPtr + x)), mask);
+    __m256i nrgba = _mm256_loadu_si256((const __m256i*)(needlePtr + x));
+    sums = _mm256_add_epi64(sums, _mm256_sad_epu8(hrgba, nrgba));
+  }
+  uint64_t lanes[4];
+  _mm256_storeu_si256((__m256i*)lanes, sums);
+  return (int64_t)(lanes[0] + lanes[1] + lanes[2] + lanes[3]) +
+      rowDiffMaskedScalar(haystackPtr + x, needlePtr + x, width - x,
+          pixelMask);
+}
+
+SIMD_TARGET_SSE2 static int rowDiffThresholdSse2(const uint32_t* haystackPtr,
+    const uint32_t* needlePtr, int width, uint32_t low, uint32_t high) {
+  const __m128i lowVector = _mm_set1_epi32((int)low);
+  const __m128i highVector = _mm_set1_epi32((int)high);
+  const __m128i alphaMask = _mm_set1_epi32((int)kPixelAlphaMask);
+  int diff = 0;
+  int x = 0;
+  for (; x + 4 <= width; x += 4) {
+    __m128i hrgba = _mm_loadu_si128((const __m128i*)(haystackPtr + x));
+    __m128i nrgba = _mm_loadu_si128((const __m128i*)(needlePtr + x));
+    __m128i hAlpha = _mm_and_si128(
+        simdInRangeSse2(hrgba, lowVector, highVector), alphaMask);
+    __m128i nAlpha = _mm_and_si128(nrgba, alphaMask);
+    int sameMask = _mm_movemask_ps(
+        _mm_castsi128_ps(_mm_cmpeq_epi32(hAlpha, nAlpha)));
+    diff += 4 - __builtin_popcount(sameMask);
+  }
+  return diff + rowDiffThresholdScalar(haystackPtr + x, needlePtr + x,
+      width - x, low, high);
+}
+
+SIMD_TARGET_AVX2 static int rowDiffThresholdAvx2(const uint32_t* haystackPtr,
+    const uint32_t* needlePtr, int width, uint32_t low, uint32_t high) {
+  const __m256i lowVector = _mm256_set1_epi32((int)low);
+  const __m256i highVector = _mm256_set1_epi32((int)high);
+  const __m256i alphaMask = _mm256_set1_epi32((int)kPixelAlphaMask);
+  int diff = 0;
+  int x = 0;
+  for (; x + 8 <= width; x += 8) {
+    __m256i hrgba = _mm256_loadu_si256((const __m256i*)(haystackPtr + x));
+    __m256i nrgba = _mm256_loadu_si256((const __m256i*)(needlePtr + x));
+    __m256i hAlpha = _mm256_and_si256(
+        simdInRangeAvx2(hrgba, lowVector, highVector), alphaMask);
+    __m256i nAlpha = _mm256_and_si256(nrgba, alphaMask);
+    int sameMask = _mm256_movemask_ps(
+        _mm256_castsi256_ps(_mm256_cmpeq_epi32(hAlpha, nAlpha)));
+    diff += 8 - __builtin_popcount(sameMask);
+  }
+  return diff + rowDiffThresholdScalar(haystackPtr + x, needlePtr + x,
+      width - x, low, high);
+}
+
+#endif  // SIMD_X86
+
 // Accelerates RgbaCheckMaskedCrop.
-int GoRgbaCheckMaskedCrop(void* haystackBytes, void* needleBytes, int hayWidth,
-    int needleWidth, int needleHeight, int needleLeft, int needleTop,
-    uint32_t argbMask) {
-  uint32_t* haystackPtr = (uint32_t*)haystackBytes + needleTop * hayWidth +
-      needleLeft;
-  uint32_t* needlePtr = (uint32_t*)needleBytes;
-  int rowJump = hayWidth - needleWidth;
+// The strides are measured in pixels.
+int GoRgbaCheckMaskedCrop(void* haystackBytes, void* needleBytes,
+    int hayStride, int needleStride, int needleWidth, int needleHeight,
+    int needleLeft, int needleTop, uint32_t pixelMask, int simdLevel) {
+  int (*rowCheck)(const uint32_t*, const uint32_t*, int, uint32_t) =
+      rowCheckMaskedScalar;
+#if SIMD_X86
+  if (simdLevel >= kSimdAvx2)
+    rowCheck = rowCheckMaskedAvx2;
+  else if (simdLevel >= kSimdSse2)
+    rowCheck = rowCheckMaskedSse2;
+#endif
+
+  const uint32_t* haystackRow = (const uint32_t*)haystackBytes +
+      needleTop * hayStride + needleLeft;
+  const uint32_t* needleRow = (const uint32_t*)needleBytes;
   for (int y = needleHeight; y > 0; --y) {
-    for (int x = needleWidth; x > 0; --x, ++needlePtr, ++haystackPtr) {
-      if ((*haystackPtr & argbMask) != *needlePtr)
-        return 0;
-    }
-    haystackPtr += rowJump;
+    if (!rowCheck(haystackRow, needleRow, needleWidth, pixelMask))
+      return 0;
+    haystackRow += hayStride;
+    needleRow += needleStride;
   }
   return 1;
 }
 
 // Accelerates RgbaDiffMaskedCrop.
+// The strides are measured in pixels.
 int64_t GoRgbaDiffMaskedCrop(void* haystackBytes, void* needleBytes,
-    int hayWidth, int needleWidth, int needleHeight, int needleLeft,
-    int needleTop, uint32_t argbMask) {
-  uint32_t* haystackPtr = (uint32_t*)haystackBytes + needleTop * hayWidth +
-      needleLeft;
-  uint32_t* needlePtr = (uint32_t*)needleBytes;
-  int rowJump = hayWidth - needleWidth;
+    int hayStride, int needleStride, int needleWidth, int needleHeight,
+    int needleLeft, int needleTop, uint32_t pixelMask, int simdLevel) {
+  int64_t (*rowDiff)(const uint32_t*, const uint32_t*, int, uint32_t) =
+      rowDiffMaskedScalar;
+#if SIMD_X86
+  if (simdLevel >= kSimdAvx2)
+    rowDiff = rowDiffMaskedAvx2;
+  else if (simdLevel >= kSimdSse2)
+    rowDiff = rowDiffMaskedSse2;
+#endif
+
+  const uint32_t* haystackRow = (const uint32_t*)haystackBytes +
+      needleTop * hayStride + needleLeft;
+  const uint32_t* needleRow = (const uint32_t*)needleBytes;
   int64_t diff = 0;
   for (int y = needleHeight; y > 0; --y) {
-    for (int x = needleWidth; x > 0; --x, ++needlePtr, ++haystackPtr) {
-      uint32_t hrgba = (*haystackPtr & argbMask);
-      uint32_t nrgba = *needlePtr;
-      int hchannel, nchannel;
-
-      // Red.
-      hchannel = hrgba & 0xff; hrgba >>= 8;
-      nchannel = nrgba & 0xff; nrgba >>= 8;
-      diff += (hchannel >= nchannel) ?
-          hchannel - nchannel : nchannel - hchannel;
-
-      // Green.
-      hchannel = hrgba & 0xff; hrgba >>= 8;
-      nchannel = nrgba & 0xff; nrgba >>= 8;
-      diff += (hchannel >= nchannel) ?
-          hchannel - nchannel : nchannel - hchannel;
-
-      // Blue.
-      hchannel = hrgba & 0xff; hrgba >>= 8;
-      nchannel = nrgba & 0xff; nrgba >>= 8;
-      diff += (hchannel >= nchannel) ?
-          hchannel - nchannel : nchannel - hchannel;
-
-      // Alpha.
-      hchannel = hrgba;
-      nchannel = nrgba;
-      diff += (hchannel >= nchannel) ?
-          hchannel - nchannel : nchannel - hchannel;
-    }
-    haystackPtr += rowJump;
+    diff += rowDiff(haystackRow, needleRow, needleWidth, pixelMask);
+    haystackRow += hayStride;
+    needleRow += needleStride;
   }
   return diff;
 }
 
 // Accelerates RgbaDiffThresholdCrop.
+// The strides are measured in pixels.
 int GoRgbaDiffThresholdCrop(void* haystackBytes, void* needleBytes,
-    int hayWidth, int needleWidth, int needleHeight, int needleLeft,
-    int needleTop,  uint8_t minR, uint8_t minG, uint8_t minB, uint8_t maxR,
-    uint8_t maxG, uint8_t maxB) {
-  uint32_t* haystackPtr = (uint32_t*)haystackBytes + needleTop * hayWidth +
-      needleLeft;
-  uint32_t* needlePtr = (uint32_t*)needleBytes;
-  int rowJump = hayWidth - needleWidth;
+    int hayStride, int needleStride, int needleWidth, int needleHeight,
+    int needleLeft, int needleTop,  uint8_t minR, uint8_t minG, uint8_t minB,
+    uint8_t maxR, uint8_t maxG, uint8_t maxB, int simdLevel) {
+  int (*rowDiff)(const uint32_t*, const uint32_t*, int, uint32_t, uint32_t) =
+      rowDiffThresholdScalar;
+#if SIMD_X86
+  if (simdLevel >= kSimdAvx2)
+    rowDiff = rowDiffThresholdAvx2;
+  else if (simdLevel >= kSimdSse2)
+    rowDiff = rowDiffThresholdSse2;
+#endif
+
+  // The alpha channel bounds are set so that they always pass.
+  uint32_t low = pixelPack(minR, minG, minB, 0);
+  uint32_t high = pixelPack(maxR, maxG, maxB, 0xff);
+  const uint32_t* haystackRow = (const uint32_t*)haystackBytes +
+      needleTop * hayStride + needleLeft;
+  const uint32_t* needleRow = (const uint32_t*)needleBytes;
   int diff = 0;
   for (int y = needleHeight; y > 0; --y) {
-    for (int x = needleWidth; x > 0; --x, ++needlePtr, ++haystackPtr) {
-      uint32_t hrgba = *haystackPtr;
-      uint8_t hr = hrgba & 0xff; hrgba >>= 8;
-      uint8_t hg = hrgba & 0xff; hrgba >>= 8;
-      uint8_t hb = hrgba & 0xff;
-      uint8_t ha = (hr >= minR && hg >= minG && hb >= minB &&
-        hr <= maxR && hg <= maxG && hb <= maxB) ? 0xff : 0;
-
-      uint32_t nrgba = *needlePtr;
-      uint8_t nr = nrgba & 0xff; nrgba >>= 8;
-      uint8_t ng = nrgba & 0xff; nrgba >>= 8;
-      uint8_t nb = nrgba & 0xff; nrgba >>= 8;
-      uint8_t na = nrgba;
-
-      if (ha != na)
-        diff += 1;
-    }
-    haystackPtr += rowJump;
+    diff += rowDiff(haystackRow, needleRow, needleWidth, low, high);
+    haystackRow += hayStride;
+    needleRow += needleStride;
   }
   return diff;
 }
 
-
-
 // (a * b) % m
 static inline uint32_t mulMod(uint32_t a, uint32_t b, uint32_t m) {
   return (uint32_t)(((uint64_t)a * b) % m);
@@ -138,30 +303,63 @@ static const uint32_t ky = 1000000007;
 // Hash modulo.
 static const uint32_t m = 2000000011;
 
-// Accelerates RabinKarpHash.
-// This doesn't really need accelerating, but it's easier to just reuse the
-// code in GoRabinKarp below and keep it in sync than to rewrite the whole
-// thing in Go.
-uint32_t GoHashForRgbaFindCrop(void *needleBytes, int needleWidth,
-    int needleHeight) {
+// The second set of constants used by the 64-bit hashes.
+// The 64-bit hashes combine the 32-bit hash above with a hash that uses a
+// different modulus, so windows that collide on both hashes are very rare.
+static const uint32_t kx2 = 911382323;
+static const uint32_t ky2 = 972663749;
+static const uint32_t m2 = 2147483647;
+
+// Computes the Rabin-Karp hash of a masked needle, with the given constants.
+// The stride is measured in pixels.
+static uint32_t rabinKarpHash(const uint32_t* needlePixels, int needleWidth,
+    int needleHeight, int needleStride, uint32_t pixelMask, uint32_t hashKx,
+    uint32_t hashKy, uint32_t hashM) {
   uint32_t hash = 0;
   for (int x = 0; x < needleWidth; ++x) {
-    uint32_t* column = (uint32_t*)needleBytes + x;
+    const uint32_t* column = needlePixels + x;
     uint32_t chash = 0;
     for (int y = 0; y < needleHeight; ++y) {
-      chash = mulModAdd(chash, ky, *column, m);
-      column += needleWidth;
+      chash = mulModAdd(chash, hashKy, pixelCanonical(*column & pixelMask),
+          hashM);
+      column += needleStride;
     }
-    hash = mulModAdd(hash, kx, chash, m);
+    hash = mulModAdd(hash, hashKx, chash, hashM);
   }
   return hash;
 }
 
+// Accelerates HashForRgbaFindCrop and HashForRgbaFindMaskedCrop.
+// This doesn't really need accelerating, but it's easier to just reuse the
+// code in GoRabinKarp below and keep it in sync than to rewrite the whole
+// thing in Go.
+// The stride is measured in pixels.
+uint32_t GoHashForRgbaFindCrop(void *needleBytes, int needleWidth,
+    int needleHeight, int needleStride, uint32_t pixelMask) {
+  return rabinKarpHash((const uint32_t*)needleBytes, needleWidth,
+      needleHeight, needleStride, pixelMask, kx, ky, m);
+}
+
+// Accelerates HashForRgbaFindCrop64 and HashForRgbaFindMaskedCrop64.
+// The hash's top half is the hash computed by GoHashForRgbaFindCrop.
+// The stride is measured in pixels.
+uint64_t GoHashForRgbaFindCrop64(void *needleBytes, int needleWidth,
+    int needleHeight, int needleStride, uint32_t pixelMask) {
+  const uint32_t* needlePixels = (const uint32_t*)needleBytes;
+  uint64_t hash1 = rabinKarpHash(needlePixels, needleWidth, needleHeight,
+      needleStride, pixelMask, kx, ky, m);
+  uint64_t hash2 = rabinKarpHash(needlePixels, needleWidth, needleHeight,
+      needleStride, pixelMask, kx2, ky2, m2);
+  return (hash1 << 32) | hash2;
+}
+
 // Accelerates RgbaFindCrop.
-// The scratch space must point to a buffer of worldWidth uint32_t elements.
+// The scratch space must point to a buffer of hayWidth uint32_t elements. The
+// strides are measured in pixels.
 int GoRgbaFindCrop(void* haystackBytes, void *needleBytes, int hayWidth,
-    int hayHeight, int needleWidth, int needleHeight, uint32_t needleHash,
-    void* scratch, int* matchLeft, int* matchTop) {
+    int hayHeight, int hayStride, int needleWidth, int needleHeight,
+    int needleStride, uint32_t needleHash, void* scratch, int* matchLeft,
+    int* matchTop) {
   uint32_t* hayPixels = (uint32_t*)haystackBytes;
   uint32_t* chash = (uint32_t*)scratch;  // column hashes
 
@@ -176,9 +374,9 @@ int GoRgbaFindCrop(void* haystackBytes, void *needleBytes, int hayWidth,
 
   memset(chash, 0, sizeof(uint32_t) * hayWidth);
   for (int y = 0; y < needleHeight; ++y) {
-    uint32_t* row = &hayPixels[y * hayWidth];
+    uint32_t* row = &hayPixels[y * hayStride];
     for (int x = 0; x < hayWidth; ++x) {
-      chash[x] = mulModAdd(chash[x], ky, row[x], m);
+      chash[x] = mulModAdd(chash[x], ky, pixelCanonical(row[x]), m);
     }
   }
 
@@ -194,8 +392,8 @@ int GoRgbaFindCrop(void* haystackBytes, void *needleBytes, int hayWidth,
     if (hash == needleHash) {
       int needleLeft = 0;
       int needleTop = 0;
-      if (GoRgbaCheckCrop(haystackBytes, needleBytes, hayWidth, needleWidth,
-            needleHeight, needleLeft, needleTop)) {
+      if (GoRgbaCheckCrop(haystackBytes, needleBytes, hayStride,
+            needleStride, needleWidth, needleHeight, needleLeft, needleTop)) {
         matchCount += 1;
         *matchLeft = needleLeft;
         *matchTop = needleTop;
@@ -209,8 +407,8 @@ int GoRgbaFindCrop(void* haystackBytes, void *needleBytes, int hayWidth,
       if (hash == needleHash) {
         int needleLeft = x - needleWidth + 1;
         int needleTop = 0;
-        if (GoRgbaCheckCrop(haystackBytes, needleBytes, hayWidth, needleWidth,
-              needleHeight, needleLeft, needleTop)) {
+        if (GoRgbaCheckCrop(haystackBytes, needleBytes, hayStride,
+              needleStride, needleWidth, needleHeight, needleLeft, needleTop)) {
           matchCount += 1;
           *matchLeft = needleLeft;
           *matchTop = needleTop;
@@ -220,12 +418,13 @@ int GoRgbaFindCrop(void* haystackBytes, void *needleBytes, int hayWidth,
   }
 
   for (int y = needleHeight; y < hayHeight; ++y) {
-    uint32_t* row = &hayPixels[y * hayWidth];
-    uint32_t* oldRow = &hayPixels[(y - needleHeight) * hayWidth];
+    uint32_t* row = &hayPixels[y * hayStride];
+    uint32_t* oldRow = &hayPixels[(y - needleHeight) * hayStride];
     hash = 0;
     for (int x = 0; x < needleWidth; ++x) {
-      chash[x] = mulModAdd(chash[x], ky, row[x], m);
-      chash[x] = modSub(chash[x], mulMod((uint64_t)oldRow[x], ky_h, m), m);
+      chash[x] = mulModAdd(chash[x], ky, pixelCanonical(row[x]), m);
+      chash[x] = modSub(chash[x],
+          mulMod(pixelCanonical(oldRow[x]), ky_h, m), m);
 
       hash = mulModAdd(hash, kx, chash[x], m);
     }
@@ -233,8 +432,8 @@ int GoRgbaFindCrop(void* haystackBytes, void *needleBytes, int hayWidth,
     if (hash == needleHash) {
       int needleLeft = 0;
       int needleTop = y - needleHeight + 1;
-      if (GoRgbaCheckCrop(haystackBytes, needleBytes, hayWidth, needleWidth,
-            needleHeight, needleLeft, needleTop)) {
+      if (GoRgbaCheckCrop(haystackBytes, needleBytes, hayStride,
+            needleStride, needleWidth, needleHeight, needleLeft, needleTop)) {
         matchCount += 1;
         *matchLeft = needleLeft;
         *matchTop = needleTop;
@@ -242,16 +441,17 @@ int GoRgbaFindCrop(void* haystackBytes, void *needleBytes, int hayWidth,
     }
 
     for (int x = needleWidth; x < hayWidth; ++x) {
-      chash[x] = mulModAdd(chash[x], ky, row[x], m);
-      chash[x] = modSub(chash[x], mulMod((uint64_t)oldRow[x], ky_h, m), m);
+      chash[x] = mulModAdd(chash[x], ky, pixelCanonical(row[x]), m);
+      chash[x] = modSub(chash[x],
+          mulMod(pixelCanonical(oldRow[x]), ky_h, m), m);
 
       hash = mulModAdd(hash, kx, chash[x], m);
       hash = modSub(hash, mulMod(chash[x - needleWidth], kx_w, m), m);
       if (hash == needleHash) {
         int needleLeft = x - needleWidth + 1;
         int needleTop = y - needleHeight + 1;
-        if (GoRgbaCheckCrop(haystackBytes, needleBytes, hayWidth, needleWidth,
-              needleHeight, needleLeft, needleTop)) {
+        if (GoRgbaCheckCrop(haystackBytes, needleBytes, hayStride,
+              needleStride, needleWidth, needleHeight, needleLeft, needleTop)) {
           matchCount += 1;
           *matchLeft = needleLeft;
           *matchTop = needleTop;
@@ -264,10 +464,12 @@ int GoRgbaFindCrop(void* haystackBytes, void *needleBytes, int hayWidth,
 }
 
 // Accelerates RgbaFindMaskedCrop.
-// The scratch space must point to a buffer of worldWidth uint32_t elements.
+// The scratch space must point to a buffer of hayWidth uint32_t elements. The
+// strides are measured in pixels.
 int GoRgbaFindMaskedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
-    int hayHeight, int needleWidth, int needleHeight, uint32_t argbMask,
-    uint32_t needleHash, void* scratch, int* matchLeft, int* matchTop) {
+    int hayHeight, int hayStride, int needleWidth, int needleHeight,
+    int needleStride, uint32_t pixelMask, uint32_t needleHash, void* scratch,
+    int* matchLeft, int* matchTop, int simdLevel) {
   uint32_t* hayPixels = (uint32_t*)haystackBytes;
   uint32_t* chash = (uint32_t*)scratch;  // column hashes
 
@@ -282,9 +484,10 @@ int GoRgbaFindMaskedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
 
   memset(chash, 0, sizeof(uint32_t) * hayWidth);
   for (int y = 0; y < needleHeight; ++y) {
-    uint32_t* row = &hayPixels[y * hayWidth];
+    uint32_t* row = &hayPixels[y * hayStride];
     for (int x = 0; x < hayWidth; ++x) {
-      chash[x] = mulModAdd(chash[x], ky, row[x] & argbMask, m);
+      chash[x] = mulModAdd(chash[x], ky,
+          pixelCanonical(row[x] & pixelMask), m);
     }
   }
 
@@ -300,8 +503,9 @@ int GoRgbaFindMaskedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
     if (hash == needleHash) {
       int needleLeft = 0;
       int needleTop = 0;
-      if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayWidth,
-            needleWidth, needleHeight, needleLeft, needleTop, argbMask)) {
+      if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayStride,
+            needleStride, needleWidth, needleHeight, needleLeft, needleTop,
+            pixelMask, simdLevel)) {
         matchCount += 1;
         *matchLeft = needleLeft;
         *matchTop = needleTop;
@@ -315,8 +519,9 @@ int GoRgbaFindMaskedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
       if (hash == needleHash) {
         int needleLeft = x - needleWidth + 1;
         int needleTop = 0;
-        if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayWidth,
-              needleWidth, needleHeight, needleLeft, needleTop, argbMask)) {
+        if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayStride,
+              needleStride, needleWidth, needleHeight, needleLeft, needleTop,
+              pixelMask, simdLevel)) {
           matchCount += 1;
           *matchLeft = needleLeft;
           *matchTop = needleTop;
@@ -326,13 +531,14 @@ int GoRgbaFindMaskedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
   }
 
   for (int y = needleHeight; y < hayHeight; ++y) {
-    uint32_t* row = &hayPixels[y * hayWidth];
-    uint32_t* oldRow = &hayPixels[(y - needleHeight) * hayWidth];
+    uint32_t* row = &hayPixels[y * hayStride];
+    uint32_t* oldRow = &hayPixels[(y - needleHeight) * hayStride];
     hash = 0;
     for (int x = 0; x < needleWidth; ++x) {
-      chash[x] = mulModAdd(chash[x], ky, row[x] & argbMask, m);
+      chash[x] = mulModAdd(chash[x], ky,
+          pixelCanonical(row[x] & pixelMask), m);
       chash[x] = modSub(chash[x],
-          mulMod((uint64_t)oldRow[x] & argbMask, ky_h, m), m);
+          mulMod(pixelCanonical(oldRow[x] & pixelMask), ky_h, m), m);
 
       hash = mulModAdd(hash, kx, chash[x], m);
     }
@@ -340,8 +546,9 @@ int GoRgbaFindMaskedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
     if (hash == needleHash) {
       int needleLeft = 0;
       int needleTop = y - needleHeight + 1;
-      if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayWidth,
-            needleWidth, needleHeight, needleLeft, needleTop, argbMask)) {
+      if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayStride,
+            needleStride, needleWidth, needleHeight, needleLeft, needleTop,
+            pixelMask, simdLevel)) {
         matchCount += 1;
         *matchLeft = needleLeft;
         *matchTop = needleTop;
@@ -349,17 +556,19 @@ int GoRgbaFindMaskedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
     }
 
     for (int x = needleWidth; x < hayWidth; ++x) {
-      chash[x] = mulModAdd(chash[x], ky, row[x] & argbMask, m);
+      chash[x] = mulModAdd(chash[x], ky,
+          pixelCanonical(row[x] & pixelMask), m);
       chash[x] = modSub(chash[x],
-          mulMod((uint64_t)oldRow[x] & argbMask, ky_h, m), m);
+          mulMod(pixelCanonical(oldRow[x] & pixelMask), ky_h, m), m);
 
       hash = mulModAdd(hash, kx, chash[x], m);
       hash = modSub(hash, mulMod(chash[x - needleWidth], kx_w, m), m);
       if (hash == needleHash) {
         int needleLeft = x - needleWidth + 1;
         int needleTop = y - needleHeight + 1;
-        if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayWidth,
-              needleWidth, needleHeight, needleLeft, needleTop, argbMask)) {
+        if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayStride,
+              needleStride, needleWidth, needleHeight, needleLeft, needleTop,
+              pixelMask, simdLevel)) {
           matchCount += 1;
           *matchLeft = needleLeft;
           *matchTop = needleTop;
@@ -370,3 +579,373 @@ int GoRgbaFindMaskedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
 
   return matchCount;
 }
+
+// Returns the index of the first element in a sorted array that is not less
+// than a value. Returns the array's size if all elements are less than the
+// value.
+static inline int lowerBound(const uint32_t* sorted, int count,
+    uint32_t value) {
+  int low = 0, high = count;
+  while (low < high) {
+    int middle = low + ((high - low) >> 1);
+    if (sorted[middle] < value)
+      low = middle + 1;
+    else
+      high = middle;
+  }
+  return low;
+}
+
+// Accelerates MultiFinder.
+// The needles all have the same size, and are stored one after another in
+// needleBytes. needleHashes holds the needles' hashes, sorted in ascending
+// order. The scratch space must point to a buffer of hayWidth uint32_t
+// elements. The stride is measured in pixels.
+// Each match is written to matchBytes as 3 int32_t values: the needle's index,
+// and the left and top coordinates of the match. At most maxMatches matches
+// are written. Returns the total number of matches, which can exceed
+// maxMatches.
+int GoRgbaFindCrops(void* haystackBytes, int hayWidth, int hayHeight,
+    int hayStride, void* needleBytes, int needleWidth, int needleHeight,
+    int needleCount, void* needleHashes, void* scratch, void* matchBytes,
+    int maxMatches) {
+  uint32_t* hayPixels = (uint32_t*)haystackBytes;
+  uint32_t* needlePixels = (uint32_t*)needleBytes;
+  const uint32_t* hashes = (const uint32_t*)needleHashes;
+  uint32_t* chash = (uint32_t*)scratch;  // column hashes
+  int32_t* matches = (int32_t*)matchBytes;
+  int needleSize = needleWidth * needleHeight;
+
+  uint32_t kx_w = 1;  // kx ^ w % m
+  for (int x = 0; x < needleWidth; ++x) {
+    kx_w = mulMod(kx_w, kx, m);
+  }
+  uint32_t ky_h = 1;  // ky ^ h % m
+  for (int y = 0; y < needleHeight; ++y) {
+    ky_h = mulMod(ky_h, ky, m);
+  }
+
+  memset(chash, 0, sizeof(uint32_t) * hayWidth);
+  for (int y = 0; y < needleHeight; ++y) {
+    uint32_t* row = &hayPixels[y * hayStride];
+    for (int x = 0; x < hayWidth; ++x) {
+      chash[x] = mulModAdd(chash[x], ky, pixelCanonical(row[x]), m);
+    }
+  }
+
+  int matchCount = 0;
+  for (int top = 0; top + needleHeight <= hayHeight; ++top) {
+    if (top > 0) {
+      // Roll the column hashes down by one row.
+      uint32_t* row = &hayPixels[(top + needleHeight - 1) * hayStride];
+      uint32_t* oldRow = &hayPixels[(top - 1) * hayStride];
+      for (int x = 0; x < hayWidth; ++x) {
+        chash[x] = mulModAdd(chash[x], ky, pixelCanonical(row[x]), m);
+        chash[x] = modSub(chash[x],
+            mulMod(pixelCanonical(oldRow[x]), ky_h, m), m);
+      }
+    }
+
+    uint32_t hash = 0;
+    for (int x = 0; x < hayWidth; ++x) {
+      hash = mulModAdd(hash, kx, chash[x], m);
+      if (x >= needleWidth)
+        hash = modSub(hash, mulMod(chash[x - needleWidth], kx_w, m), m);
+      if (x + 1 < needleWidth)
+        continue;
+
+      int left = x - needleWidth + 1;
+      for (int i = lowerBound(hashes, needleCount, hash);
+           i < needleCount && hashes[i] == hash; ++i) {
+        if (!GoRgbaCheckCrop(haystackBytes, needlePixels + i * needleSize,
+              hayStride, needleWidth, needleWidth, needleHeight, left, top)) {
+          continue;
+        }
+        if (matchCount < maxMatches) {
+          int32_t* match = matches + 3 * matchCount;
+          match[0] = i;
+          match[1] = left;
+          match[2] = top;
+        }
+        ++matchCount;
+      }
+    }
+  }
+  return matchCount;
+}
+
+// Accelerates RgbaFindCrop64 and RgbaFindMaskedCrop64.
+// This is GoRgbaFindMaskedCrop with two rolling hashes. Unmasked searches use
+// a mask with all bits set. The scratch space must point to a buffer of
+// 2 * hayWidth uint32_t elements. The strides are measured in pixels.
+int GoRgbaFindCrop64(void* haystackBytes, void *needleBytes, int hayWidth,
+    int hayHeight, int hayStride, int needleWidth, int needleHeight,
+    int needleStride, uint32_t pixelMask, uint64_t needleHash, void* scratch,
+    int* matchLeft, int* matchTop, int simdLevel) {
+  uint32_t* hayPixels = (uint32_t*)haystackBytes;
+  uint32_t* chash1 = (uint32_t*)scratch;  // column hashes
+  uint32_t* chash2 = chash1 + hayWidth;
+  uint32_t needleHash1 = (uint32_t)(needleHash >> 32);
+  uint32_t needleHash2 = (uint32_t)needleHash;
+
+  uint32_t kx_w = 1, kx2_w = 1;  // kx ^ w % m
+  for (int x = 0; x < needleWidth; ++x) {
+    kx_w = mulMod(kx_w, kx, m);
+    kx2_w = mulMod(kx2_w, kx2, m2);
+  }
+  uint32_t ky_h = 1, ky2_h = 1;  // ky ^ h % m
+  for (int y = 0; y < needleHeight; ++y) {
+    ky_h = mulMod(ky_h, ky, m);
+    ky2_h = mulMod(ky2_h, ky2, m2);
+  }
+
+  memset(chash1, 0, sizeof(uint32_t) * 2 * hayWidth);
+  for (int y = 0; y < needleHeight; ++y) {
+    uint32_t* row = &hayPixels[y * hayStride];
+    for (int x = 0; x < hayWidth; ++x) {
+      uint32_t pixel = pixelCanonical(row[x] & pixelMask);
+      chash1[x] = mulModAdd(chash1[x], ky, pixel, m);
+      chash2[x] = mulModAdd(chash2[x], ky2, pixel, m2);
+    }
+  }
+
+  int matchCount = 0;
+  for (int top = 0; top + needleHeight <= hayHeight; ++top) {
+    if (top > 0) {
+      // Roll the column hashes down by one row.
+      uint32_t* row = &hayPixels[(top + needleHeight - 1) * hayStride];
+      uint32_t* oldRow = &hayPixels[(top - 1) * hayStride];
+      for (int x = 0; x < hayWidth; ++x) {
+        uint32_t pixel = pixelCanonical(row[x] & pixelMask);
+        uint32_t oldPixel = pixelCanonical(oldRow[x] & pixelMask);
+        chash1[x] = modSub(mulModAdd(chash1[x], ky, pixel, m),
+            mulMod(oldPixel, ky_h, m), m);
+        chash2[x] = modSub(mulModAdd(chash2[x], ky2, pixel, m2),
+            mulMod(oldPixel, ky2_h, m2), m2);
+      }
+    }
+
+    uint32_t hash1 = 0, hash2 = 0;
+    for (int x = 0; x < hayWidth; ++x) {
+      hash1 = mulModAdd(hash1, kx, chash1[x], m);
+      hash2 = mulModAdd(hash2, kx2, chash2[x], m2);
+      if (x >= needleWidth) {
+        hash1 = modSub(hash1, mulMod(chash1[x - needleWidth], kx_w, m), m);
+        hash2 = modSub(hash2, mulMod(chash2[x - needleWidth], kx2_w, m2),
+            m2);
+      }
+      if (x + 1 < needleWidth || hash1 != needleHash1 ||
+          hash2 != needleHash2) {
+        continue;
+      }
+
+      int needleLeft = x - needleWidth + 1;
+      if (GoRgbaCheckMaskedCrop(haystackBytes, needleBytes, hayStride,
+            needleStride, needleWidth, needleHeight, needleLeft, top,
+            pixelMask, simdLevel)) {
+        matchCount += 1;
+        *matchLeft = needleLeft;
+        *matchTop = top;
+      }
+    }
+  }
+  return matchCount;
+}
+
+// Returns 1 if a haystack row matches the keyed pixels in a needle row.
+// Keyed pixels are the needle pixels whose alpha is at least the cutoff. Only
+// their color channels are compared.
+static int rowCheckKeyed(const uint32_t* haystackPtr,
+    const uint32_t* needlePtr, int width, int alphaCutoff) {
+  for (int x = width; x > 0; --x, ++needlePtr, ++haystackPtr) {
+    uint32_t nrgba = *needlePtr;
+    if (pixelAlpha(nrgba) >= alphaCutoff &&
+        ((*haystackPtr ^ nrgba) & ~kPixelAlphaMask) != 0)
+      return 0;
+  }
+  return 1;
+}
+
+// Accelerates RgbaCheckKeyedCrop.
+// The strides are measured in pixels.
+int GoRgbaCheckKeyedCrop(void* haystackBytes, void* needleBytes,
+    int hayStride, int needleStride, int needleWidth, int needleHeight,
+    int needleLeft, int needleTop, int alphaCutoff) {
+  const uint32_t* haystackRow = (const uint32_t*)haystackBytes +
+      needleTop * hayStride + needleLeft;
+  const uint32_t* needleRow = (const uint32_t*)needleBytes;
+  for (int y = needleHeight; y > 0; --y) {
+    if (!rowCheckKeyed(haystackRow, needleRow, needleWidth, alphaCutoff))
+      return 0;
+    haystackRow += hayStride;
+    needleRow += needleStride;
+  }
+  return 1;
+}
+
+// Accelerates RgbaDiffKeyedCrop.
+// The strides are measured in pixels.
+int64_t GoRgbaDiffKeyedCrop(void* haystackBytes, void* needleBytes,
+    int hayStride, int needleStride, int needleWidth, int needleHeight,
+    int needleLeft, int needleTop, int alphaCutoff) {
+  const uint32_t* haystackRow = (const uint32_t*)haystackBytes +
+      needleTop * hayStride + needleLeft;
+  const uint32_t* needleRow = (const uint32_t*)needleBytes;
+  int64_t diff = 0;
+  for (int y = needleHeight; y > 0; --y) {
+    for (int x = 0; x < needleWidth; ++x) {
+      uint32_t hrgba = haystackRow[x];
+      uint32_t nrgba = needleRow[x];
+      if (pixelAlpha(nrgba) < alphaCutoff)
+        continue;
+      diff += channelDiff(pixelRed(hrgba), pixelRed(nrgba));
+      diff += channelDiff(pixelGreen(hrgba), pixelGreen(nrgba));
+      diff += channelDiff(pixelBlue(hrgba), pixelBlue(nrgba));
+    }
+    haystackRow += hayStride;
+    needleRow += needleStride;
+  }
+  return diff;
+}
+
+// Accelerates RgbaFindKeyedCrop.
+// The rolling hash only covers the needle's anchor, which is a rectangle of
+// keyed pixels. The anchor hash is computed over the anchor's color channels.
+// Windows whose hash matches are verified against all the keyed pixels. The
+// scratch space must point to a buffer of hayWidth uint32_t elements. The
+// strides are measured in pixels.
+int GoRgbaFindKeyedCrop(void* haystackBytes, void *needleBytes, int hayWidth,
+    int hayHeight, int hayStride, int needleWidth, int needleHeight,
+    int needleStride, int anchorLeft, int anchorTop, int anchorWidth,
+    int anchorHeight, uint32_t anchorHash, int alphaCutoff, void* scratch,
+    int* matchLeft, int* matchTop) {
+  // The anchor is scanned over the part of the haystack where it can appear
+  // while the whole needle fits in the haystack. A window at (x, y) in this
+  // region corresponds to a needle at (x, y) in the haystack.
+  uint32_t* regionPixels = (uint32_t*)haystackBytes + anchorTop * hayStride +
+      anchorLeft;
+  int regionWidth = hayWidth - needleWidth + anchorWidth;
+  int regionHeight = hayHeight - needleHeight + anchorHeight;
+  uint32_t* chash = (uint32_t*)scratch;  // column hashes
+  const uint32_t pixelMask = ~kPixelAlphaMask;
+
+  uint32_t kx_w = 1;  // kx ^ w % m
+  for (int x = 0; x < anchorWidth; ++x) {
+    kx_w = mulMod(kx_w, kx, m);
+  }
+  uint32_t ky_h = 1;  // ky ^ h % m
+  for (int y = 0; y < anchorHeight; ++y) {
+    ky_h = mulMod(ky_h, ky, m);
+  }
+
+  memset(chash, 0, sizeof(uint32_t) * regionWidth);
+  for (int y = 0; y < anchorHeight; ++y) {
+    uint32_t* row = &regionPixels[y * hayStride];
+    for (int x = 0; x < regionWidth; ++x) {
+      chash[x] = mulModAdd(chash[x], ky, pixelCanonical(row[x] & pixelMask),
+          m);
+    }
+  }
+
+  int matchCount = 0;
+  for (int top = 0; top + anchorHeight <= regionHeight; ++top) {
+    if (top > 0) {
+      // Roll the column hashes down by one row.
+      uint32_t* row = &regionPixels[(top + anchorHeight - 1) * hayStride];
+      uint32_t* oldRow = &regionPixels[(top - 1) * hayStride];
+      for (int x = 0; x < regionWidth; ++x) {
+        chash[x] = modSub(
+            mulModAdd(chash[x], ky, pixelCanonical(row[x] & pixelMask), m),
+            mulMod(pixelCanonical(oldRow[x] & pixelMask), ky_h, m), m);
+      }
+    }
+
+    uint32_t hash = 0;
+    for (int x = 0; x < regionWidth; ++x) {
+      hash = mulModAdd(hash, kx, chash[x], m);
+      if (x >= anchorWidth)
+        hash = modSub(hash, mulMod(chash[x - anchorWidth], kx_w, m), m);
+      if (x + 1 < anchorWidth || hash != anchorHash)
+        continue;
+
+      int needleLeft = x - anchorWidth + 1;
+      if (GoRgbaCheckKeyedCrop(haystackBytes, needleBytes, hayStride,
+            needleStride, needleWidth, needleHeight, needleLeft, top,
+            alphaCutoff)) {
+        matchCount += 1;
+        *matchLeft = needleLeft;
+        *matchTop = top;
+      }
+    }
+  }
+  return matchCount;
+}
+
+// Accelerates RgbaFindBestCrop.
+// Every needle placement is scored with GoRgbaDiffMaskedCrop, and the best
+// placements are kept in the matches, sorted by their diffs. A placement is
+// dropped if a kept placement that overlaps it is at least as good, and it
+// replaces the kept placements that it overlaps otherwise. Ties are broken in
+// scan order. The matches are stored as (left, top) pairs in matchPositions,
+// and their diffs are stored in matchDiffs. The strides are measured in
+// pixels.
+int GoRgbaFindBestCrop(void* haystackBytes, void* needleBytes, int hayWidth,
+    int hayHeight, int hayStride, int needleWidth, int needleHeight,
+    int needleStride, uint32_t pixelMask, int32_t* matchPositions,
+    int64_t* matchDiffs, int maxMatches, int simdLevel) {
+  int placeWidth = hayWidth - needleWidth + 1;
+  int placeHeight = hayHeight - needleHeight + 1;
+
+  int matchCount = 0;
+  for (int top = 0; top < placeHeight; ++top) {
+    for (int left = 0; left < placeWidth; ++left) {
+      int64_t diff = GoRgbaDiffMaskedCrop(haystackBytes, needleBytes,
+          hayStride, needleStride, needleWidth, needleHeight, left, top,
+          pixelMask, simdLevel);
+      if (matchCount == maxMatches && diff >= matchDiffs[matchCount - 1])
+        continue;
+
+      int suppressed = 0;
+      for (int i = 0; i < matchCount; ++i) {
+        int dx = left - matchPositions[2 * i];
+        int dy = top - matchPositions[2 * i + 1];
+        if (dx > -needleWidth && dx < needleWidth && dy > -needleHeight &&
+            dy < needleHeight && matchDiffs[i] <= diff) {
+          suppressed = 1;
+          break;
+        }
+      }
+      if (suppressed)
+        continue;
+
+      // The remaining overlapping matches are worse than the placement.
+      int keptCount = 0;
+      for (int i = 0; i < matchCount; ++i) {
+        int dx = left - matchPositions[2 * i];
+        int dy = top - matchPositions[2 * i + 1];
+        if (dx > -needleWidth && dx < needleWidth && dy > -needleHeight &&
+            dy < needleHeight)
+          continue;
+        matchPositions[2 * keptCount] = matchPositions[2 * i];
+        matchPositions[2 * keptCount + 1] = matchPositions[2 * i + 1];
+        matchDiffs[keptCount] = matchDiffs[i];
+        keptCount += 1;
+      }
+      matchCount = keptCount;
+
+      // The worst match is dropped when the matches are full.
+      int slot = (matchCount < maxMatches) ? matchCount : maxMatches - 1;
+      for (; slot > 0 && matchDiffs[slot - 1] > diff; --slot) {
+        matchPositions[2 * slot] = matchPositions[2 * slot - 2];
+        matchPositions[2 * slot + 1] = matchPositions[2 * slot - 1];
+        matchDiffs[slot] = matchDiffs[slot - 1];
+      }
+      matchPositions[2 * slot] = left;
+      matchPositions[2 * slot + 1] = top;
+      matchDiffs[slot] = diff;
+      if (matchCount < maxMatches)
+        matchCount += 1;
+    }
+  }
+  return matchCount;
+}
@@ -1,12 +1,13 @@
 #include <memory.h>
 #include <stdint.h>
 
-#include <stdio.h>
+#include "pixel.h"
 
 // Accelerates RgbaFindPillars.
+// The stride is measured in pixels.
 void GoRgbaFindPillars(void* rgbaBytes, void* pillarBytes, int width,
-    int height, int pillarCount, uint8_t minR, uint8_t minG, uint8_t minB,
-    uint8_t maxR, uint8_t maxG, uint8_t maxB) {
+    int height, int stride, int pillarCount, uint8_t minR, uint8_t minG,
+    uint8_t minB, uint8_t maxR, uint8_t maxG, uint8_t maxB) {
 
   uint32_t *rgbaPixel = (uint32_t*)rgbaBytes;
   int *pillars = (int*)pillarBytes;
@@ -18,11 +19,11 @@ void GoRgbaFindPillars(void* rgbaBytes, void* pillarBytes, int width,
   for (int x = 0; x < width; ++x) {
     int pillarHeight = 0;
     uint32_t* rgbaPixel = (uint32_t*)rgbaBytes + x;
-    for (int y = 0; y < height; ++y, rgbaPixel += width) {
+    for (int y = 0; y < height; ++y, rgbaPixel += stride) {
       uint32_t rgba = *rgbaPixel;
-      uint8_t r = rgba & 0xff;
-      uint8_t g = (rgba >> 8) & 0xff;
-      uint8_t b = (rgba >> 16) & 0xff;
+      uint8_t r = pixelRed(rgba);
+      uint8_t g = pixelGreen(rgba);
+      uint8_t b = pixelBlue(rgba);
 
       if (r >= minR && g >= minG && b >= minB &&
           r <= maxR && g <= maxG && b <= maxB) {
@@ -51,24 +52,25 @@ void GoRgbaFindPillars(void* rgbaBytes, void* pillarBytes, int width,
 }
 
 // Accelerates GoRgbaFindPuddle.
+// The stride is measured in pixels.
 int GoRgbaFindPuddle(void* rgbaBytes, void* puddleBytes, int width,
-    int height, int startY, int maxPuddleSize,uint8_t minR,
+    int height, int stride, int startY, int maxPuddleSize,uint8_t minR,
     uint8_t minG, uint8_t minB, uint8_t maxR, uint8_t maxG, uint8_t maxB) {
   uint32_t* rgbaPixels = (uint32_t*)rgbaBytes;
 
-  uint32_t* rgbaPixel0 = rgbaPixels + width * startY;
   for (int y0 = startY; y0 < height; ++y0) {
+    uint32_t* rgbaPixel0 = rgbaPixels + stride * y0;
     for (int x0 = 0; x0 < width; ++x0, ++rgbaPixel0) {
       uint32_t rgba = *rgbaPixel0;
-      uint8_t r = rgba & 0xff;
-      uint8_t g = (rgba >> 8) & 0xff;
-      uint8_t b = (rgba >> 16) & 0xff;
+      uint8_t r = pixelRed(rgba);
+      uint8_t g = pixelGreen(rgba);
+      uint8_t b = pixelBlue(rgba);
       if (r < minR || g < minG || b < minB ||
           r > maxR || g > maxG || b > maxB) {
         continue;
       }
 
-      uint8_t a = (rgba >> 24) & 0xff;
+      uint8_t a = pixelAlpha(rgba);
       if (a == 0) {
         continue;
       }
@@ -78,7 +80,7 @@ int GoRgbaFindPuddle(void* rgbaBytes, void* puddleBytes, int width,
       puddleOut[0] = x0;
       puddleOut[1] = y0;
       puddleOut += 2;
-      *rgbaPixel0 &= 0x00ffffff;
+      *rgbaPixel0 &= ~kPixelAlphaMask;
       int puddleSize = 1;  // Found a puddle.
       if (puddleSize == maxPuddleSize) {
         return puddleSize;
@@ -94,16 +96,19 @@ int GoRgbaFindPuddle(void* rgbaBytes, void* puddleBytes, int width,
           for (int dy = -1; dy <= 1; ++dy) {
             int x = dx + x0;
             int y = dy + y0;
-            uint32_t *rgbaPixel = rgbaPixels + y * width + x;
+            if (x < 0 || x >= width || y < 0 || y >= height) {
+              continue;
+            }
+            uint32_t *rgbaPixel = rgbaPixels + y * stride + x;
             uint32_t rgba = *rgbaPixel;
-            uint8_t r = rgba & 0xff;
-            uint8_t g = (rgba >> 8) & 0xff;
-            uint8_t b = (rgba >> 16) & 0xff;
+            uint8_t r = pixelRed(rgba);
+            uint8_t g = pixelGreen(rgba);
+            uint8_t b = pixelBlue(rgba);
             if (r < minR || g < minG || b < minB ||
                 r > maxR || g > maxG || b > maxB) {
               continue;
             }
-            uint8_t a = (rgba >> 24) & 0xff;
+            uint8_t a = pixelAlpha(rgba);
             if (a == 0) {
               continue;
             }
@@ -111,7 +116,7 @@ int GoRgbaFindPuddle(void* rgbaBytes, void* puddleBytes, int width,
             puddleOut[0] = x;
             puddleOut[1] = y;
             puddleOut += 2;
-            *rgbaPixel = rgba & 0x00ffffff;
+            *rgbaPixel = rgba & ~kPixelAlphaMask;
             ++puddleSize;
             if (puddleSize == maxPuddleSize) {
               return puddleSize;
@@ -125,6 +130,33 @@ int GoRgbaFindPuddle(void* rgbaBytes, void* puddleBytes, int width,
   return 0;  // Did not find a puddle.
 }
 
+// Accelerates FindPuddleParallel.
+// Returns the first row between startY (inclusive) and endY (exclusive) that
+// contains a pixel where GoRgbaFindPuddle would start a puddle, or -1 if there
+// is no such row. The stride is measured in pixels.
+int GoRgbaFindPuddleRow(void* rgbaBytes, int width, int stride, int startY,
+    int endY, uint8_t minR, uint8_t minG, uint8_t minB, uint8_t maxR,
+    uint8_t maxG, uint8_t maxB) {
+  uint32_t* rgbaPixels = (uint32_t*)rgbaBytes;
+
+  for (int y = startY; y < endY; ++y) {
+    uint32_t* rgbaPixel = rgbaPixels + stride * y;
+    for (int x = 0; x < width; ++x, ++rgbaPixel) {
+      uint32_t rgba = *rgbaPixel;
+      uint8_t r = pixelRed(rgba);
+      uint8_t g = pixelGreen(rgba);
+      uint8_t b = pixelBlue(rgba);
+      if (r < minR || g < minG || b < minB ||
+          r > maxR || g > maxG || b > maxB) {
+        continue;
+      }
+      if (pixelAlpha(rgba) != 0)
+        return y;
+    }
+  }
+  return -1;
+}
+
 // Accelerates RgbaResetPuddles.
 void GoRgbaResetPuddles(void* rgbaBytes, int byteSize) {
   uint8_t* alphaPixel = (uint8_t*)rgbaBytes + 3;
@@ -0,0 +1,36 @@
+#include <stdint.h>
+
+#include "pixel.h"
+
+// Accelerates the thumbnails used by the perceptual hashes.
+// The image is split into thumbWidth x thumbHeight cells, and the gray values
+// of each cell's pixels are added up. Cell (cx, cy) covers the pixels with
+// x in [cx * width / thumbWidth, (cx + 1) * width / thumbWidth), and likewise
+// for y. Cells that would be empty because the image is smaller than the
+// thumbnail cover a single pixel instead. The sums are stored in row-major
+// order, and the number of pixels in each cell is stored in counts. The stride
+// is measured in pixels.
+void GoRgbaGrayThumbnail(void* rgbaBytes, int width, int height, int stride,
+    int thumbWidth, int thumbHeight, uint64_t* sums, uint32_t* counts) {
+  for (int cy = 0; cy < thumbHeight; ++cy) {
+    int minY = (int)((int64_t)cy * height / thumbHeight);
+    int maxY = (int)((int64_t)(cy + 1) * height / thumbHeight);
+    if (maxY <= minY)
+      maxY = minY + 1;
+    for (int cx = 0; cx < thumbWidth; ++cx) {
+      int minX = (int)((int64_t)cx * width / thumbWidth);
+      int maxX = (int)((int64_t)(cx + 1) * width / thumbWidth);
+      if (maxX <= minX)
+        maxX = minX + 1;
+
+      uint64_t sum = 0;
+      for (int y = minY; y < maxY; ++y) {
+        const uint32_t* row = (const uint32_t*)rgbaBytes + y * stride;
+        for (int x = minX; x < maxX; ++x)
+          sum += pixelGray(row[x]);
+      }
+      sums[cy * thumbWidth + cx] = sum;
+      counts[cy * thumbWidth + cx] = (uint32_t)((maxX - minX) * (maxY - minY));
+    }
+  }
+}
@@ -0,0 +1,67 @@
+#ifndef IMAGEUTIL_PIXEL_H_
+#define IMAGEUTIL_PIXEL_H_
+
+#include <stdint.h>
+
+// Packed pixels are RGBA pixels loaded from memory into an uint32_t.
+// The channels' positions inside a packed pixel depend on the host's byte
+// order, so kernels must use the helpers below instead of hard-coding shifts.
+// The Go code mirrors these helpers in pixel.go.
+
+#if defined(__BYTE_ORDER__) && __BYTE_ORDER__ == __ORDER_BIG_ENDIAN__
+#define PIXEL_RED_SHIFT 24
+#define PIXEL_GREEN_SHIFT 16
+#define PIXEL_BLUE_SHIFT 8
+#define PIXEL_ALPHA_SHIFT 0
+#else  // __BYTE_ORDER__ == __ORDER_LITTLE_ENDIAN__
+#define PIXEL_RED_SHIFT 0
+#define PIXEL_GREEN_SHIFT 8
+#define PIXEL_BLUE_SHIFT 16
+#define PIXEL_ALPHA_SHIFT 24
+#endif
+
+// Selects the alpha channel in a packed pixel.
+static const uint32_t kPixelAlphaMask = (uint32_t)0xff << PIXEL_ALPHA_SHIFT;
+
+static inline uint8_t pixelRed(uint32_t pixel) {
+  return (uint8_t)(pixel >> PIXEL_RED_SHIFT);
+}
+static inline uint8_t pixelGreen(uint32_t pixel) {
+  return (uint8_t)(pixel >> PIXEL_GREEN_SHIFT);
+}
+static inline uint8_t pixelBlue(uint32_t pixel) {
+  return (uint8_t)(pixel >> PIXEL_BLUE_SHIFT);
+}
+static inline uint8_t pixelAlpha(uint32_t pixel) {
+  return (uint8_t)(pixel >> PIXEL_ALPHA_SHIFT);
+}
+
+// Builds a packed pixel out of channel values.
+static inline uint32_t pixelPack(uint8_t red, uint8_t green, uint8_t blue,
+    uint8_t alpha) {
+  return ((uint32_t)red << PIXEL_RED_SHIFT) |
+      ((uint32_t)green << PIXEL_GREEN_SHIFT) |
+      ((uint32_t)blue << PIXEL_BLUE_SHIFT) |
+      ((uint32_t)alpha << PIXEL_ALPHA_SHIFT);
+}
+
+// The pixel's Rec. 601 luma, computed with 8-bit fixed-point weights.
+// The Go code mirrors this in pixelGray.
+static inline uint8_t pixelGray(uint32_t pixel) {
+  return (uint8_t)((77 * pixelRed(pixel) + 150 * pixelGreen(pixel) +
+      29 * pixelBlue(pixel) + 128) >> 8);
+}
+
+// The value that a packed pixel would have on a little-endian host.
+// Hashes are computed over canonical values, so they don't depend on the
+// host's byte order.
+static inline uint32_t pixelCanonical(uint32_t pixel) {
+#if PIXEL_RED_SHIFT == 0
+  return pixel;
+#else
+  return (uint32_t)pixelRed(pixel) | ((uint32_t)pixelGreen(pixel) << 8) |
+      ((uint32_t)pixelBlue(pixel) << 16) | ((uint32_t)pixelAlpha(pixel) << 24);
+#endif
+}
+
+#endif  // IMAGEUTIL_PIXEL_H_
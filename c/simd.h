@@ -0,0 +1,63 @@
+#ifndef IMAGEUTIL_SIMD_H_
+#define IMAGEUTIL_SIMD_H_
+
+#include <stdint.h>
+
+// The instruction sets that kernels can be specialized for.
+// Each level includes the instructions in the levels below it. The Go code
+// mirrors these values in simd_cgo.go.
+enum {
+  kSimdScalar = 0,
+  kSimdSse2 = 1,
+  kSimdAvx2 = 2,
+};
+
+// SIMD_X86 is 1 when the compiler can build x86 SIMD kernels.
+// The kernels are compiled with target attributes, so they don't require any
+// compiler flags. They must only be called if the CPU supports them.
+#if (defined(__x86_64__) || defined(__i386__)) && defined(__GNUC__)
+#define SIMD_X86 1
+#include <immintrin.h>
+#define SIMD_TARGET_SSE2 __attribute__((target("sse2")))
+#define SIMD_TARGET_AVX2 __attribute__((target("avx2")))
+#else
+#define SIMD_X86 0
+#endif
+
+// Returns the best instruction set supported by the CPU.
+static inline int simdDetectLevel(void) {
+#if SIMD_X86
+  __builtin_cpu_init();
+  if (__builtin_cpu_supports("avx2"))
+    return kSimdAvx2;
+  if (__builtin_cpu_supports("sse2"))
+    return kSimdSse2;
+#endif
+  return kSimdScalar;
+}
+
+#if SIMD_X86
+
+// Returns all-ones lanes for the packed pixels whose channels are all inside
+// [low, high]. The bounds are packed pixels.
+SIMD_TARGET_SSE2 static inline __m128i simdInRangeSse2(__m128i pixels,
+    __m128i low, __m128i high) {
+  __m128i aboveLow = _mm_cmpeq_epi8(_mm_max_epu8(pixels, low), pixels);
+  __m128i belowHigh = _mm_cmpeq_epi8(_mm_min_epu8(pixels, high), pixels);
+  return _mm_cmpeq_epi32(_mm_and_si128(aboveLow, belowHigh),
+      _mm_set1_epi32(-1));
+}
+
+// The AVX2 version of simdInRangeSse2.
+SIMD_TARGET_AVX2 static inline __m256i simdInRangeAvx2(__m256i pixels,
+    __m256i low, __m256i high) {
+  __m256i aboveLow = _mm256_cmpeq_epi8(_mm256_max_epu8(pixels, low), pixels);
+  __m256i belowHigh = _mm256_cmpeq_epi8(_mm256_min_epu8(pixels, high),
+      pixels);
+  return _mm256_cmpeq_epi32(_mm256_and_si256(aboveLow, belowHigh),
+      _mm256_set1_epi32(-1));
+}
+
+#endif  // SIMD_X86
+
+#endif  // IMAGEUTIL_SIMD_H_
@@ -0,0 +1,60 @@
+#include <stdint.h>
+
+// Accelerates the window statistics used by SSIM.
+// Two packed grayscale images are covered by square windows of the given size,
+// at every position where the window fits in the images. For each window, the
+// sums of the first image's values, the second image's values, their squares
+// and their products are stored in stats, in that order, 5 values per window.
+// The windows are in row-major order. The columns buffer is scratch space for
+// 5 * width values.
+void GoGraySsimStats(void* grayBytes1, void* grayBytes2, int width,
+    int height, int window, uint32_t* stats, uint32_t* columns) {
+  const uint8_t* gray1 = (const uint8_t*)grayBytes1;
+  const uint8_t* gray2 = (const uint8_t*)grayBytes2;
+
+  for (int i = 0; i < 5 * width; ++i)
+    columns[i] = 0;
+  for (int y = 0; y < height; ++y) {
+    // The columns hold the sums over the window rows ending at row y.
+    const uint8_t* row1 = gray1 + y * width;
+    const uint8_t* row2 = gray2 + y * width;
+    for (int x = 0; x < width; ++x) {
+      uint32_t value1 = row1[x], value2 = row2[x];
+      uint32_t* column = columns + 5 * x;
+      column[0] += value1;
+      column[1] += value2;
+      column[2] += value1 * value1;
+      column[3] += value2 * value2;
+      column[4] += value1 * value2;
+    }
+    if (y >= window) {
+      const uint8_t* oldRow1 = gray1 + (y - window) * width;
+      const uint8_t* oldRow2 = gray2 + (y - window) * width;
+      for (int x = 0; x < width; ++x) {
+        uint32_t value1 = oldRow1[x], value2 = oldRow2[x];
+        uint32_t* column = columns + 5 * x;
+        column[0] -= value1;
+        column[1] -= value2;
+        column[2] -= value1 * value1;
+        column[3] -= value2 * value2;
+        column[4] -= value1 * value2;
+      }
+    }
+    if (y < window - 1)
+      continue;
+
+    uint32_t sums[5] = {0, 0, 0, 0, 0};
+    for (int x = 0; x < width; ++x) {
+      for (int i = 0; i < 5; ++i)
+        sums[i] += columns[5 * x + i];
+      if (x >= window) {
+        for (int i = 0; i < 5; ++i)
+          sums[i] -= columns[5 * (x - window) + i];
+      }
+      if (x < window - 1)
+        continue;
+      for (int i = 0; i < 5; ++i)
+        *stats++ = sums[i];
+    }
+  }
+}
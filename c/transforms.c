@@ -0,0 +1,106 @@
+#include <math.h>
+#include <stdint.h>
+
+// NOTE: Contracting multiply-adds into FMA instructions changes rounding, so
+//       it is disabled to keep the results identical to the pure Go kernels.
+#if defined(__clang__)
+#pragma STDC FP_CONTRACT OFF
+#elif defined(__GNUC__)
+#pragma GCC optimize("fp-contract=off")
+#endif
+
+// Side of the square tiles used to keep the orientation loops cache-friendly.
+static const int kTileSize = 32;
+
+// Accelerates RotateRgba90, RotateRgba180, RotateRgba270, FlipRgbaHorizontal,
+// FlipRgbaVertical, and TransposeRgba.
+// Source pixel (x, y) is copied to target pixel targetBase + x * targetXStep +
+// y * targetYStep, where all the quantities are measured in pixels.
+void GoRgbaOrient(void* rgbaBytes, void* targetBytes, int width, int height,
+    int targetBase, int targetXStep, int targetYStep) {
+  uint32_t* rgbaPixels = (uint32_t*)rgbaBytes;
+  uint32_t* targetPixels = (uint32_t*)targetBytes + targetBase;
+
+  for (int tileY = 0; tileY < height; tileY += kTileSize) {
+    int maxY = tileY + kTileSize;
+    if (maxY > height) maxY = height;
+    for (int tileX = 0; tileX < width; tileX += kTileSize) {
+      int maxX = tileX + kTileSize;
+      if (maxX > width) maxX = width;
+      for (int y = tileY; y < maxY; ++y) {
+        uint32_t* rgbaPixel = rgbaPixels + y * width + tileX;
+        uint32_t* targetPixel = targetPixels + y * targetYStep +
+            tileX * targetXStep;
+        for (int x = tileX; x < maxX; ++x, ++rgbaPixel) {
+          *targetPixel = *rgbaPixel;
+          targetPixel += targetXStep;
+        }
+      }
+    }
+  }
+}
+
+// Accelerates AffineWarpRgba.
+// The matrix maps target pixel centers to source coordinates. Samples that
+// fall outside the source image use the background color.
+void GoRgbaAffineWarp(void* rgbaBytes, void* targetBytes, int width,
+    int height, int targetWidth, int targetHeight, double m0, double m1,
+    double m2, double m3, double m4, double m5, uint8_t backR,
+    uint8_t backG, uint8_t backB, uint8_t backA) {
+  const uint8_t* rgbaPixels = (const uint8_t*)rgbaBytes;
+  uint8_t* targetPixel = (uint8_t*)targetBytes;
+  const uint8_t background[4] = { backR, backG, backB, backA };
+
+  for (int y = 0; y < targetHeight; ++y) {
+    double centerY = y + 0.5;
+    for (int x = 0; x < targetWidth; ++x, targetPixel += 4) {
+      double centerX = x + 0.5;
+      double sourceX = m0 * centerX + m1 * centerY + m2 - 0.5;
+      double sourceY = m3 * centerX + m4 * centerY + m5 - 0.5;
+
+      double floorX = floor(sourceX);
+      double floorY = floor(sourceY);
+      if (floorX < -1 || floorX >= width || floorY < -1 ||
+          floorY >= height) {
+        targetPixel[0] = backR;
+        targetPixel[1] = backG;
+        targetPixel[2] = backB;
+        targetPixel[3] = backA;
+        continue;
+      }
+
+      int x0 = (int)floorX;
+      int y0 = (int)floorY;
+      double fracX = sourceX - floorX;
+      double fracY = sourceY - floorY;
+
+      // The four neighbors, in the order (x0, y0), (x0 + 1, y0), (x0, y0 + 1),
+      // (x0 + 1, y0 + 1).
+      const uint8_t* samples[4];
+      for (int i = 0; i < 4; ++i) {
+        int sampleX = x0 + (i & 1);
+        int sampleY = y0 + (i >> 1);
+        if (sampleX < 0 || sampleX >= width || sampleY < 0 ||
+            sampleY >= height) {
+          samples[i] = background;
+        } else {
+          samples[i] = rgbaPixels + 4 * (sampleY * width + sampleX);
+        }
+      }
+      double weights[4] = {
+        (1 - fracX) * (1 - fracY), fracX * (1 - fracY),
+        (1 - fracX) * fracY, fracX * fracY,
+      };
+
+      for (int channel = 0; channel < 4; ++channel) {
+        double value = weights[0] * samples[0][channel] +
+            weights[1] * samples[1][channel] +
+            weights[2] * samples[2][channel] +
+            weights[3] * samples[3][channel];
+        int rounded = (int)(value + 0.5);
+        if (rounded > 255) rounded = 255;
+        targetPixel[channel] = (uint8_t)rounded;
+      }
+    }
+  }
+}
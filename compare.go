@@ -0,0 +1,150 @@
+package imageutil
+
+import (
+  "math"
+)
+
+// CompareStats holds the error metrics between two images.
+// The per-channel arrays are indexed in red, green, blue, alpha order. The
+// overall metrics only cover the channels that are not fully masked out.
+type CompareStats struct {
+  // Pixels is the number of pixels compared.
+  Pixels int
+  // Channels is the number of channels that are not masked out.
+  Channels int
+
+  // AbsErrors holds the sum of absolute differences of each channel.
+  AbsErrors [4]int64
+  // SquaredErrors holds the sum of squared differences of each channel.
+  SquaredErrors [4]int64
+
+  // MaxAbsError is the largest absolute difference in any channel.
+  MaxAbsError int
+  // ChannelMaxAbsErrors holds the largest absolute difference in each
+  // channel.
+  ChannelMaxAbsErrors [4]int
+
+  // Mse is the mean squared error.
+  Mse float64
+  // ChannelMses holds the mean squared error of each channel.
+  ChannelMses [4]float64
+
+  // Psnr is the peak signal-to-noise ratio, in decibels.
+  // It is +Inf when the images are identical.
+  Psnr float64
+  // ChannelPsnrs holds the peak signal-to-noise ratio of each channel.
+  ChannelPsnrs [4]float64
+}
+
+// RgbaCompareStats computes error metrics between two equal-sized RGBA images.
+// The mask is applied to both images before they are compared, like in
+// RgbaCheckMaskedCrop. 0xffffffff compares the images exactly, and 0xffffff00
+// ignores the alpha channel.
+func RgbaCompareStats(image1 []byte, image2 []byte, width int, height int,
+    rgbaMask uint32) CompareStats {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(image1) < width * height * 4 || len(image2) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return NewRgbaView(image1, width, height).CompareStats(
+      NewRgbaView(image2, width, height), rgbaMask)
+}
+
+// CompareStats computes error metrics between two equal-sized views.
+// This is the view equivalent of RgbaCompareStats.
+func (v RgbaView) CompareStats(other RgbaView,
+    rgbaMask uint32) CompareStats {
+  if v.Width != other.Width || v.Height != other.Height {
+    panic("Views have different sizes")
+  }
+  return v.CompareCropStats(other, 0, 0, rgbaMask)
+}
+
+// RgbaCompareCropStats computes error metrics between a needle image and a
+// crop of a haystack image.
+// The needle is compared with the crop at (needleLeft, needleTop), like in
+// RgbaDiffMaskedCrop, but the mask is applied to both images. All the metrics
+// are computed in a single pass over the images. The function returns zero
+// stats if the needle does not fit in the haystack at the given position.
+func RgbaCompareCropStats(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleLeft int,
+    needleTop int, rgbaMask uint32) CompareStats {
+
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).CompareCropStats(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop,
+      rgbaMask)
+}
+
+// CompareCropStats computes error metrics between a needle view and a crop of
+// this view.
+// This is the view equivalent of RgbaCompareCropStats.
+func (v RgbaView) CompareCropStats(needle RgbaView, needleLeft int,
+    needleTop int, rgbaMask uint32) CompareStats {
+  v.check("Haystack view")
+  needle.check("Needle view")
+
+  // NOTE: These checks are also intended to prevent segmentation faults, but
+  //       we don't have to panic here.
+  if needleLeft < 0 || needleLeft + needle.Width > v.Width {
+    return CompareStats{}
+  }
+  if needleTop < 0 || needleTop + needle.Height > v.Height {
+    return CompareStats{}
+  }
+
+  var sums [12]uint64
+  if !needle.Empty() {
+    rgbaCompareCropStats(v, needle, needleLeft, needleTop, packRgba(rgbaMask),
+        &sums)
+  }
+  return newCompareStats(sums, needle.Width * needle.Height, rgbaMask)
+}
+
+// newCompareStats computes error metrics out of the sums produced by the
+// comparison kernels.
+func newCompareStats(sums [12]uint64, pixels int,
+    rgbaMask uint32) CompareStats {
+  stats := CompareStats{Pixels: pixels}
+  squaredErrors := 0.0
+  for i := 0; i < 4; i += 1 {
+    stats.AbsErrors[i] = int64(sums[i])
+    stats.SquaredErrors[i] = int64(sums[4 + i])
+    stats.ChannelMaxAbsErrors[i] = int(sums[8 + i])
+    if pixels > 0 {
+      stats.ChannelMses[i] = float64(sums[4 + i]) / float64(pixels)
+    }
+    stats.ChannelPsnrs[i] = psnr(stats.ChannelMses[i])
+
+    if (rgbaMask >> uint(24 - 8 * i)) & 0xff == 0 {
+      continue
+    }
+    stats.Channels += 1
+    squaredErrors += float64(sums[4 + i])
+    if stats.ChannelMaxAbsErrors[i] > stats.MaxAbsError {
+      stats.MaxAbsError = stats.ChannelMaxAbsErrors[i]
+    }
+  }
+  if pixels > 0 && stats.Channels > 0 {
+    stats.Mse = squaredErrors / float64(pixels * stats.Channels)
+  }
+  stats.Psnr = psnr(stats.Mse)
+  return stats
+}
+
+// psnr computes the peak signal-to-noise ratio for 8-bit channels.
+func psnr(mse float64) float64 {
+  if mse == 0 {
+    return math.Inf(1)
+  }
+  return 10 * math.Log10(255 * 255 / mse)
+}
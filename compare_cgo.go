@@ -0,0 +1,25 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/compare.c"
+import "C"  // cgo
+
+import (
+  "unsafe"
+)
+
+// rgbaCompareCropStats adds up the channel errors between a needle and a crop
+// of a haystack.
+// The views must be non-empty and checked, and the needle must fit inside the
+// haystack at the given position. The mask is a packed pixel. The stats array
+// receives the absolute error sums, the squared error sums, and the maximum
+// absolute errors, each in red, green, blue, alpha order.
+func rgbaCompareCropStats(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, pixelMask uint32, stats *[12]uint64) {
+  C.GoRgbaCompareCropStats(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Stride / 4),
+      C.int(needle.Stride / 4), C.int(needle.Width), C.int(needle.Height),
+      C.int(needleLeft), C.int(needleTop), C.uint32_t(pixelMask),
+      (*C.uint64_t)(unsafe.Pointer(&stats[0])))
+}
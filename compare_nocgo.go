@@ -0,0 +1,37 @@
+//go:build !cgo
+
+package imageutil
+
+// The kernels below are the pure Go versions of the functions in
+// c/compare.c.
+
+// rgbaCompareCropStats adds up the channel errors between a needle and a crop
+// of a haystack.
+// The views must be non-empty and checked, and the needle must fit inside the
+// haystack at the given position. The mask is a packed pixel. The stats array
+// receives the absolute error sums, the squared error sums, and the maximum
+// absolute errors, each in red, green, blue, alpha order.
+func rgbaCompareCropStats(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, pixelMask uint32, stats *[12]uint64) {
+  // Packed pixels hold the channels in memory order, so the mask bytes line
+  // up with the red, green, blue and alpha bytes.
+  maskBytes := pixelMaskBytes(pixelMask)
+  *stats = [12]uint64{}
+  for y := 0; y < needle.Height; y += 1 {
+    hayRow := haystack.Pix[haystack.PixOffset(needleLeft, needleTop + y):]
+    needleRow := needle.Pix[needle.PixOffset(0, y):]
+    for i := 0; i < needle.Width * 4; i += 1 {
+      channel := i & 3
+      diff := int(hayRow[i] & maskBytes[channel]) -
+          int(needleRow[i] & maskBytes[channel])
+      if diff < 0 {
+        diff = -diff
+      }
+      stats[channel] += uint64(diff)
+      stats[4 + channel] += uint64(diff * diff)
+      if uint64(diff) > stats[8 + channel] {
+        stats[8 + channel] = uint64(diff)
+      }
+    }
+  }
+}
@@ -0,0 +1,113 @@
+package imageutil
+
+import (
+  "math"
+  "math/rand"
+  "testing"
+)
+
+func TestRgbaCompareStats(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  random := rand.New(rand.NewSource(9))
+  noisy := make([]byte, len(fruits.Pix))
+  var goldSquares [4]int64
+  goldMax := [4]int{}
+  for i, value := range fruits.Pix {
+    noisy[i] = byte(clamp(int(value) + random.Intn(21) - 10, 0, 255))
+    if i % 4 == 3 {
+      noisy[i] = value ^ byte(random.Intn(2))
+    }
+    diff := absInt(int(noisy[i]) - int(value))
+    goldSquares[i % 4] += int64(diff * diff)
+    if diff > goldMax[i % 4] {
+      goldMax[i % 4] = diff
+    }
+  }
+
+  stats := RgbaCompareStats(fruits.Pix, noisy, width, height, 0xffffffff)
+  if stats.Pixels != width * height || stats.Channels != 4 {
+    t.Errorf("Incorrect pixel and channel counts: %d, %d\n", stats.Pixels,
+        stats.Channels)
+  }
+  if stats.SquaredErrors != goldSquares ||
+      stats.ChannelMaxAbsErrors != goldMax || stats.MaxAbsError != 10 {
+    t.Errorf("Incorrect error sums: %v %v %d\n", stats.SquaredErrors,
+        stats.ChannelMaxAbsErrors, stats.MaxAbsError)
+  }
+  goldMse := float64(goldSquares[0] + goldSquares[1] + goldSquares[2] +
+      goldSquares[3]) / float64(width * height * 4)
+  if math.Abs(stats.Mse - goldMse) > 1e-9 {
+    t.Errorf("MSE is %f instead of %f\n", stats.Mse, goldMse)
+  }
+  if goldPsnr := 10 * math.Log10(65025 / goldMse);
+      math.Abs(stats.Psnr - goldPsnr) > 1e-9 {
+    t.Errorf("PSNR is %f instead of %f\n", stats.Psnr, goldPsnr)
+  }
+  if stats.ChannelPsnrs[3] <= stats.ChannelPsnrs[0] {
+    t.Error("Alpha PSNR not higher than red PSNR: ", stats.ChannelPsnrs)
+  }
+
+  // Masking out the alpha channel removes it from the overall metrics.
+  colorStats := RgbaCompareStats(fruits.Pix, noisy, width, height,
+      0xffffff00)
+  colorMse := float64(goldSquares[0] + goldSquares[1] + goldSquares[2]) /
+      float64(width * height * 3)
+  if colorStats.Channels != 3 || colorStats.SquaredErrors[3] != 0 ||
+      math.Abs(colorStats.Mse - colorMse) > 1e-9 {
+    t.Errorf("Incorrect masked stats: %d channels, MSE %f\n",
+        colorStats.Channels, colorStats.Mse)
+  }
+
+  same := RgbaCompareStats(fruits.Pix, fruits.Pix, width, height, 0xffffffff)
+  if same.Mse != 0 || same.MaxAbsError != 0 || !math.IsInf(same.Psnr, 1) {
+    t.Error("Incorrect stats for identical images: ", same)
+  }
+}
+
+func TestRgbaCompareCropStats(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  var needle []byte
+  CropRgba(fruits.Pix, width, height, 100, 200, 50, 40, &needle)
+  if stats := RgbaCompareCropStats(fruits.Pix, width, height, needle, 50, 40,
+      100, 200, 0xffffffff); stats.Pixels != 2000 || stats.Mse != 0 {
+    t.Error("Incorrect stats for aligned crop: ", stats)
+  }
+
+  // The crop stats match the diffs computed by RgbaDiffMaskedCrop when the
+  // mask doesn't change the needle.
+  for i := range needle {
+    needle[i] &= 0xf0
+  }
+  stats := RgbaCompareCropStats(fruits.Pix, width, height, needle, 50, 40,
+      101, 199, 0xf0f0f0f0)
+  diff := RgbaDiffMaskedCrop(fruits.Pix, width, height, needle, 50, 40, 101,
+      199, 0xf0f0f0f0)
+  absSum := stats.AbsErrors[0] + stats.AbsErrors[1] + stats.AbsErrors[2] +
+      stats.AbsErrors[3]
+  if absSum != diff || diff == 0 {
+    t.Errorf("Crop absolute errors add up to %d instead of %d\n", absSum,
+        diff)
+  }
+
+  view, _ := ViewRgba(fruits.Pix, width, height, 101, 199, 50, 40)
+  if viewStats := NewRgbaView(needle, 50, 40).CompareStats(view,
+      0xf0f0f0f0); viewStats.AbsErrors != stats.AbsErrors ||
+      viewStats.Mse != stats.Mse {
+    t.Error("View stats do not match crop stats: ", viewStats)
+  }
+
+  if stats := RgbaCompareCropStats(fruits.Pix, width, height, needle, 50, 40,
+      480, 0, 0xffffffff); stats != (CompareStats{}) {
+    t.Error("Out of bounds crop returned non-zero stats: ", stats)
+  }
+}
@@ -1,28 +1,214 @@
 package imageutil
 
 import (
+  "errors"
+  "fmt"
   "image"
   "image/draw"
 )
 
+// CropMode selects how crop rectangles that exceed the image are handled.
+type CropMode int
+
+const (
+  // CropStrict rejects crop rectangles that are not inside the image.
+  CropStrict CropMode = iota
+  // CropClip crops the intersection of the crop rectangle and the image.
+  CropClip
+  // CropPadColor fills the pixels outside the image with a given color.
+  CropPadColor
+  // CropPadEdge fills the pixels outside the image with the closest pixel on
+  // the image's edge.
+  CropPadEdge
+)
+
+// ErrCropOutOfBounds is returned when a crop rectangle can't be satisfied.
+var ErrCropOutOfBounds = errors.New("Crop rectangle exceeds image bounds")
+
 // CropRgba crops an RGBA image into a target slice.
 // The target slice's length is set to the needed image length. If the slice's
 // capacity is too small, the slice is re-created.
+// It returns ErrCropOutOfBounds if the crop rectangle is not entirely inside
+// the image. The target slice is not changed in that case.
 func CropRgba(rawImage []byte, width int, height int, xOffset int,
-    yOffset int, xSize int, ySize int, target *[]byte) {
+    yOffset int, xSize int, ySize int, target *[]byte) error {
+  _, err := CropRgbaMode(rawImage, width, height, xOffset, yOffset, xSize,
+      ySize, CropStrict, 0, target)
+  return err
+}
+
+// CropRgbaMode crops an RGBA image into a target slice.
+// The mode determines how the parts of the crop rectangle that fall outside
+// the image are handled. The fill color is only used by CropPadColor. The
+// target slice's length is set to the needed image length. If the slice's
+// capacity is too small, the slice is re-created.
+// It returns the image area that ended up in the target, which is smaller than
+// the crop rectangle in CropClip mode, and any error encountered. Unknown
+// modes return an error, and leave the target slice unchanged, and so do
+// images whose dimensions don't match their buffers, which return an error
+// wrapping ErrInvalidDimensions or ErrBufferTooSmall.
+func CropRgbaMode(rawImage []byte, width int, height int, xOffset int,
+    yOffset int, xSize int, ySize int, mode CropMode, fillRgba uint32,
+    target *[]byte) (image.Rectangle, error) {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return image.Rectangle{}, err
+  }
+  if xSize < 0 || ySize < 0 {
+    return image.Rectangle{}, errors.New("Crop size cannot be negative")
+  }
+
+  bounds := image.Rect(0, 0, width, height)
+  cropRect := image.Rect(xOffset, yOffset, xOffset + xSize, yOffset + ySize)
+  clipRect := cropRect.Intersect(bounds)
+  switch mode {
+  case CropStrict:
+    if !cropRect.Empty() && clipRect != cropRect {
+      return image.Rectangle{}, ErrCropOutOfBounds
+    }
+  case CropClip:
+    if clipRect.Empty() {
+      return image.Rectangle{}, ErrCropOutOfBounds
+    }
+    cropRect = clipRect
+  case CropPadColor:
+  case CropPadEdge:
+    if bounds.Empty() {
+      return image.Rectangle{}, ErrCropOutOfBounds
+    }
+  default:
+    return image.Rectangle{}, fmt.Errorf("Unknown crop mode %d", mode)
+  }
+
+  source := image.RGBA{Pix: rawImage, Stride: width * 4, Rect: bounds}
+
+  cropWidth, cropHeight := cropRect.Dx(), cropRect.Dy()
+  resizeBuffer(target, cropWidth * cropHeight * 4)
+  cropped := image.RGBA{Pix: *target, Stride: cropWidth * 4,
+      Rect: image.Rect(0, 0, cropWidth, cropHeight)}
+
+  switch mode {
+  case CropPadColor:
+    fill := [4]byte{byte(fillRgba >> 24), byte(fillRgba >> 16),
+        byte(fillRgba >> 8), byte(fillRgba)}
+    for i := 0; i < len(*target); i += 4 {
+      copy((*target)[i:i + 4], fill[:])
+    }
+  case CropPadEdge:
+    padEdges(&source, &cropped, cropRect)
+  }
+
+  if !clipRect.Empty() {
+    targetRect := clipRect.Sub(cropRect.Min)
+    draw.Draw(&cropped, targetRect, &source, clipRect.Min, draw.Src)
+  }
+  return cropRect, nil
+}
 
-  source := image.RGBA{Pix: rawImage, Stride: width * 4,
-      Rect: image.Rect(0, 0, width, height)}
+// CropArena owns the memory that backs the crops made by CropRgbaMany.
+// Reusing an arena across CropRgbaMany calls avoids re-allocating the crops'
+// memory. The zero value is an empty arena that is ready to use.
+type CropArena struct {
+  // slab holds the pixels of all the crops, one after another.
+  slab []byte
+  // crops holds the slices returned by CropRgbaMany.
+  crops [][]byte
+  // layout holds the crop descriptions passed to the cropping kernel.
+  layout []int32
+}
 
-  targetSize := xSize * ySize * 4
-  if cap(*target) < targetSize {
-    *target = make([]byte, targetSize, targetSize)
-  } else if len(*target) != targetSize {
-    *target = (*target)[:targetSize]
+// CropRgbaMany crops many rectangles out of an RGBA image in one pass.
+// The crops are stored in the arena's memory, so they are overwritten by the
+// next CropRgbaMany call that uses the same arena.
+// It returns one slice of pixels for each rectangle, and any error
+// encountered. ErrCropOutOfBounds is returned if a rectangle is not entirely
+// inside the image.
+func CropRgbaMany(rawImage []byte, width int, height int,
+    rects []image.Rectangle, arena *CropArena) ([][]byte, error) {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(rawImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
   }
-  cropped := image.RGBA{Pix: *target, Stride: xSize * 4,
-      Rect: image.Rect(0, 0, xSize, ySize)}
 
-  sourcePt := image.Pt(xOffset, yOffset)
-  draw.Draw(&cropped, cropped.Bounds(), &source, sourcePt, draw.Src)
+  bounds := image.Rect(0, 0, width, height)
+  slabSize := 0
+  minY, maxY := height, 0
+  for _, rect := range rects {
+    if rect.Dx() < 0 || rect.Dy() < 0 {
+      return nil, errors.New("Crop size cannot be negative")
+    }
+    if rect.Empty() {
+      continue
+    }
+    if !rect.In(bounds) {
+      return nil, ErrCropOutOfBounds
+    }
+    slabSize += rect.Dx() * rect.Dy() * 4
+    if minY > rect.Min.Y {
+      minY = rect.Min.Y
+    }
+    if maxY < rect.Max.Y {
+      maxY = rect.Max.Y
+    }
+  }
+
+  resizeBuffer(&arena.slab, slabSize)
+  if cap(arena.crops) < len(rects) {
+    arena.crops = make([][]byte, len(rects))
+  }
+  arena.crops = arena.crops[:len(rects)]
+  arena.layout = arena.layout[:0]
+
+  offset := 0
+  for i, rect := range rects {
+    cropSize := rect.Dx() * rect.Dy() * 4
+    arena.crops[i] = arena.slab[offset:offset + cropSize:offset + cropSize]
+    if cropSize == 0 {
+      continue
+    }
+    arena.layout = append(arena.layout, int32(rect.Min.X), int32(rect.Min.Y),
+        int32(rect.Max.X), int32(rect.Max.Y), int32(offset / 4))
+    offset += cropSize
+  }
+
+  if len(arena.layout) > 0 {
+    rgbaCropMany(rawImage, arena.slab, width, arena.layout, minY, maxY)
+  }
+  return arena.crops, nil
+}
+
+// padEdges replicates a source image's edges into a crop target.
+// The crop rectangle is in the source image's coordinates. Only the target
+// pixels that fall outside the source image are written.
+func padEdges(source *image.RGBA, cropped *image.RGBA,
+    cropRect image.Rectangle) {
+  maxX, maxY := source.Rect.Dx() - 1, source.Rect.Dy() - 1
+  for y := cropRect.Min.Y; y < cropRect.Max.Y; y += 1 {
+    sourceY := clamp(y, 0, maxY)
+    for x := cropRect.Min.X; x < cropRect.Max.X; x += 1 {
+      if y == sourceY && x >= 0 && x <= maxX {
+        // Skip the pixels inside the image.
+        x = maxX
+        continue
+      }
+      sourceX := clamp(x, 0, maxX)
+
+      sourceOffset := source.PixOffset(sourceX, sourceY)
+      targetOffset := cropped.PixOffset(x - cropRect.Min.X,
+          y - cropRect.Min.Y)
+      copy(cropped.Pix[targetOffset:targetOffset + 4],
+          source.Pix[sourceOffset:sourceOffset + 4])
+    }
+  }
+}
+
+// clamp returns the value in the [low, high] range that is closest to x.
+func clamp(x int, low int, high int) int {
+  if x < low {
+    return low
+  }
+  if x > high {
+    return high
+  }
+  return x
 }
@@ -0,0 +1,19 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/crop.c"
+import "C"  // cgo
+
+import (
+  "unsafe"
+)
+
+// rgbaCropMany copies the crops described by a layout into a slab.
+// Each crop takes up 5 layout values, as documented in c/crop.c.
+func rgbaCropMany(rawImage []byte, slab []byte, width int, layout []int32,
+    minY int, maxY int) {
+  C.GoRgbaCropMany(unsafe.Pointer(&rawImage[0]), unsafe.Pointer(&slab[0]),
+      C.int(width), unsafe.Pointer(&layout[0]), C.int(len(layout) / 5),
+      C.int(minY), C.int(maxY))
+}
@@ -0,0 +1,23 @@
+//go:build !cgo
+
+package imageutil
+
+// rgbaCropMany copies the crops described by a layout into a slab.
+// Each crop takes up 5 layout values, as documented in c/crop.c. This is the
+// pure Go version of GoRgbaCropMany.
+func rgbaCropMany(rawImage []byte, slab []byte, width int, layout []int32,
+    minY int, maxY int) {
+  for y := minY; y < maxY; y += 1 {
+    row := rawImage[y * width * 4:(y + 1) * width * 4]
+    for i := 0; i < len(layout); i += 5 {
+      left, top, right, bottom := int(layout[i]), int(layout[i + 1]),
+          int(layout[i + 2]), int(layout[i + 3])
+      if y < top || y >= bottom {
+        continue
+      }
+      rowSize := (right - left) * 4
+      start := int(layout[i + 4]) * 4 + (y - top) * rowSize
+      copy(slab[start:start + rowSize], row[left * 4:right * 4])
+    }
+  }
+}
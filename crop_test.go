@@ -4,6 +4,8 @@ import (
   "bytes"
   "encoding/hex"
   "crypto/sha256"
+  "errors"
+  imagepkg "image"
   "testing"
 )
 
@@ -48,3 +50,212 @@ func TestCropRgba(t *testing.T) {
     t.Error("Crop2 pixel data mismatch")
   }
 }
+
+func TestCropRgbaOutOfBounds(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  cases := [][4]int{
+    {-1, 0, 16, 16},
+    {0, -1, 16, 16},
+    {500, 0, 16, 16},
+    {0, 500, 16, 16},
+    {600, 600, 16, 16},
+  }
+
+  target := []byte{1, 2, 3, 4}
+  for _, testCase := range cases {
+    err := CropRgba(image.Pix, width, height, testCase[0], testCase[1],
+        testCase[2], testCase[3], &target)
+    if err != ErrCropOutOfBounds {
+      t.Errorf("Case %v did not return ErrCropOutOfBounds: %v", testCase, err)
+    }
+  }
+  if !bytes.Equal(target, []byte{1, 2, 3, 4}) {
+    t.Error("Failed crops changed the target")
+  }
+
+  if err := CropRgba(image.Pix, width, height, 496, 496, 16, 16,
+      &target); err != nil {
+    t.Error("Crop touching the image corner failed: ", err)
+  }
+}
+
+func TestCropRgbaModeClip(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  var target, golden []byte
+  rect, err := CropRgbaMode(image.Pix, width, height, 500, -4, 16, 8, CropClip,
+      0, &target)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if rect.Min.X != 500 || rect.Min.Y != 0 || rect.Max.X != 512 ||
+      rect.Max.Y != 4 {
+    t.Errorf("Incorrect clipped rectangle: %v\n", rect)
+  }
+
+  CropRgba(image.Pix, width, height, 500, 0, 12, 4, &golden)
+  if !bytes.Equal(target, golden) {
+    t.Error("Clipped crop pixel data mismatch")
+  }
+
+  _, err = CropRgbaMode(image.Pix, width, height, 600, 0, 16, 8, CropClip, 0,
+      &target)
+  if err != ErrCropOutOfBounds {
+    t.Error("Disjoint clipped crop did not return ErrCropOutOfBounds: ", err)
+  }
+}
+
+func TestCropRgbaModePadColor(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  // Reuse a dirty buffer, to make sure that stale data is overwritten.
+  target := bytes.Repeat([]byte{0xaa}, 4096)
+  var golden []byte
+  rect, err := CropRgbaMode(image.Pix, width, height, -2, 508, 8, 8,
+      CropPadColor, 0x11223344, &target)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if rect.Min.X != -2 || rect.Min.Y != 508 || rect.Max.X != 6 ||
+      rect.Max.Y != 516 {
+    t.Errorf("Incorrect padded rectangle: %v\n", rect)
+  }
+  // Save the crop result for debugging.
+  RgbaToPng(target, 8, 8, "test_tmp/fruits_CropPadColor.png")
+
+  CropRgba(image.Pix, width, height, 0, 508, 6, 4, &golden)
+  for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+      pixel := target[4 * (y * 8 + x):4 * (y * 8 + x + 1)]
+      var goldenPixel []byte
+      if x >= 2 && y < 4 {
+        goldenPixel = golden[4 * (y * 6 + x - 2):4 * (y * 6 + x - 1)]
+      } else {
+        goldenPixel = []byte{0x11, 0x22, 0x33, 0x44}
+      }
+      if !bytes.Equal(pixel, goldenPixel) {
+        t.Fatalf("Pixel mismatch at %d, %d: %v vs %v\n", x, y, pixel,
+            goldenPixel)
+      }
+    }
+  }
+}
+
+func TestCropRgbaModePadEdge(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  var target []byte
+  _, err = CropRgbaMode(image.Pix, width, height, 508, -3, 8, 8, CropPadEdge,
+      0, &target)
+  if err != nil {
+    t.Fatal(err)
+  }
+  // Save the crop result for debugging.
+  RgbaToPng(target, 8, 8, "test_tmp/fruits_CropPadEdge.png")
+
+  for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+      pixel := target[4 * (y * 8 + x):4 * (y * 8 + x + 1)]
+      sourceX, sourceY := 508 + x, y - 3
+      if sourceX > 511 {
+        sourceX = 511
+      }
+      if sourceY < 0 {
+        sourceY = 0
+      }
+      offset := image.PixOffset(sourceX, sourceY)
+      if !bytes.Equal(pixel, image.Pix[offset:offset + 4]) {
+        t.Fatalf("Pixel mismatch at %d, %d\n", x, y)
+      }
+    }
+  }
+}
+
+func TestCropRgbaModeUnknown(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  target := []byte{1, 2, 3, 4}
+  if _, err := CropRgbaMode(image.Pix, width, height, 508, -3, 8, 8,
+      CropMode(7), 0, &target); err == nil {
+    t.Error("Unknown crop mode did not return an error")
+  }
+  if !bytes.Equal(target, []byte{1, 2, 3, 4}) {
+    t.Error("Unknown crop mode changed the target: ", target)
+  }
+  // Buffers that are too small for their images are rejected before they are
+  // read.
+  if _, err := CropRgbaMode(image.Pix[:100], width, height, 0, 0, 8, 8,
+      CropStrict, 0, &target); !errors.Is(err, ErrBufferTooSmall) {
+    t.Error("Short buffer did not return ErrBufferTooSmall: ", err)
+  }
+  if _, err := CropRgbaMode(image.Pix, -1, height, 0, 0, 8, 8, CropStrict, 0,
+      &target); !errors.Is(err, ErrInvalidDimensions) {
+    t.Error("Negative width did not return ErrInvalidDimensions: ", err)
+  }
+  if !bytes.Equal(target, []byte{1, 2, 3, 4}) {
+    t.Error("Invalid image changed the target: ", target)
+  }
+}
+
+func TestCropRgbaMany(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  rects := []imagepkg.Rectangle{
+    imagepkg.Rect(200, 400, 328, 416),
+    imagepkg.Rect(0, 0, 16, 8),
+    imagepkg.Rect(496, 500, 512, 512),
+    imagepkg.Rect(10, 10, 10, 20),
+    imagepkg.Rect(205, 390, 215, 410),
+  }
+
+  var arena CropArena
+  for round := 0; round < 2; round += 1 {
+    crops, err := CropRgbaMany(image.Pix, width, height, rects, &arena)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if len(crops) != len(rects) {
+      t.Fatal("Incorrect number of crops: ", len(crops))
+    }
+
+    var golden []byte
+    for i, rect := range rects {
+      CropRgba(image.Pix, width, height, rect.Min.X, rect.Min.Y, rect.Dx(),
+          rect.Dy(), &golden)
+      if !bytes.Equal(crops[i], golden) {
+        t.Errorf("Round %d crop %d pixel data mismatch", round, i)
+      }
+    }
+  }
+
+  rects = append(rects, imagepkg.Rect(500, 500, 520, 510))
+  if _, err := CropRgbaMany(image.Pix, width, height, rects,
+      &arena); err != ErrCropOutOfBounds {
+    t.Error("Out of bounds rectangle did not return ErrCropOutOfBounds: ", err)
+  }
+}
@@ -0,0 +1,170 @@
+package imageutil
+
+import (
+  "bufio"
+  "errors"
+  "image"
+  "image/color"
+  "image/draw"
+  "image/gif"
+  _ "image/jpeg"
+  _ "image/png"
+  "io"
+  "os"
+
+  _ "golang.org/x/image/bmp"
+  _ "golang.org/x/image/webp"
+)
+
+// ReadRgba decodes an image file into a raw RGBA buffer.
+// It returns the decoded image and any error encountered. See DecodeRgba for
+// the supported formats.
+func ReadRgba(fileName string) (*image.RGBA, error) {
+  f, err := os.Open(fileName)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  return DecodeRgba(f)
+}
+
+// DecodeRgba decodes an image into a raw RGBA buffer.
+// The format is sniffed from the image's first bytes. PNG, JPEG, GIF, BMP and
+// WebP are supported, and GIFs decode to their first frame. Other formats are
+// supported when their decoders are registered with the image package.
+// Unknown formats return image.ErrFormat.
+//
+// The returned image's pixels are packed, with a stride of 4 * width, and its
+// bounds start at (0, 0), so its Pix slice can be passed to all the functions
+// in this package.
+func DecodeRgba(r io.Reader) (*image.RGBA, error) {
+  decodedImage, _, err := image.Decode(bufio.NewReader(r))
+  if err != nil {
+    return nil, err
+  }
+  return packedRgba(decodedImage), nil
+}
+
+// ReadRgbaGif decodes all the frames in a GIF file into raw RGBA buffers.
+// It returns the decoded frames and any error encountered. See DecodeRgbaGif
+// for details.
+func ReadRgbaGif(fileName string) ([]*image.RGBA, error) {
+  f, err := os.Open(fileName)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  return DecodeRgbaGif(f)
+}
+
+// DecodeRgbaGif decodes all the frames in a GIF image into raw RGBA buffers.
+// GIF frames only hold the pixels that changed since the previous frame, so
+// each frame is composited over the previous frames, following the frames'
+// disposal methods. All the returned frames have the GIF's full size, and
+// start out transparent.
+func DecodeRgbaGif(r io.Reader) ([]*image.RGBA, error) {
+  gifImage, err := gif.DecodeAll(bufio.NewReader(r))
+  if err != nil {
+    return nil, err
+  }
+  if len(gifImage.Image) == 0 {
+    return nil, errors.New("GIF has no frames")
+  }
+
+  bounds := image.Rect(0, 0, gifImage.Config.Width, gifImage.Config.Height)
+  if bounds.Empty() {
+    for _, frame := range gifImage.Image {
+      bounds = bounds.Union(frame.Bounds())
+    }
+  }
+  canvas := image.NewRGBA(bounds)
+  var previous *image.RGBA
+  frames := make([]*image.RGBA, len(gifImage.Image))
+  for i, frame := range gifImage.Image {
+    disposal := byte(0)
+    if i < len(gifImage.Disposal) {
+      disposal = gifImage.Disposal[i]
+    }
+    if disposal == gif.DisposalPrevious {
+      previous = cloneRgba(canvas)
+    }
+
+    draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+    frames[i] = packedRgba(cloneRgba(canvas))
+
+    switch disposal {
+    case gif.DisposalBackground:
+      draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{},
+          draw.Src)
+    case gif.DisposalPrevious:
+      canvas = previous
+    }
+  }
+  return frames, nil
+}
+
+// packedRgba converts a decoded image into a packed RGBA image.
+// RGBA images that are already packed and start at (0, 0) are returned as-is.
+func packedRgba(decodedImage image.Image) *image.RGBA {
+  bounds := decodedImage.Bounds()
+  if rgbaImage, ok := decodedImage.(*image.RGBA); ok &&
+      bounds.Min == (image.Point{}) && rgbaImage.Stride == bounds.Dx() * 4 {
+    return rgbaImage
+  }
+
+  rgbaImage := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+  draw.Draw(rgbaImage, rgbaImage.Bounds(), decodedImage, bounds.Min, draw.Src)
+  return rgbaImage
+}
+
+// packedNrgba converts a decoded image into a packed NRGBA image.
+// Unlike draw.Draw, the conversion keeps the colors of transparent pixels in
+// NRGBA, 16-bit NRGBA and paletted images. NRGBA images that are already
+// packed and start at (0, 0) are returned as-is.
+func packedNrgba(decodedImage image.Image) *image.NRGBA {
+  bounds := decodedImage.Bounds()
+  if nrgbaImage, ok := decodedImage.(*image.NRGBA); ok &&
+      bounds.Min == (image.Point{}) && nrgbaImage.Stride == bounds.Dx() * 4 {
+    return nrgbaImage
+  }
+
+  width, height := bounds.Dx(), bounds.Dy()
+  nrgbaImage := image.NewNRGBA(image.Rect(0, 0, width, height))
+  switch sourceImage := decodedImage.(type) {
+  case *image.NRGBA:
+    for y := 0; y < height; y += 1 {
+      offset := sourceImage.PixOffset(bounds.Min.X, bounds.Min.Y + y)
+      copy(nrgbaImage.Pix[y * width * 4:(y + 1) * width * 4],
+          sourceImage.Pix[offset:])
+    }
+  case *image.NRGBA64:
+    // 16-bit channels are big-endian, so their high bytes come first.
+    for y := 0; y < height; y += 1 {
+      row := sourceImage.Pix[sourceImage.PixOffset(bounds.Min.X,
+          bounds.Min.Y + y):]
+      for i := 0; i < width * 4; i += 1 {
+        nrgbaImage.Pix[y * width * 4 + i] = row[i * 2]
+      }
+    }
+  default:
+    // NRGBAModel returns NRGBA colors, like the ones in paletted PNGs,
+    // unchanged.
+    for y := 0; y < height; y += 1 {
+      for x := 0; x < width; x += 1 {
+        nrgbaImage.SetNRGBA(x, y, color.NRGBAModel.Convert(decodedImage.At(
+            bounds.Min.X + x, bounds.Min.Y + y)).(color.NRGBA))
+      }
+    }
+  }
+  return nrgbaImage
+}
+
+// cloneRgba copies an RGBA image.
+func cloneRgba(rgbaImage *image.RGBA) *image.RGBA {
+  clone := *rgbaImage
+  clone.Pix = make([]byte, len(rgbaImage.Pix))
+  copy(clone.Pix, rgbaImage.Pix)
+  return &clone
+}
@@ -0,0 +1,146 @@
+package imageutil
+
+import (
+  "bytes"
+  "image"
+  "image/color"
+  "image/gif"
+  "image/jpeg"
+  "strings"
+  "testing"
+)
+
+func TestDecodeRgba(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  decoded, err := ReadRgba("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(decoded.Pix, fruits.Pix) {
+    t.Error("ReadRgba does not match ReadRgbaPng")
+  }
+
+  var jpegBuffer bytes.Buffer
+  if err := jpeg.Encode(&jpegBuffer, fruits,
+      &jpeg.Options{Quality: 95}); err != nil {
+    t.Fatal(err)
+  }
+  decoded, err = DecodeRgba(&jpegBuffer)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if decoded.Stride != width * 4 || decoded.Rect != fruits.Rect {
+    t.Fatalf("Incorrect JPEG stride %d and bounds %v\n", decoded.Stride,
+        decoded.Rect)
+  }
+  if stats := RgbaCompareStats(decoded.Pix, fruits.Pix, width, height,
+      0xffffffff); stats.Psnr < 30 || stats.ChannelMaxAbsErrors[3] != 0 {
+    t.Errorf("JPEG decoded with PSNR %f and alpha error %d\n", stats.Psnr,
+        stats.ChannelMaxAbsErrors[3])
+  }
+
+  // Sub-images are re-packed, and moved to the origin.
+  subImage := fruits.SubImage(image.Rect(100, 50, 300, 250)).(*image.RGBA)
+  packed := packedRgba(subImage)
+  var crop []byte
+  CropRgba(fruits.Pix, width, height, 100, 50, 200, 200, &crop)
+  if packed.Rect != image.Rect(0, 0, 200, 200) ||
+      !bytes.Equal(packed.Pix, crop) {
+    t.Error("Sub-image was not packed correctly")
+  }
+  if packedRgba(fruits) != fruits {
+    t.Error("Packed image was copied")
+  }
+
+  // The BMP and WebP fixtures are lossless, and come with PNG copies.
+  for _, fixture := range []string{"rose.bmp", "gopher.webp"} {
+    decoded, err := ReadRgba("test_data/" + fixture)
+    if err != nil {
+      t.Errorf("ReadRgba failed for %s: %v\n", fixture, err)
+      continue
+    }
+    pngName := "test_data/" + fixture[:strings.IndexByte(fixture, '.')] +
+        ".png"
+    golden, err := ReadRgbaPng(pngName)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if decoded.Rect != golden.Rect || decoded.Stride != golden.Stride ||
+        !bytes.Equal(decoded.Pix, golden.Pix) {
+      t.Errorf("%s does not match %s\n", fixture, pngName)
+    }
+  }
+
+  if _, err := DecodeRgba(bytes.NewReader([]byte("not an image"))); err !=
+      image.ErrFormat {
+    t.Error("Unknown format did not return image.ErrFormat: ", err)
+  }
+  if _, err := ReadRgba("test_data/missing.png"); err == nil {
+    t.Error("Missing file did not return an error")
+  }
+}
+
+func TestDecodeRgbaGif(t *testing.T) {
+  palette := color.Palette{color.Transparent, color.RGBA{0xff, 0, 0, 0xff},
+      color.RGBA{0, 0, 0xff, 0xff}}
+  // A red 4x4 square, a blue 2x2 square over it that is disposed back to the
+  // red square, and a transparent frame.
+  red := image.NewPaletted(image.Rect(0, 0, 4, 4), palette)
+  for i := range red.Pix {
+    red.Pix[i] = 1
+  }
+  blue := image.NewPaletted(image.Rect(1, 1, 3, 3), palette)
+  for i := range blue.Pix {
+    blue.Pix[i] = 2
+  }
+  empty := image.NewPaletted(image.Rect(0, 0, 1, 1), palette)
+  var gifBuffer bytes.Buffer
+  if err := gif.EncodeAll(&gifBuffer, &gif.GIF{
+    Image: []*image.Paletted{red, blue, empty},
+    Delay: []int{0, 0, 0},
+    Disposal: []byte{gif.DisposalNone, gif.DisposalPrevious,
+        gif.DisposalNone},
+    Config: image.Config{ColorModel: palette, Width: 4, Height: 4},
+  }); err != nil {
+    t.Fatal(err)
+  }
+  gifBytes := gifBuffer.Bytes()
+
+  frames, err := DecodeRgbaGif(bytes.NewReader(gifBytes))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(frames) != 3 {
+    t.Fatal("Incorrect frame count: ", len(frames))
+  }
+  redPixel, bluePixel := []byte{0xff, 0, 0, 0xff}, []byte{0, 0, 0xff, 0xff}
+  checks := []struct {
+    frame int
+    x, y int
+    pixel []byte
+  } {
+    {0, 2, 2, redPixel}, {1, 2, 2, bluePixel}, {1, 3, 3, redPixel},
+    {2, 2, 2, redPixel}, {2, 0, 0, redPixel},
+  }
+  for _, check := range checks {
+    offset := frames[check.frame].PixOffset(check.x, check.y)
+    if pixel := frames[check.frame].Pix[offset:offset + 4];
+        !bytes.Equal(pixel, check.pixel) {
+      t.Errorf("Frame %d pixel (%d, %d) is %v instead of %v\n", check.frame,
+          check.x, check.y, pixel, check.pixel)
+    }
+  }
+
+  first, err := DecodeRgba(bytes.NewReader(gifBytes))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(first.Pix, frames[0].Pix) {
+    t.Error("DecodeRgba did not return the first GIF frame")
+  }
+}
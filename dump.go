@@ -0,0 +1,134 @@
+package imageutil
+
+import (
+  "sync"
+)
+
+// PngDumper writes PNG images to files in a background goroutine.
+// Dumping images this way takes the PNG encoding off the caller's goroutine,
+// which helps when intermediate images are dumped while debugging. The images
+// wait in a bounded queue, so a slow disk cannot use up unbounded memory.
+// Dumpers are safe for concurrent use by multiple goroutines.
+type PngDumper struct {
+  // options is the dumper's copy of the PNG encoder options.
+  options PngOptions
+  // queue holds the images waiting to be written.
+  queue chan pngDump
+  // buffers holds *[]byte image copies that can be reused.
+  buffers sync.Pool
+  // done is closed when the background goroutine exits.
+  done chan struct{}
+
+  // mutex guards err.
+  mutex sync.Mutex
+  // err is the first error encountered while writing images.
+  err error
+}
+
+// pngDump is an image waiting to be written by a PngDumper.
+type pngDump struct {
+  rawImage *[]byte
+  width int
+  height int
+  fileName string
+}
+
+// NewPngDumper creates a PngDumper and starts its background goroutine.
+// The queue size is the number of images that can wait to be written. A nil
+// options pointer uses the defaults set by SetPngDefaults when the dumper is
+// created. The dumper must be closed by Close to stop its goroutine.
+func NewPngDumper(queueSize int, options *PngOptions) *PngDumper {
+  if queueSize < 0 {
+    panic("Queue size must not be negative")
+  }
+  if options == nil {
+    defaults := PngDefaults()
+    options = &defaults
+  }
+
+  dumper := &PngDumper{options: *options,
+      queue: make(chan pngDump, queueSize), done: make(chan struct{})}
+  go dumper.run()
+  return dumper
+}
+
+// Dump queues a raw RGBA-encoded image to be written into a PNG file.
+// The image is copied, so its buffer can be reused after this returns. If the
+// queue is full, Dump waits until an image is written. It returns an error
+// wrapping ErrInvalidDimensions or ErrBufferTooSmall if the image's
+// dimensions don't match its buffer. Errors encountered while writing the
+// file are returned by Close. Dump must not be called after Close.
+func (d *PngDumper) Dump(rawImage []byte, width int, height int,
+    fileName string) error {
+  dump, err := d.newDump(rawImage, width, height, fileName)
+  if err != nil {
+    return err
+  }
+  d.queue <- dump
+  return nil
+}
+
+// TryDump queues a raw RGBA-encoded image to be written, unless the queue is
+// full.
+// This is a variant of Dump that drops the image and returns ErrQueueFull
+// instead of waiting, so dumping never slows down the caller.
+func (d *PngDumper) TryDump(rawImage []byte, width int, height int,
+    fileName string) error {
+  dump, err := d.newDump(rawImage, width, height, fileName)
+  if err != nil {
+    return err
+  }
+  select {
+  case d.queue <- dump:
+    return nil
+  default:
+    d.buffers.Put(dump.rawImage)
+    return ErrQueueFull
+  }
+}
+
+// Close waits until all the queued images are written, and stops the
+// background goroutine.
+// It returns the first error encountered while writing images.
+func (d *PngDumper) Close() error {
+  close(d.queue)
+  <-d.done
+
+  d.mutex.Lock()
+  defer d.mutex.Unlock()
+  return d.err
+}
+
+// newDump copies an image so it can be queued.
+func (d *PngDumper) newDump(rawImage []byte, width int, height int,
+    fileName string) (pngDump, error) {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return pngDump{}, err
+  }
+
+  buffer, _ := d.buffers.Get().(*[]byte)
+  if buffer == nil {
+    buffer = new([]byte)
+  }
+  resizeBuffer(buffer, width * height * 4)
+  copy(*buffer, rawImage)
+  return pngDump{rawImage: buffer, width: width, height: height,
+      fileName: fileName}, nil
+}
+
+// run writes the queued images until the queue is closed.
+func (d *PngDumper) run() {
+  defer close(d.done)
+  for dump := range d.queue {
+    err := RgbaToPngWithOptions(*dump.rawImage, dump.width, dump.height,
+        dump.fileName, &d.options)
+    d.buffers.Put(dump.rawImage)
+    if err != nil {
+      d.mutex.Lock()
+      if d.err == nil {
+        d.err = err
+      }
+      d.mutex.Unlock()
+    }
+  }
+}
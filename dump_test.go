@@ -0,0 +1,89 @@
+package imageutil
+
+import (
+  "bytes"
+  "errors"
+  "fmt"
+  "image/png"
+  "testing"
+)
+
+func TestPngDumper(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  dumper := NewPngDumper(2, &PngOptions{CompressionLevel: png.BestSpeed,
+      BufferPool: &PngBufferPool{}})
+  buffer := make([]byte, len(fruits.Pix))
+  for i := 0; i < 5; i += 1 {
+    // The dumper copies the image, so the buffer can be changed right away.
+    copy(buffer, fruits.Pix)
+    buffer[i * 4] ^= 0xff
+    if err := dumper.Dump(buffer, width, height,
+        fmt.Sprintf("test_tmp/dump_%d.png", i)); err != nil {
+      t.Fatal(err)
+    }
+    buffer[i * 4] ^= 0x0f
+  }
+  if err := dumper.Dump(buffer, width, height + 1,
+      "test_tmp/dump_bad.png"); !errors.Is(err, ErrBufferTooSmall) {
+    t.Error("Small buffer did not return ErrBufferTooSmall: ", err)
+  }
+  if err := dumper.Close(); err != nil {
+    t.Fatal(err)
+  }
+
+  for i := 0; i < 5; i += 1 {
+    dumped, err := ReadRgbaPng(fmt.Sprintf("test_tmp/dump_%d.png", i))
+    if err != nil {
+      t.Fatal(err)
+    }
+    copy(buffer, fruits.Pix)
+    buffer[i * 4] ^= 0xff
+    if !bytes.Equal(dumped.Pix, buffer) {
+      t.Errorf("Dump %d pixel data mismatch\n", i)
+    }
+  }
+
+  dumper = NewPngDumper(0, nil)
+  if err := dumper.Dump(fruits.Pix, width, height,
+      "test_tmp/missing_dir/dump.png"); err != nil {
+    t.Fatal(err)
+  }
+  if err := dumper.Close(); err == nil {
+    t.Error("Close did not return the write error")
+  }
+}
+
+func TestPngDumperTryDump(t *testing.T) {
+  // The dumper's goroutine is started after the queue fills up.
+  dumper := &PngDumper{queue: make(chan pngDump, 1),
+      done: make(chan struct{})}
+  rawImage := []byte{1, 2, 3, 0xff}
+  if err := dumper.TryDump(rawImage, 1, 1,
+      "test_tmp/try_dump_0.png"); err != nil {
+    t.Fatal(err)
+  }
+  if err := dumper.TryDump(rawImage, 1, 1,
+      "test_tmp/try_dump_1.png"); err != ErrQueueFull {
+    t.Error("Full queue did not return ErrQueueFull: ", err)
+  }
+  go dumper.run()
+  if err := dumper.Close(); err != nil {
+    t.Fatal(err)
+  }
+
+  dumped, err := ReadNrgbaPng("test_tmp/try_dump_0.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(dumped.Pix, rawImage) {
+    t.Error("TryDump pixel data mismatch: ", dumped.Pix)
+  }
+  if _, err := ReadRgbaPng("test_tmp/try_dump_1.png"); err == nil {
+    t.Error("Dropped image was written")
+  }
+}
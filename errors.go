@@ -0,0 +1,67 @@
+package imageutil
+
+import (
+  "errors"
+  "fmt"
+)
+
+// The errors returned by the Checked functions and the image I/O functions.
+// The Checked functions wrap these errors with details about the offending
+// argument, so they should be tested with errors.Is.
+var (
+  // ErrBufferTooSmall is returned when a buffer is smaller than its image.
+  ErrBufferTooSmall = errors.New("Buffer too small for image dimensions")
+  // ErrInvalidDimensions is returned for negative or overflowing dimensions.
+  ErrInvalidDimensions = errors.New("Invalid image dimensions")
+  // ErrScratchTooSmall is returned when a scratch buffer's capacity is
+  // smaller than what a search needs.
+  ErrScratchTooSmall = errors.New("Insufficient scratch buffer capacity")
+  // ErrNeedleNotMasked is returned when a masked search's needle has bits
+  // outside the mask, so the search could never match it.
+  ErrNeedleNotMasked = errors.New("Needle has bits outside the search mask")
+  // ErrQueueFull is returned by PngDumper.TryDump when the dumper's queue has
+  // no room for another image.
+  ErrQueueFull = errors.New("Dump queue is full")
+  // ErrInvalidHeader is returned when a Netpbm or raw image file's header is
+  // malformed or uses unsupported features.
+  ErrInvalidHeader = errors.New("Invalid or unsupported image file header")
+)
+
+// maxPixels is the largest pixel count whose byte size fits in an int.
+const maxPixels = int(^uint(0) >> 3)
+
+// rgbaSize computes the number of bytes in a raw RGBA image.
+// It returns an error wrapping ErrInvalidDimensions if the dimensions are
+// negative, or if width * height * 4 does not fit in an int. The name
+// identifies the image in the returned error.
+func rgbaSize(name string, width int, height int) (int, error) {
+  if width < 0 || height < 0 || (width > 0 && height > maxPixels / width) {
+    return 0, fmt.Errorf("%s is %d x %d: %w", name, width, height,
+        ErrInvalidDimensions)
+  }
+  return width * height * 4, nil
+}
+
+// validateRgba checks that a raw RGBA buffer can hold an image.
+// The name identifies the buffer in the returned error.
+func validateRgba(name string, rawImage []byte, width int,
+    height int) error {
+  size, err := rgbaSize(name, width, height)
+  if err != nil {
+    return err
+  }
+  if len(rawImage) < size {
+    return fmt.Errorf("%s needs %d bytes, has %d: %w", name, size,
+        len(rawImage), ErrBufferTooSmall)
+  }
+  return nil
+}
+
+// validateScratch checks that a scratch buffer can be used for a search.
+func validateScratch(scratch []byte, hayWidth int) error {
+  if cap(scratch) < hayWidth * 4 {
+    return fmt.Errorf("Scratch needs %d bytes, has %d: %w", hayWidth * 4,
+        cap(scratch), ErrScratchTooSmall)
+  }
+  return nil
+}
@@ -0,0 +1,128 @@
+package imageutil
+
+import (
+  "errors"
+  "testing"
+)
+
+func TestCheckedMatchersErrors(t *testing.T) {
+  haystack := make([]byte, 16 * 8 * 4)
+  needle := make([]byte, 4 * 4 * 4)
+  scratch := make([]byte, 16 * 4)
+
+  if _, err := RgbaCheckCropChecked(haystack, 16, 9, needle, 4, 4, 0,
+      0); !errors.Is(err, ErrBufferTooSmall) {
+    t.Error("Short haystack did not return ErrBufferTooSmall: ", err)
+  }
+  if _, err := RgbaCheckMaskedCropChecked(haystack, 16, 8, needle, 4, 5, 0,
+      0, 0xffffffff); !errors.Is(err, ErrBufferTooSmall) {
+    t.Error("Short needle did not return ErrBufferTooSmall: ", err)
+  }
+  if _, err := RgbaDiffMaskedCropChecked(haystack, -16, 8, needle, 4, 4, 0,
+      0, 0xffffffff); !errors.Is(err, ErrInvalidDimensions) {
+    t.Error("Negative width did not return ErrInvalidDimensions: ", err)
+  }
+  if _, err := RgbaDiffThresholdCropChecked(haystack, 16, 8, needle, 4,
+      maxPixels, 0, 0, 0, 255, 0, 255, 0, 255); !errors.Is(err,
+      ErrInvalidDimensions) {
+    t.Error("Overflowing size did not return ErrInvalidDimensions: ", err)
+  }
+  if _, err := HashForRgbaFindCropChecked(needle, 5, 4); !errors.Is(err,
+      ErrBufferTooSmall) {
+    t.Error("Short needle hash did not return ErrBufferTooSmall: ", err)
+  }
+  if _, _, _, err := RgbaFindCropChecked(haystack, 16, 8, needle, 4, 4, 0,
+      scratch[:15 * 4:15 * 4]); !errors.Is(err, ErrScratchTooSmall) {
+    t.Error("Short scratch did not return ErrScratchTooSmall: ", err)
+  }
+  if _, _, _, err := RgbaFindMaskedCropChecked(haystack, 16, 8, needle, 4, 4,
+      0xffffffff, 0, scratch[:0:15 * 4]); !errors.Is(err,
+      ErrScratchTooSmall) {
+    t.Error("Short scratch did not return ErrScratchTooSmall: ", err)
+  }
+  needle[1] = 0x0f
+  if _, _, _, err := RgbaFindMaskedCropChecked(haystack, 16, 8, needle, 4, 4,
+      0xf0f0f0ff, 0, scratch); err != ErrNeedleNotMasked {
+    t.Error("Unmasked needle did not return ErrNeedleNotMasked: ", err)
+  }
+  needle[1] = 0
+
+  // Valid arguments work just like in the panicking versions.
+  hash, err := HashForRgbaFindCropChecked(needle, 4, 4)
+  if err != nil {
+    t.Fatal(err)
+  }
+  count, _, _, err := RgbaFindCropChecked(haystack, 16, 8, needle, 4, 4, hash,
+      scratch)
+  if err != nil || count != 13 * 5 {
+    t.Errorf("RgbaFindCropChecked returned count %d, error %v\n", count, err)
+  }
+  match, err := RgbaCheckCropChecked(haystack, 16, 8, needle, 4, 4, 12, 4)
+  if err != nil || !match {
+    t.Errorf("RgbaCheckCropChecked returned %v, error %v\n", match, err)
+  }
+}
+
+func TestCheckedFiltersAndObjectsErrors(t *testing.T) {
+  rgba := make([]byte, 8 * 8 * 4)
+
+  if err := RgbaToHslaChecked(rgba, make([]byte, 8)); !errors.Is(err,
+      ErrBufferTooSmall) {
+    t.Error("Short HSLA buffer did not return ErrBufferTooSmall: ", err)
+  }
+  if err := RgbaToHslaChecked(rgba, make([]byte, len(rgba))); err != nil {
+    t.Error("RgbaToHslaChecked failed: ", err)
+  }
+
+  pillars := make([][4]int32, 2)
+  if err := RgbaFindPillarsChecked(rgba, 8, 9, 0, 255, 0, 255, 0, 255,
+      pillars); !errors.Is(err, ErrBufferTooSmall) {
+    t.Error("Short image did not return ErrBufferTooSmall: ", err)
+  }
+  puddle := make([][2]int32, 64)
+  if _, err := RgbaFindPuddleChecked(rgba, 8, -8, 0, 255, 0, 255, 0, 255, 0,
+      puddle); !errors.Is(err, ErrInvalidDimensions) {
+    t.Error("Negative height did not return ErrInvalidDimensions: ", err)
+  }
+  RgbaResetPuddles(rgba)
+  size, err := RgbaFindPuddleChecked(rgba, 8, 8, 0, 255, 0, 255, 0, 255, 0,
+      puddle)
+  if err != nil || size != 64 {
+    t.Errorf("RgbaFindPuddleChecked returned size %d, error %v\n", size, err)
+  }
+
+  if _, err := ViewRgba(rgba, 8, 9, 0, 0, 1, 1); !errors.Is(err,
+      ErrBufferTooSmall) {
+    t.Error("Short image view did not return ErrBufferTooSmall: ", err)
+  }
+}
+
+func TestEmptyInputs(t *testing.T) {
+  // None of these calls should panic.
+  RgbaResetPuddles(nil)
+  MaskRgba(nil, BuildRgbaMask(0xffffffff))
+  RgbaThreshold(nil, 0, 255, 0, 255, 0, 255)
+  RgbaToHsla(nil, nil)
+
+  rgba := make([]byte, 4 * 4 * 4)
+  RgbaFindPillars(rgba, 4, 4, 0, 255, 0, 255, 0, 255, nil)
+  pillars := [][4]int32{{1, 2, 3, 4}}
+  RgbaFindPillars(nil, 0, 0, 0, 255, 0, 255, 0, 255, pillars)
+  if pillars[0] != [4]int32{} {
+    t.Error("Empty image did not clear pillars: ", pillars)
+  }
+  if size := RgbaFindPuddle(rgba, 4, 4, 0, 255, 0, 255, 0, 255, 0,
+      nil); size != 0 {
+    t.Error("Empty puddle buffer returned size ", size)
+  }
+  puddle := make([][2]int32, 16)
+  RgbaResetPuddles(rgba)
+  if size := RgbaFindPuddle(nil, 0, 0, 0, 255, 0, 255, 0, 255, 0,
+      puddle); size != 0 {
+    t.Error("Empty image returned puddle size ", size)
+  }
+  if size := RgbaFindPuddle(rgba, 4, 4, 0, 255, 0, 255, 0, 255, -3,
+      puddle); size != 16 {
+    t.Error("Negative start row returned puddle size ", size)
+  }
+}
@@ -1,28 +1,73 @@
 package imageutil
 
-// #include "c/filters.c"
-import "C"  // cgo
-
 import (
-  "unsafe"
+  "fmt"
 )
 
-// BuildMask computes a word mask from a 32-bit RGBA mask.
-// The word mask is intended to be used with the MaskRgba function.
+// BuildRgbaMask computes a word mask from a 32-bit RGBA mask.
+// The word mask is intended to be used with the MaskRgba family of functions.
 func BuildRgbaMask(rgba uint32) uint64 {
-  // NOTE: Intels are little-endian, so we need to flip the bytes in the word.
-  argb := uint64(((rgba & 0xff) << 24) | ((rgba & 0xff00) << 8) |
-      ((rgba & 0xff0000) >> 8) | ((rgba & 0xff000000) >> 24))
+  // NOTE: The word mask is applied to the image's memory directly, so both
+  //       of its halves are packed pixels.
+  pixel := uint64(packRgba(rgba))
+  return pixel | (pixel << 32)
+}
 
-  return argb | (argb << 32)
+// RgbaBitDepthMask computes an RGBA mask that keeps the top bits of channels.
+// The bit counts range from 0 to 8. Masking an image with the result of this
+// function reduces its bit depth, which is useful for quantized matching.
+func RgbaBitDepthMask(redBits int, greenBits int, blueBits int,
+    alphaBits int) uint32 {
+  topBits := func(bits int) uint32 {
+    if bits < 0 || bits > 8 {
+      panic("Channel bit counts must be between 0 and 8")
+    }
+    return (0xff << uint(8 - bits)) & 0xff
+  }
+  return (topBits(redBits) << 24) | (topBits(greenBits) << 16) |
+      (topBits(blueBits) << 8) | topBits(alphaBits)
 }
 
+// The operations supported by maskRgba.
+const (
+  maskAnd = 0
+  maskOr = 1
+  maskXor = 2
+  maskAndNot = 3
+)
+
 // MaskRgba applies a word mask to an RGBA image buffer.
 // This uses fast 64-bit operations. In return for the speed, the caller must
-// covert the RGBA mask into a word mask, with the help of BuildMask.
+// covert the RGBA mask into a word mask, with the help of BuildRgbaMask.
 func MaskRgba(rawImage []byte, mask uint64) {
-  C.GoMaskRgba(unsafe.Pointer(&rawImage[0]), C.int(len(rawImage)),
-      C.uint64_t(mask))
+  maskRgba(rawImage, mask, maskAnd)
+}
+
+// OrMaskRgba sets the bits in a word mask in an RGBA image buffer.
+// The word mask is computed by BuildRgbaMask.
+func OrMaskRgba(rawImage []byte, mask uint64) {
+  maskRgba(rawImage, mask, maskOr)
+}
+
+// XorMaskRgba flips the bits in a word mask in an RGBA image buffer.
+// The word mask is computed by BuildRgbaMask.
+func XorMaskRgba(rawImage []byte, mask uint64) {
+  maskRgba(rawImage, mask, maskXor)
+}
+
+// AndNotMaskRgba clears the bits in a word mask in an RGBA image buffer.
+// The word mask is computed by BuildRgbaMask.
+func AndNotMaskRgba(rawImage []byte, mask uint64) {
+  maskRgba(rawImage, mask, maskAndNot)
+}
+
+// ReduceRgbaBitDepth keeps the top bits of each channel in an RGBA image.
+// This is a shortcut for masking the image with the result of
+// RgbaBitDepthMask.
+func ReduceRgbaBitDepth(rawImage []byte, redBits int, greenBits int,
+    blueBits int, alphaBits int) {
+  MaskRgba(rawImage, BuildRgbaMask(RgbaBitDepthMask(redBits, greenBits,
+      blueBits, alphaBits)))
 }
 
 // RgbaToHsla converts an RGBA image to a HSLA image.
@@ -32,22 +77,46 @@ func RgbaToHsla(rgbaImage []byte, hslaImage []byte) {
   if cap(hslaImage) < len(rgbaImage) {
     panic("HSLA buffer smaller than RGBA image size")
   }
-  C.GoRgbaToHsla(unsafe.Pointer(&rgbaImage[0]), unsafe.Pointer(&hslaImage[0]),
-      C.int(len(rgbaImage)));
+  if len(rgbaImage) == 0 {
+    return
+  }
+  rgbaToHsla(rgbaImage, hslaImage[:len(rgbaImage)])
+}
+
+// RgbaToHslaChecked is the error-returning equivalent of RgbaToHsla.
+// It returns an error wrapping ErrBufferTooSmall instead of panicing when the
+// HSLA buffer is smaller than the RGBA image.
+func RgbaToHslaChecked(rgbaImage []byte, hslaImage []byte) error {
+  if cap(hslaImage) < len(rgbaImage) {
+    return fmt.Errorf("HSLA buffer needs %d bytes, has %d: %w",
+        len(rgbaImage), cap(hslaImage), ErrBufferTooSmall)
+  }
+  RgbaToHsla(rgbaImage, hslaImage)
+  return nil
+}
+
+// RgbaToHslaParallel converts an RGBA image to HSLA using many goroutines.
+// This is the parallel equivalent of RgbaToHsla. The number of goroutines is
+// set by SetConcurrency.
+func RgbaToHslaParallel(rgbaImage []byte, hslaImage []byte) {
+  if cap(hslaImage) < len(rgbaImage) {
+    panic("HSLA buffer smaller than RGBA image size")
+  }
+  pixelCount := len(rgbaImage) / 4
+  runBands(pixelCount, splitBands(pixelCount, parallelMinPixels),
+      func(_ int, start int, end int) {
+        RgbaToHsla(rgbaImage[start * 4:end * 4], hslaImage[start * 4:end * 4])
+      })
 }
 
 // RgbPixelToHsl returns the HSL values for a RGB color with 8-bits / channel.
 // It is mostly useful for easy conversion to our custom HSL scheme where H
 // is scaled between 0 and 255.
 func RgbPixelToHsl(red int, green int, blue int) (int, int, int) {
-  argb := uint32(uint32(red) | uint32(green << 8) | uint32(blue << 16))
-  var alsh uint32
-  C.GoRgbaToHsla(unsafe.Pointer(&argb), unsafe.Pointer(&alsh), C.int(4))
-
-  h := int(alsh & 0xff)
-  s := int((alsh >> 8) & 0xff)
-  l := int((alsh >> 16) & 0xff)
-  return h, s, l
+  rgba := []byte{byte(red), byte(green), byte(blue), 0}
+  hsla := make([]byte, 4)
+  rgbaToHsla(rgba, hsla)
+  return int(hsla[0]), int(hsla[1]), int(hsla[2])
 }
 
 // RgbaThreshold sets the alpha channel in image to a threshold function.
@@ -55,7 +124,66 @@ func RgbPixelToHsl(red int, green int, blue int) (int, int, int) {
 // 0 otherwise.
 func RgbaThreshold(rgbaImage []byte, minRed int, maxRed int, minGreen int,
     maxGreen int, minBlue int, maxBlue int) {
-  C.GoRgbaThreshold(unsafe.Pointer(&rgbaImage[0]), C.int(len(rgbaImage)),
-      C.uint8_t(minRed), C.uint8_t(minGreen), C.uint8_t(minBlue),
-      C.uint8_t(maxRed), C.uint8_t(maxGreen), C.uint8_t(maxBlue))
+  if len(rgbaImage) == 0 {
+    return
+  }
+  rgbaThreshold(rgbaImage, uint8(minRed), uint8(minGreen), uint8(minBlue),
+      uint8(maxRed), uint8(maxGreen), uint8(maxBlue))
+}
+
+// RgbaThresholdParallel thresholds an RGBA image using many goroutines.
+// This is the parallel equivalent of RgbaThreshold. The number of goroutines
+// is set by SetConcurrency.
+func RgbaThresholdParallel(rgbaImage []byte, minRed int, maxRed int,
+    minGreen int, maxGreen int, minBlue int, maxBlue int) {
+  pixelCount := len(rgbaImage) / 4
+  runBands(pixelCount, splitBands(pixelCount, parallelMinPixels),
+      func(_ int, start int, end int) {
+        RgbaThreshold(rgbaImage[start * 4:end * 4], minRed, maxRed, minGreen,
+            maxGreen, minBlue, maxBlue)
+      })
+}
+
+// Mask applies a word mask to the pixels in a view.
+// This is the view equivalent of MaskRgba.
+func (v RgbaView) Mask(mask uint64) {
+  v.check("View")
+  v.rows(func(row []byte) {
+    MaskRgba(row, mask)
+  })
+}
+
+// ToHsla converts the pixels in a view to HSLA, and stores them in a target.
+// This is the view equivalent of RgbaToHsla. The target view must have the
+// same dimensions as this view.
+func (v RgbaView) ToHsla(target RgbaView) {
+  v.check("View")
+  target.check("Target view")
+  if v.Width != target.Width || v.Height != target.Height {
+    panic("HSLA view dimensions do not match RGBA view")
+  }
+  if v.Empty() {
+    return
+  }
+
+  if v.Packed() && target.Packed() {
+    RgbaToHsla(v.Pix, target.Pix)
+    return
+  }
+  rowSize := v.Width * 4
+  for y := 0; y < v.Height; y += 1 {
+    start, targetStart := y * v.Stride, y * target.Stride
+    RgbaToHsla(v.Pix[start:start + rowSize],
+        target.Pix[targetStart:targetStart + rowSize])
+  }
+}
+
+// Threshold sets the alpha channel of a view's pixels to a threshold function.
+// This is the view equivalent of RgbaThreshold.
+func (v RgbaView) Threshold(minRed int, maxRed int, minGreen int,
+    maxGreen int, minBlue int, maxBlue int) {
+  v.check("View")
+  v.rows(func(row []byte) {
+    RgbaThreshold(row, minRed, maxRed, minGreen, maxGreen, minBlue, maxBlue)
+  })
 }
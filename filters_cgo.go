@@ -0,0 +1,34 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/filters.c"
+import "C"  // cgo
+
+import (
+  "unsafe"
+)
+
+// maskRgba combines an RGBA image buffer with a word mask.
+func maskRgba(rawImage []byte, mask uint64, operation int) {
+  if len(rawImage) == 0 {
+    return
+  }
+  C.GoMaskRgba(unsafe.Pointer(&rawImage[0]), C.int(len(rawImage)),
+      C.uint64_t(mask), C.int(operation))
+}
+
+// rgbaToHsla converts a non-empty RGBA image buffer to HSLA.
+func rgbaToHsla(rgbaImage []byte, hslaImage []byte) {
+  C.GoRgbaToHsla(unsafe.Pointer(&rgbaImage[0]), unsafe.Pointer(&hslaImage[0]),
+      C.int(len(rgbaImage)), C.int(simdLevel))
+}
+
+// rgbaThreshold sets the alpha channel in a non-empty RGBA image buffer.
+func rgbaThreshold(rgbaImage []byte, minRed uint8, minGreen uint8,
+    minBlue uint8, maxRed uint8, maxGreen uint8, maxBlue uint8) {
+  C.GoRgbaThreshold(unsafe.Pointer(&rgbaImage[0]), C.int(len(rgbaImage)),
+      C.uint8_t(minRed), C.uint8_t(minGreen), C.uint8_t(minBlue),
+      C.uint8_t(maxRed), C.uint8_t(maxGreen), C.uint8_t(maxBlue),
+      C.int(simdLevel))
+}
@@ -0,0 +1,114 @@
+//go:build !cgo
+
+package imageutil
+
+import (
+  "encoding/binary"
+)
+
+// maskRgba combines an RGBA image buffer with a word mask.
+// This is the pure Go version of GoMaskRgba in c/filters.c.
+func maskRgba(rawImage []byte, mask uint64, operation int) {
+  var maskBytes [8]byte
+  binary.NativeEndian.PutUint64(maskBytes[:], mask)
+
+  wordEnd := len(rawImage) &^ 7
+  for i := 0; i < wordEnd; i += 8 {
+    word := binary.NativeEndian.Uint64(rawImage[i:i + 8])
+    switch operation {
+    case maskAnd:
+      word &= mask
+    case maskOr:
+      word |= mask
+    case maskXor:
+      word ^= mask
+    case maskAndNot:
+      word &^= mask
+    }
+    binary.NativeEndian.PutUint64(rawImage[i:i + 8], word)
+  }
+
+  for i := wordEnd; i < len(rawImage); i += 1 {
+    maskByte := maskBytes[i - wordEnd]
+    switch operation {
+    case maskAnd:
+      rawImage[i] &= maskByte
+    case maskOr:
+      rawImage[i] |= maskByte
+    case maskXor:
+      rawImage[i] ^= maskByte
+    case maskAndNot:
+      rawImage[i] &^= maskByte
+    }
+  }
+}
+
+// rgbaToHsla converts a non-empty RGBA image buffer to HSLA.
+// This is the pure Go version of GoRgbaToHsla in c/filters.c.
+func rgbaToHsla(rgbaImage []byte, hslaImage []byte) {
+  for i := 0; i + 4 <= len(rgbaImage); i += 4 {
+    r, g, b := int(rgbaImage[i]), int(rgbaImage[i + 1]),
+        int(rgbaImage[i + 2])
+    a := rgbaImage[i + 3]
+
+    // RGB -> HSL formula lifted and adapted for 0-255 from:
+    // http://www.niwa.nu/2013/05/math-behind-colorspace-conversions-rgb-hsl/
+    min := r
+    if min > g {
+      min = g
+    }
+    if min > b {
+      min = b
+    }
+    max := r
+    if max < g {
+      max = g
+    }
+    if max < b {
+      max = b
+    }
+
+    sum := min + max
+    diff := max - min
+    l := sum >> 1  // L = (min + max) / 2
+    var h, s int
+    if diff != 0 {
+      if l >= 128 {
+        s = 255 * diff / (510 - sum)
+      } else {
+        s = 255 * diff / sum
+      }
+
+      if max == r {
+        h = 42 * (g - b) / diff
+        if h < 0 {
+          h += 256
+        }
+      } else if max == g {
+        h = 84 + 42 * (b - r) / diff
+      } else {
+        h = 168 + 42 * (r - g) / diff
+      }
+    }
+
+    hslaImage[i] = byte(h)
+    hslaImage[i + 1] = byte(s)
+    hslaImage[i + 2] = byte(l)
+    hslaImage[i + 3] = a
+  }
+}
+
+// rgbaThreshold sets the alpha channel in a non-empty RGBA image buffer.
+// This is the pure Go version of GoRgbaThreshold in c/filters.c.
+func rgbaThreshold(rgbaImage []byte, minRed uint8, minGreen uint8,
+    minBlue uint8, maxRed uint8, maxGreen uint8, maxBlue uint8) {
+  for i := 0; i + 4 <= len(rgbaImage); i += 4 {
+    r, g, b := rgbaImage[i], rgbaImage[i + 1], rgbaImage[i + 2]
+    if r >= minRed && g >= minGreen && b >= minBlue &&
+        r <= maxRed && g <= maxGreen && b <= maxBlue {
+      rgbaImage[i + 3] = 0xff
+    } else {
+      rgbaImage[i + 3] = 0
+    }
+  }
+}
@@ -1,8 +1,10 @@
 package imageutil
 
 import (
+  "bytes"
   "crypto/sha256"
   "encoding/hex"
+  "math/bits"
   "testing"
 )
 
@@ -16,6 +18,10 @@ func TestBuildMask(t *testing.T) {
   }
 
   for input, golden := range cases {
+    // NOTE: The golden values above are for little-endian hosts.
+    if packRgba(0x11223344) == 0x11223344 {
+      golden = bits.ReverseBytes64(golden)
+    }
     if output := BuildRgbaMask(input); output != golden {
       t.Errorf("Got unexpected value %X for input %X\n", output, input)
     }
@@ -43,6 +49,71 @@ func TestMaskRgba(t *testing.T) {
   }
 }
 
+func TestMaskRgbaOperations(t *testing.T) {
+  // NOTE: 3 pixels don't fill up a whole number of 64-bit words, so this
+  //       covers the tail handling code.
+  pixels := []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0,
+      0x0f, 0x1e, 0x2d, 0x3c}
+  rgbaMask := uint32(0xf0cc3301)
+  maskBytes := []byte{0xf0, 0xcc, 0x33, 0x01}
+
+  cases := []struct {
+    name string
+    maskFunc func([]byte, uint64)
+    byteFunc func(byte, byte) byte
+  }{
+    {"MaskRgba", MaskRgba, func(b, m byte) byte { return b & m }},
+    {"OrMaskRgba", OrMaskRgba, func(b, m byte) byte { return b | m }},
+    {"XorMaskRgba", XorMaskRgba, func(b, m byte) byte { return b ^ m }},
+    {"AndNotMaskRgba", AndNotMaskRgba, func(b, m byte) byte { return b &^ m }},
+  }
+
+  for _, testCase := range cases {
+    golden := make([]byte, len(pixels))
+    for i, b := range pixels {
+      golden[i] = testCase.byteFunc(b, maskBytes[i % 4])
+    }
+
+    output := make([]byte, len(pixels))
+    copy(output, pixels)
+    testCase.maskFunc(output, BuildRgbaMask(rgbaMask))
+    if !bytes.Equal(output, golden) {
+      t.Errorf("%s got %v, expected %v\n", testCase.name, output, golden)
+    }
+
+    // Empty buffers are a no-op.
+    testCase.maskFunc([]byte{}, BuildRgbaMask(rgbaMask))
+  }
+}
+
+func TestRgbaBitDepthMask(t *testing.T) {
+  cases := map[[4]int]uint32{
+    {8, 8, 8, 8}: 0xffffffff,
+    {0, 0, 0, 0}: 0x00000000,
+    {4, 5, 6, 8}: 0xf0f8fcff,
+    {1, 2, 3, 7}: 0x80c0e0fe,
+  }
+
+  for input, golden := range cases {
+    output := RgbaBitDepthMask(input[0], input[1], input[2], input[3])
+    if output != golden {
+      t.Errorf("Got unexpected value %X for input %v\n", output, input)
+    }
+  }
+
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  golden := make([]byte, len(image.Pix))
+  copy(golden, image.Pix)
+  MaskRgba(golden, BuildRgbaMask(0xf0f8fcff))
+  ReduceRgbaBitDepth(image.Pix, 4, 5, 6, 8)
+  if !bytes.Equal(image.Pix, golden) {
+    t.Error("ReduceRgbaBitDepth does not match MaskRgba")
+  }
+}
+
 func TestRgbPixelToHsl(t *testing.T) {
   cases := [][6]int {
     {255, 0, 0, 0, 255, 127},
@@ -115,3 +186,35 @@ func TestRgbaThreshold(t *testing.T) {
     t.Error("Pixel data hash mismatch. Got :", hexHash)
   }
 }
+
+func TestRgbaFiltersParallel(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  goldHsla := make([]byte, len(image.Pix))
+  RgbaToHsla(image.Pix, goldHsla)
+  goldThreshold := make([]byte, len(image.Pix))
+  copy(goldThreshold, image.Pix)
+  RgbaThreshold(goldThreshold, 230, 255, 150, 220, 0, 120)
+
+  previous := SetConcurrency(0)
+  defer SetConcurrency(previous)
+  hsla := make([]byte, len(image.Pix))
+  thresholded := make([]byte, len(image.Pix))
+  for workers := 1; workers <= 7; workers += 1 {
+    SetConcurrency(workers)
+
+    RgbaToHslaParallel(image.Pix, hsla)
+    if !bytes.Equal(hsla, goldHsla) {
+      t.Errorf("RgbaToHslaParallel mismatch with %d workers\n", workers)
+    }
+
+    copy(thresholded, image.Pix)
+    RgbaThresholdParallel(thresholded, 230, 255, 150, 220, 0, 120)
+    if !bytes.Equal(thresholded, goldThreshold) {
+      t.Errorf("RgbaThresholdParallel mismatch with %d workers\n", workers)
+    }
+  }
+}
@@ -0,0 +1,329 @@
+package imageutil
+
+import (
+  "fmt"
+  "image"
+  "sort"
+  "sync"
+)
+
+// Finder looks for a needle image in haystack images.
+// A Finder owns a copy of its needle, computes the needle's hash once, and
+// reuses scratch buffers across searches. Finders are safe for concurrent use
+// by multiple goroutines.
+type Finder struct {
+  // needle is the Finder's copy of the needle, with the mask applied.
+  needle RgbaView
+  // rgbaMask is applied to the haystack pixels during searches.
+  rgbaMask uint32
+  // hash is the needle's Rabin-Karp hash.
+  // Keyed Finders store their anchor's hash here.
+  hash uint32
+  // keyed is true for Finders created by NewKeyedFinder.
+  keyed bool
+  // alphaCutoff is the alpha below which keyed needle pixels are ignored.
+  alphaCutoff int
+  // anchor is the keyed needle's anchor rectangle.
+  anchor image.Rectangle
+  // scratch holds *[]byte scratch buffers for the searches.
+  scratch sync.Pool
+}
+
+// NewFinder creates a Finder that looks for a needle image.
+// The Finder copies the needle, so the needle's buffer can be reused after
+// this returns. It returns an error wrapping ErrInvalidDimensions or
+// ErrBufferTooSmall if the needle's dimensions don't match its buffer.
+func NewFinder(needle []byte, needleWidth int, needleHeight int) (*Finder,
+    error) {
+  return NewMaskedFinder(needle, needleWidth, needleHeight, 0xffffffff)
+}
+
+// NewMaskedFinder creates a Finder that looks for a masked needle image.
+// The mask is applied to the Finder's copy of the needle, and to haystack
+// pixels during searches, so the needle does not need to be masked in
+// advance. It returns an error wrapping ErrInvalidDimensions or
+// ErrBufferTooSmall if the needle's dimensions don't match its buffer.
+func NewMaskedFinder(needle []byte, needleWidth int, needleHeight int,
+    rgbaMask uint32) (*Finder, error) {
+  if err := validateRgba("Needle", needle, needleWidth,
+      needleHeight); err != nil {
+    return nil, err
+  }
+
+  var needleCopy []byte
+  NewRgbaView(needle, needleWidth, needleHeight).Materialize(&needleCopy)
+  if rgbaMask != 0xffffffff {
+    MaskRgba(needleCopy, BuildRgbaMask(rgbaMask))
+  }
+  finder := &Finder{
+    needle: NewRgbaView(needleCopy, needleWidth, needleHeight),
+    rgbaMask: rgbaMask,
+  }
+  finder.hash = finder.needle.HashForFindCrop()
+  return finder, nil
+}
+
+// NewKeyedFinder creates a Finder that looks for a needle image's keyed
+// pixels.
+// Needle pixels whose alpha is below the cutoff are ignored, like in
+// RgbaFindKeyedCrop. The Finder copies the needle, so the needle's buffer can
+// be reused after this returns. It returns an error wrapping
+// ErrInvalidDimensions or ErrBufferTooSmall if the needle's dimensions don't
+// match its buffer.
+func NewKeyedFinder(needle []byte, needleWidth int, needleHeight int,
+    alphaCutoff int) (*Finder, error) {
+  if err := validateRgba("Needle", needle, needleWidth,
+      needleHeight); err != nil {
+    return nil, err
+  }
+
+  var needleCopy []byte
+  NewRgbaView(needle, needleWidth, needleHeight).Materialize(&needleCopy)
+  finder := &Finder{
+    needle: NewRgbaView(needleCopy, needleWidth, needleHeight),
+    keyed: true,
+    alphaCutoff: alphaCutoff,
+  }
+  finder.anchor, finder.hash = finder.needle.HashForFindKeyedCrop(
+      alphaCutoff)
+  return finder, nil
+}
+
+// Hash returns the hash of the Finder's masked needle.
+// This is the hash that HashForRgbaFindCrop returns for the masked needle.
+// For keyed Finders, this is the anchor hash returned by
+// HashForRgbaFindKeyedCrop.
+func (f *Finder) Hash() uint32 {
+  return f.hash
+}
+
+// Find looks for the Finder's needle in a haystack image.
+// It returns the number of matches and the coordinates of the last match, like
+// RgbaFindCrop.
+func (f *Finder) Find(haystack []byte, hayWidth int,
+    hayHeight int) (int, int, int) {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  return f.FindInView(NewRgbaView(haystack, hayWidth, hayHeight))
+}
+
+// FindInView looks for the Finder's needle in a haystack view.
+// This is the view equivalent of Find. The match coordinates are relative to
+// the view's top-left corner.
+func (f *Finder) FindInView(haystack RgbaView) (int, int, int) {
+  scratch := f.getScratch(haystack.Width * 4)
+  defer f.scratch.Put(scratch)
+
+  if f.keyed {
+    return haystack.FindKeyedCrop(f.needle, f.alphaCutoff, f.anchor, f.hash,
+        *scratch)
+  }
+  if f.rgbaMask == 0xffffffff {
+    return haystack.FindCrop(f.needle, f.hash, *scratch)
+  }
+  return haystack.FindMaskedCrop(f.needle, f.rgbaMask, f.hash, *scratch)
+}
+
+// FindInRect looks for the Finder's needle inside a search rectangle of a
+// haystack image.
+// The search rectangle is handled like in RgbaFindCropInRect, and the match
+// coordinates are relative to the haystack's top-left corner.
+func (f *Finder) FindInRect(haystack []byte, hayWidth int, hayHeight int,
+    searchRect image.Rectangle) (int, int, int) {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  region, origin := NewRgbaView(haystack, hayWidth, hayHeight).searchRegion(
+      searchRect)
+  count, matchLeft, matchTop := f.FindInView(region)
+  return offsetMatch(count, matchLeft, matchTop, origin)
+}
+
+// getScratch returns a scratch buffer with at least the given capacity.
+// The buffer should be returned to the pool when the search completes.
+func (f *Finder) getScratch(size int) *[]byte {
+  scratch, _ := f.scratch.Get().(*[]byte)
+  if scratch == nil {
+    scratch = new([]byte)
+  }
+  resizeBuffer(scratch, size)
+  return scratch
+}
+
+// FinderMatch is a needle match found by a MultiFinder.
+type FinderMatch struct {
+  // Needle is the needle's index in the slice passed to NewMultiFinder.
+  Needle int
+  // Left is the X coordinate of the match's top-left pixel.
+  Left int
+  // Top is the Y coordinate of the match's top-left pixel.
+  Top int
+}
+
+// MultiFinder looks for many needle images in haystack images at once.
+// Needles that have the same size share a single Rabin-Karp pass over the
+// haystack, so searching for many needles costs about as much as searching
+// for one needle of each size. MultiFinders own copies of their needles, and
+// are safe for concurrent use by multiple goroutines.
+type MultiFinder struct {
+  // groups holds the needles, grouped by size.
+  groups []needleGroup
+  // scratch holds *multiFinderScratch buffers for the searches.
+  scratch sync.Pool
+}
+
+// needleGroup holds the MultiFinder needles that have the same size.
+type needleGroup struct {
+  width int
+  height int
+  // pixels holds the needles' pixels, one needle after another, in the order
+  // of their hashes.
+  pixels []byte
+  // hashes holds the needles' hashes, in ascending order.
+  hashes []uint32
+  // needles maps positions in the group to needle indexes.
+  needles []int
+}
+
+// multiFinderScratch holds the buffers used by a MultiFinder search.
+type multiFinderScratch struct {
+  // hashes holds the Rabin-Karp column hashes.
+  hashes []byte
+  // matches holds (needle, left, top) triples written by the search kernel.
+  matches []int32
+}
+
+// NewMultiFinder creates a MultiFinder that looks for needle views.
+// The MultiFinder copies the needles' pixels, so the needles' buffers can be
+// reused after this returns. It returns an error wrapping ErrInvalidDimensions
+// or ErrBufferTooSmall if a needle is empty or inconsistent.
+func NewMultiFinder(needles []RgbaView) (*MultiFinder, error) {
+  var sizes []image.Point
+  sizeNeedles := make(map[image.Point][]int)
+  for i, needle := range needles {
+    name := fmt.Sprintf("Needle %d", i)
+    if err := needle.validate(name); err != nil {
+      return nil, err
+    }
+    if needle.Empty() {
+      return nil, fmt.Errorf("%s is empty: %w", name, ErrInvalidDimensions)
+    }
+
+    size := image.Pt(needle.Width, needle.Height)
+    if _, ok := sizeNeedles[size]; !ok {
+      sizes = append(sizes, size)
+    }
+    sizeNeedles[size] = append(sizeNeedles[size], i)
+  }
+
+  finder := &MultiFinder{groups: make([]needleGroup, len(sizes))}
+  for i, size := range sizes {
+    group := &finder.groups[i]
+    group.width, group.height = size.X, size.Y
+    group.needles = sizeNeedles[size]
+
+    hashes := make(map[int]uint32, len(group.needles))
+    for _, needle := range group.needles {
+      hashes[needle] = needles[needle].HashForFindCrop()
+    }
+    sort.SliceStable(group.needles, func(a int, b int) bool {
+      return hashes[group.needles[a]] < hashes[group.needles[b]]
+    })
+
+    needleSize := size.X * size.Y * 4
+    group.pixels = make([]byte, needleSize * len(group.needles))
+    group.hashes = make([]uint32, len(group.needles))
+    for j, needle := range group.needles {
+      target := group.pixels[j * needleSize:(j + 1) * needleSize]
+      needles[needle].Materialize(&target)
+      group.hashes[j] = hashes[needle]
+    }
+  }
+  return finder, nil
+}
+
+// Find looks for the MultiFinder's needles in a haystack image.
+// It returns all the matches, sorted by their Top, Left and Needle values.
+func (f *MultiFinder) Find(haystack []byte, hayWidth int,
+    hayHeight int) []FinderMatch {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  return f.FindInView(NewRgbaView(haystack, hayWidth, hayHeight))
+}
+
+// FindInView looks for the MultiFinder's needles in a haystack view.
+// This is the view equivalent of Find. The match coordinates are relative to
+// the view's top-left corner.
+func (f *MultiFinder) FindInView(haystack RgbaView) []FinderMatch {
+  haystack.check("Haystack view")
+
+  scratch, _ := f.scratch.Get().(*multiFinderScratch)
+  if scratch == nil {
+    scratch = &multiFinderScratch{matches: make([]int32, 3 * 64)}
+  }
+  defer f.scratch.Put(scratch)
+  resizeBuffer(&scratch.hashes, haystack.Width * 4)
+
+  var matches []FinderMatch
+  for _, group := range f.groups {
+    if group.width > haystack.Width || group.height > haystack.Height {
+      continue
+    }
+
+    count := rgbaFindCrops(haystack, group.pixels, group.width, group.height,
+        group.hashes, scratch.hashes, scratch.matches)
+    if count > len(scratch.matches) / 3 {
+      // Searches are deterministic, so repeating the search with a larger
+      // buffer yields all the matches.
+      scratch.matches = make([]int32, 3 * count)
+      rgbaFindCrops(haystack, group.pixels, group.width, group.height,
+          group.hashes, scratch.hashes, scratch.matches)
+    }
+    for i := 0; i < count; i += 1 {
+      match := scratch.matches[3 * i:3 * i + 3]
+      matches = append(matches, FinderMatch{
+          Needle: group.needles[match[0]], Left: int(match[1]),
+          Top: int(match[2])})
+    }
+  }
+
+  sort.Slice(matches, func(i int, j int) bool {
+    if matches[i].Top != matches[j].Top {
+      return matches[i].Top < matches[j].Top
+    }
+    if matches[i].Left != matches[j].Left {
+      return matches[i].Left < matches[j].Left
+    }
+    return matches[i].Needle < matches[j].Needle
+  })
+  return matches
+}
+
+// FindInRect looks for the MultiFinder's needles inside a search rectangle of
+// a haystack image.
+// The search rectangle is handled like in RgbaFindCropInRect, and the match
+// coordinates are relative to the haystack's top-left corner.
+func (f *MultiFinder) FindInRect(haystack []byte, hayWidth int,
+    hayHeight int, searchRect image.Rectangle) []FinderMatch {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  region, origin := NewRgbaView(haystack, hayWidth, hayHeight).searchRegion(
+      searchRect)
+  matches := f.FindInView(region)
+  for i := range matches {
+    matches[i].Left += origin.X
+    matches[i].Top += origin.Y
+  }
+  return matches
+}
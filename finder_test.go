@@ -0,0 +1,178 @@
+package imageutil
+
+import (
+  "errors"
+  "image"
+  "reflect"
+  "sort"
+  "sync"
+  "testing"
+)
+
+func TestFinder(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  var needle []byte
+  CropRgba(image.Pix, width, height, 300, 200, 16, 12, &needle)
+  finder, err := NewFinder(needle, 16, 12)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if finder.Hash() != HashForRgbaFindCrop(needle, 16, 12) {
+    t.Error("Finder hash does not match HashForRgbaFindCrop")
+  }
+
+  // The Finder must not depend on the caller's needle buffer.
+  needle[0] ^= 0xff
+  count, matchX, matchY := finder.Find(image.Pix, width, height)
+  if count != 1 || matchX != 300 || matchY != 200 {
+    t.Errorf("Find returned count %d, matchX %d, matchY %d", count, matchX,
+        matchY)
+  }
+
+  // Smaller haystacks re-use the pooled scratch, larger ones grow it.
+  view, _ := ViewRgba(image.Pix, width, height, 280, 190, 100, 50)
+  count, matchX, matchY = finder.FindInView(view)
+  if count != 1 || matchX != 20 || matchY != 10 {
+    t.Errorf("FindInView returned count %d, matchX %d, matchY %d", count,
+        matchX, matchY)
+  }
+
+  if _, err := NewFinder(needle, 16, 13); err == nil {
+    t.Error("NewFinder accepted a short needle buffer")
+  }
+}
+
+func TestMaskedFinder(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  mask := uint32(0xe0e0e0ff)
+  var needle []byte
+  CropRgba(image.Pix, width, height, 120, 40, 10, 10, &needle)
+  finder, err := NewMaskedFinder(needle, 10, 10, mask)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // The golden results come from the lower-level functions.
+  MaskRgba(needle, BuildRgbaMask(mask))
+  goldHash := HashForRgbaFindCrop(needle, 10, 10)
+  if finder.Hash() != goldHash {
+    t.Error("Masked Finder hash does not match the masked needle hash")
+  }
+  scratch := make([]byte, width * 4)
+  goldCount, goldX, goldY := RgbaFindMaskedCrop(image.Pix, width, height,
+      needle, 10, 10, mask, goldHash, scratch)
+
+  // Concurrent searches share the scratch pool.
+  var wait sync.WaitGroup
+  for i := 0; i < 8; i += 1 {
+    wait.Add(1)
+    go func() {
+      defer wait.Done()
+      count, matchX, matchY := finder.Find(image.Pix, width, height)
+      if count != goldCount || matchX != goldX || matchY != goldY {
+        t.Errorf("Find returned count %d, matchX %d, matchY %d", count,
+            matchX, matchY)
+      }
+    }()
+  }
+  wait.Wait()
+}
+
+func TestMultiFinder(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  // A solid area yields many more matches than the search buffers initially
+  // hold.
+  solid, _ := ViewRgba(fruits.Pix, width, height, 10, 10, 40, 30)
+  solid.Mask(BuildRgbaMask(0x000000ff))
+
+  fullView := NewRgbaView(fruits.Pix, width, height)
+  rects := [][4]int{
+    {300, 200, 16, 12}, {20, 30, 16, 12}, {490, 400, 16, 12},
+    {120, 40, 10, 10}, {400, 300, 10, 10}, {12, 12, 4, 4},
+    {300, 200, 16, 12}, {0, 0, 7, 3},
+  }
+  needles := make([]RgbaView, len(rects))
+  for i, rect := range rects {
+    needles[i], _ = fullView.Crop(rect[0], rect[1], rect[2], rect[3])
+  }
+  // A needle that is not in the image.
+  var missing []byte
+  needles[7].Materialize(&missing)
+  missing[0] ^= 0x80
+  needles[7] = NewRgbaView(missing, 7, 3)
+
+  finder, err := NewMultiFinder(needles)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // The golden matches come from searching for each needle separately.
+  var goldMatches []FinderMatch
+  for i, needle := range needles {
+    for top := 0; top + needle.Height <= height; top += 1 {
+      for left := 0; left + needle.Width <= width; left += 1 {
+        if fullView.CheckCrop(needle, left, top) {
+          goldMatches = append(goldMatches, FinderMatch{i, left, top})
+        }
+      }
+    }
+  }
+  sort.Slice(goldMatches, func(i int, j int) bool {
+    a, b := goldMatches[i], goldMatches[j]
+    if a.Top != b.Top {
+      return a.Top < b.Top
+    }
+    if a.Left != b.Left {
+      return a.Left < b.Left
+    }
+    return a.Needle < b.Needle
+  })
+  if len(goldMatches) < 1000 {
+    t.Fatal("Too few golden matches: ", len(goldMatches))
+  }
+
+  for i := 0; i < 2; i += 1 {
+    // The second search re-uses the grown match buffer.
+    matches := finder.Find(fruits.Pix, width, height)
+    if !reflect.DeepEqual(matches, goldMatches) {
+      t.Errorf("Search %d returned %d matches, expected %d\n", i,
+          len(matches), len(goldMatches))
+    }
+  }
+
+  // Region searches only return the matches inside the search rectangle.
+  var regionMatches []FinderMatch
+  for _, match := range goldMatches {
+    needle := needles[match.Needle]
+    if match.Left >= 100 && match.Top >= 50 &&
+        match.Left + needle.Width <= 400 && match.Top + needle.Height <= 300 {
+      regionMatches = append(regionMatches, match)
+    }
+  }
+  matches := finder.FindInRect(fruits.Pix, width, height, image.Rect(100, 50,
+      400, 300))
+  if !reflect.DeepEqual(matches, regionMatches) {
+    t.Errorf("FindInRect returned %d matches instead of %d\n", len(matches),
+        len(regionMatches))
+  }
+
+  if _, err := NewMultiFinder([]RgbaView{needles[0], {}}); !errors.Is(err,
+      ErrInvalidDimensions) {
+    t.Error("Empty needle did not return ErrInvalidDimensions: ", err)
+  }
+}
@@ -1,2 +1,16 @@
 // Package imageutil is a collection of low-level image processing tools.
+//
+// The image processing kernels are implemented in C and called via cgo. When
+// cgo is disabled, for example in CGO_ENABLED=0 builds, the package uses pure
+// Go kernels that produce identical results.
 package imageutil
+
+// resizeBuffer sets a target slice's length to the given size.
+// If the slice's capacity is too small, the slice is re-created.
+func resizeBuffer(target *[]byte, size int) {
+  if cap(*target) < size {
+    *target = make([]byte, size, size)
+  } else if len(*target) != size {
+    *target = (*target)[:size]
+  }
+}
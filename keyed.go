@@ -0,0 +1,264 @@
+package imageutil
+
+import (
+  "image"
+)
+
+// Keyed matchers treat a needle's alpha channel as a per-pixel mask. The
+// needle pixels whose alpha is at least the alpha cutoff are keyed pixels, and
+// only their red, green and blue channels are matched against the haystack.
+// The other needle pixels are "don't care" pixels, so irregularly shaped
+// needles can be matched over any background.
+
+// RgbaCheckKeyedCrop returns true if a needle's keyed pixels match an image.
+// This is image pattern-matching, but only aligns the pattern with the image
+// in one predetermined position. Needle pixels whose alpha is below the cutoff
+// are ignored.
+func RgbaCheckKeyedCrop(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleLeft int,
+    needleTop int, alphaCutoff int) bool {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).CheckKeyedCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop,
+      alphaCutoff)
+}
+
+// CheckKeyedCrop returns true if a needle view's keyed pixels match this view.
+// This is the view equivalent of RgbaCheckKeyedCrop.
+func (v RgbaView) CheckKeyedCrop(needle RgbaView, needleLeft int,
+    needleTop int, alphaCutoff int) bool {
+  v.check("Haystack view")
+  needle.check("Needle view")
+
+  // NOTE: These checks are also intended to prevent segmentation faults, but
+  //       we don't have to panic here.
+  if needleLeft < 0 || needleLeft + needle.Width > v.Width {
+    return false
+  }
+  if needleTop < 0 || needleTop + needle.Height > v.Height {
+    return false
+  }
+  if needle.Empty() {
+    return true
+  }
+
+  return rgbaCheckKeyedCrop(v, needle, needleLeft, needleTop, alphaCutoff)
+}
+
+// RgbaDiffKeyedCrop diffs a needle's keyed pixels with an image.
+// It returns the sum of absolute red, green and blue differences. Needle
+// pixels whose alpha is below the cutoff are ignored.
+func RgbaDiffKeyedCrop(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleLeft int,
+    needleTop int, alphaCutoff int) int64 {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).DiffKeyedCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop,
+      alphaCutoff)
+}
+
+// DiffKeyedCrop diffs a needle view's keyed pixels with this view.
+// This is the view equivalent of RgbaDiffKeyedCrop.
+func (v RgbaView) DiffKeyedCrop(needle RgbaView, needleLeft int,
+    needleTop int, alphaCutoff int) int64 {
+  v.check("Haystack view")
+  needle.check("Needle view")
+
+  // NOTE: These checks are also intended to prevent segmentation faults, but
+  //       we don't have to panic here.
+  if needleLeft < 0 || needleLeft + needle.Width > v.Width {
+    return 0
+  }
+  if needleTop < 0 || needleTop + needle.Height > v.Height {
+    return 0
+  }
+  if needle.Empty() {
+    return 0
+  }
+
+  return rgbaDiffKeyedCrop(v, needle, needleLeft, needleTop, alphaCutoff)
+}
+
+// HashForRgbaFindKeyedCrop computes the anchor and hash needed by
+// RgbaFindKeyedCrop.
+// The rolling hash used by the find functions can't skip "don't care" pixels,
+// so it only covers the needle's anchor, which is the largest rectangle of
+// keyed pixels in the needle. The anchor's coordinates are relative to the
+// needle's top-left corner. The anchor is empty if the needle has no keyed
+// pixels.
+func HashForRgbaFindKeyedCrop(needle []byte, needleWidth int,
+    needleHeight int, alphaCutoff int) (image.Rectangle, uint32) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(needle, needleWidth,
+      needleHeight).HashForFindKeyedCrop(alphaCutoff)
+}
+
+// HashForFindKeyedCrop computes the anchor and hash needed by FindKeyedCrop.
+// This is the view equivalent of HashForRgbaFindKeyedCrop.
+func (v RgbaView) HashForFindKeyedCrop(alphaCutoff int) (image.Rectangle,
+    uint32) {
+  v.check("Needle view")
+  anchor := keyedAnchor(v, alphaCutoff)
+  if anchor.Empty() {
+    return image.Rectangle{}, 0
+  }
+
+  anchorView, _ := v.Crop(anchor.Min.X, anchor.Min.Y, anchor.Dx(),
+      anchor.Dy())
+  return anchor, hashForRgbaFindCrop(anchorView, packRgba(0xffffff00))
+}
+
+// RgbaFindKeyedCrop looks for a needle's keyed pixels in a haystack image.
+// It returns the number of matches and the coordinates of the last match.
+// Needle pixels whose alpha is below the cutoff are ignored. The scratch space
+// capacity must be at least 4 * hayWidth. The anchor and its hash can be
+// computed by HashForRgbaFindKeyedCrop. Needles without keyed pixels have an
+// empty anchor, and match at every position where they fit in the haystack.
+func RgbaFindKeyedCrop(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, alphaCutoff int,
+    anchor image.Rectangle, anchorHash uint32,
+    scratch []byte) (int, int, int) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindKeyedCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), alphaCutoff, anchor,
+      anchorHash, scratch)
+}
+
+// FindKeyedCrop looks for a needle view's keyed pixels in this view.
+// This is the view equivalent of RgbaFindKeyedCrop. The scratch space
+// capacity must be at least 4 * v.Width. The match coordinates are relative
+// to the view's top-left corner.
+func (v RgbaView) FindKeyedCrop(needle RgbaView, alphaCutoff int,
+    anchor image.Rectangle, anchorHash uint32,
+    scratch []byte) (int, int, int) {
+  v.check("Haystack view")
+  needle.check("Needle view")
+  if cap(scratch) < v.Width * 4 {
+    panic("Insufficent scratch buffer capacity")
+  }
+  if !anchor.In(image.Rect(0, 0, needle.Width, needle.Height)) {
+    panic("Anchor rectangle exceeds needle bounds")
+  }
+  if needle.Empty() || needle.Width > v.Width || needle.Height > v.Height {
+    return 0, 0, 0
+  }
+  if anchor.Empty() {
+    // Needles without keyed pixels match everywhere.
+    lastLeft, lastTop := v.Width - needle.Width, v.Height - needle.Height
+    return (lastLeft + 1) * (lastTop + 1), lastLeft, lastTop
+  }
+
+  return rgbaFindKeyedCrop(v, needle, anchor, anchorHash, alphaCutoff,
+      scratch[:cap(scratch)])
+}
+
+// RgbaFindKeyedCropInRect looks for a needle's keyed pixels inside a search
+// rectangle of a haystack image.
+// This is RgbaFindKeyedCrop, restricted to the matches that lie entirely
+// inside the search rectangle. The search rectangle is handled like in
+// RgbaFindCropInRect. The scratch space capacity must be at least
+// 4 * searchRect.Dx().
+func RgbaFindKeyedCropInRect(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, alphaCutoff int,
+    anchor image.Rectangle, anchorHash uint32, searchRect image.Rectangle,
+    scratch []byte) (int, int, int) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindKeyedCropInRect(
+      NewRgbaView(needle, needleWidth, needleHeight), alphaCutoff, anchor,
+      anchorHash, searchRect, scratch)
+}
+
+// FindKeyedCropInRect looks for a needle view's keyed pixels inside a search
+// rectangle of this view.
+// This is the view equivalent of RgbaFindKeyedCropInRect. The search rectangle
+// and the match coordinates are relative to the view's top-left corner.
+func (v RgbaView) FindKeyedCropInRect(needle RgbaView, alphaCutoff int,
+    anchor image.Rectangle, anchorHash uint32, searchRect image.Rectangle,
+    scratch []byte) (int, int, int) {
+  v.check("Haystack view")
+  region, origin := v.searchRegion(searchRect)
+  count, matchLeft, matchTop := region.FindKeyedCrop(needle, alphaCutoff,
+      anchor, anchorHash, scratch)
+  return offsetMatch(count, matchLeft, matchTop, origin)
+}
+
+// keyedAnchor returns the largest rectangle of keyed pixels in a needle.
+// This is the classic maximal rectangle search, which scans the needle's rows
+// while tracking the height of the keyed column above each pixel.
+func keyedAnchor(needle RgbaView, alphaCutoff int) image.Rectangle {
+  var anchor image.Rectangle
+  anchorArea := 0
+  heights := make([]int, needle.Width)
+  stack := make([]int, 0, needle.Width)
+  for y := 0; y < needle.Height; y += 1 {
+    row := needle.Pix[needle.PixOffset(0, y):]
+    for x := range heights {
+      if int(row[x * 4 + 3]) >= alphaCutoff {
+        heights[x] += 1
+      } else {
+        heights[x] = 0
+      }
+    }
+
+    // The stack holds columns with increasing heights. Popping a column
+    // yields the widest rectangle with that column's height.
+    stack = stack[:0]
+    for x := 0; x <= needle.Width; x += 1 {
+      height := 0
+      if x < needle.Width {
+        height = heights[x]
+      }
+      for len(stack) > 0 && heights[stack[len(stack) - 1]] >= height {
+        column := stack[len(stack) - 1]
+        stack = stack[:len(stack) - 1]
+        left := 0
+        if len(stack) > 0 {
+          left = stack[len(stack) - 1] + 1
+        }
+        if area := heights[column] * (x - left); area > anchorArea {
+          anchorArea = area
+          anchor = image.Rect(left, y - heights[column] + 1, x, y + 1)
+        }
+      }
+      stack = append(stack, x)
+    }
+  }
+  return anchor
+}
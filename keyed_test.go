@@ -0,0 +1,191 @@
+package imageutil
+
+import (
+  "image"
+  "testing"
+)
+
+// keyedNeedle crops a needle with transparent corners out of an image.
+// The transparent pixels are filled with noise, which keyed matchers must
+// ignore.
+func keyedNeedle(rgbaImage *image.RGBA, x int, y int, width int,
+    height int) []byte {
+  var needle []byte
+  CropRgba(rgbaImage.Pix, rgbaImage.Bounds().Dx(), rgbaImage.Bounds().Dy(),
+      x, y, width, height, &needle)
+  for row := 0; row < height; row += 1 {
+    for column := 0; column < width; column += 1 {
+      dx, dy := 2 * column + 1 - width, 2 * row + 1 - height
+      offset := (row * width + column) * 4
+      if dx * dx + dy * dy <= width * width {
+        needle[offset + 3] = 0xff
+        continue
+      }
+      needle[offset] ^= 0x5a
+      needle[offset + 1] = byte(column * 7)
+      needle[offset + 3] = byte(row * 3)
+    }
+  }
+  return needle
+}
+
+func TestRgbaCheckKeyedCrop(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+  needle := keyedNeedle(fruits, 200, 150, 24, 20)
+
+  if !RgbaCheckKeyedCrop(fruits.Pix, width, height, needle, 24, 20, 200, 150,
+      128) {
+    t.Error("RgbaCheckKeyedCrop did not detect correctly aligned crop")
+  }
+  if RgbaCheckKeyedCrop(fruits.Pix, width, height, needle, 24, 20, 201, 150,
+      128) {
+    t.Error("RgbaCheckKeyedCrop did not bounce crop misaligned by (1, 0)")
+  }
+  if RgbaCheckMaskedCrop(fruits.Pix, width, height, needle, 24, 20, 200, 150,
+      0xffffff00) {
+    t.Error("RgbaCheckMaskedCrop matched the needle's transparent pixels")
+  }
+  if RgbaCheckKeyedCrop(fruits.Pix, width, height, needle, 24, 20, 200, 150,
+      0) {
+    t.Error("RgbaCheckKeyedCrop with a zero cutoff ignored the noise")
+  }
+  if RgbaCheckKeyedCrop(fruits.Pix, width, height, needle, 24, 20, 500, 150,
+      128) {
+    t.Error("RgbaCheckKeyedCrop accepted an out of bounds crop")
+  }
+
+  if diff := RgbaDiffKeyedCrop(fruits.Pix, width, height, needle, 24, 20, 200,
+      150, 128); diff != 0 {
+    t.Error("Non-zero RgbaDiffKeyedCrop for correctly aligned crop: ", diff)
+  }
+  noiseDiff := RgbaDiffKeyedCrop(fruits.Pix, width, height, needle, 24, 20,
+      200, 150, 0)
+  if noiseDiff == 0 {
+    t.Error("RgbaDiffKeyedCrop with a zero cutoff ignored the noise")
+  }
+  if diff := RgbaDiffKeyedCrop(fruits.Pix, width, height, needle, 24, 20, 199,
+      150, 128); diff == 0 {
+    t.Error("Zero RgbaDiffKeyedCrop for crop misaligned by (-1, 0)")
+  }
+}
+
+func TestRgbaFindKeyedCrop(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+  needle := keyedNeedle(fruits, 200, 150, 24, 20)
+
+  // Stamp the needle's keyed pixels over different backgrounds.
+  for _, corner := range []image.Point{{50, 60}, {300, 400}, {488, 492}} {
+    for row := 0; row < 20; row += 1 {
+      for column := 0; column < 24; column += 1 {
+        offset := (row * 24 + column) * 4
+        if needle[offset + 3] < 128 {
+          continue
+        }
+        copy(fruits.Pix[fruits.PixOffset(corner.X + column,
+            corner.Y + row):], needle[offset:offset + 3])
+      }
+    }
+  }
+
+  anchor, hash := HashForRgbaFindKeyedCrop(needle, 24, 20, 128)
+  if anchor.Dx() * anchor.Dy() < 24 * 20 / 2 {
+    t.Error("Anchor too small: ", anchor)
+  }
+  for y := anchor.Min.Y; y < anchor.Max.Y; y += 1 {
+    for x := anchor.Min.X; x < anchor.Max.X; x += 1 {
+      if needle[(y * 24 + x) * 4 + 3] < 128 {
+        t.Fatalf("Anchor %v contains transparent pixel (%d, %d)", anchor, x,
+            y)
+      }
+    }
+  }
+
+  scratch := make([]byte, width * 4)
+  count, matchX, matchY := RgbaFindKeyedCrop(fruits.Pix, width, height,
+      needle, 24, 20, 128, anchor, hash, scratch)
+  if count != 4 || matchX != 488 || matchY != 492 {
+    t.Errorf("RgbaFindKeyedCrop returned count %d, matchX %d, matchY %d",
+        count, matchX, matchY)
+  }
+
+  count, matchX, matchY = RgbaFindKeyedCropInRect(fruits.Pix, width, height,
+      needle, 24, 20, 128, anchor, hash, image.Rect(0, 0, 400, 450), scratch)
+  if count != 3 || matchX != 300 || matchY != 400 {
+    t.Errorf("RgbaFindKeyedCropInRect returned count %d, matchX %d, " +
+        "matchY %d", count, matchX, matchY)
+  }
+  count, _, _ = RgbaFindKeyedCropInRect(fruits.Pix, width, height, needle,
+      24, 20, 128, anchor, hash, image.Rect(301, 0, 600, 600), scratch)
+  if count != 1 {
+    t.Error("RgbaFindKeyedCropInRect found the needle outside its search " +
+        "rectangle: ", count)
+  }
+
+  finder, err := NewKeyedFinder(needle, 24, 20, 128)
+  if err != nil {
+    t.Fatal(err)
+  }
+  count, matchX, matchY = finder.FindInRect(fruits.Pix, width, height,
+      image.Rect(0, 0, 400, 450))
+  if count != 3 || matchX != 300 || matchY != 400 {
+    t.Errorf("Keyed Finder returned count %d, matchX %d, matchY %d", count,
+        matchX, matchY)
+  }
+
+  // Needles without keyed pixels match everywhere.
+  anchor, hash = HashForRgbaFindKeyedCrop(needle, 24, 20, 256)
+  if !anchor.Empty() {
+    t.Error("Non-empty anchor for needle without keyed pixels: ", anchor)
+  }
+  count, matchX, matchY = RgbaFindKeyedCrop(fruits.Pix, width, height,
+      needle, 24, 20, 256, anchor, hash, scratch)
+  if count != 489 * 493 || matchX != 488 || matchY != 492 {
+    t.Errorf("Fully transparent needle returned count %d, matchX %d, " +
+        "matchY %d", count, matchX, matchY)
+  }
+  count, matchX, matchY = RgbaFindKeyedCropInRect(fruits.Pix, width, height,
+      needle, 24, 20, 256, anchor, hash, image.Rect(10, 20, 44, 50), scratch)
+  if count != 11 * 11 || matchX != 20 || matchY != 30 {
+    t.Errorf("Fully transparent needle in rectangle returned count %d, " +
+        "matchX %d, matchY %d", count, matchX, matchY)
+  }
+}
+
+func TestKeyedAnchor(t *testing.T) {
+  cases := []struct {
+    rows []string
+    anchor image.Rectangle
+  } {
+    { []string{"...", "...", "..."}, image.Rectangle{} },
+    { []string{"#..", "...", "..."}, image.Rect(0, 0, 1, 1) },
+    { []string{"###", "###", "###"}, image.Rect(0, 0, 3, 3) },
+    { []string{".##.", "####", "####", ".##."}, image.Rect(0, 1, 4, 3) },
+    { []string{".##..", ".##.#", ".####", "..###"}, image.Rect(1, 0, 3, 3) },
+    { []string{"#.###", "#####", "#.###"}, image.Rect(2, 0, 5, 3) },
+  }
+
+  for _, testCase := range cases {
+    width, height := len(testCase.rows[0]), len(testCase.rows)
+    needle := make([]byte, width * height * 4)
+    for y, row := range testCase.rows {
+      for x, cell := range row {
+        if cell == '#' {
+          needle[(y * width + x) * 4 + 3] = 0xff
+        }
+      }
+    }
+    anchor := keyedAnchor(NewRgbaView(needle, width, height), 1)
+    if anchor != testCase.anchor {
+      t.Errorf("Anchor for %v is %v instead of %v", testCase.rows, anchor,
+          testCase.anchor)
+    }
+  }
+}
@@ -0,0 +1,526 @@
+package imageutil
+
+import (
+  "errors"
+  "math"
+  "math/bits"
+  "math/rand"
+  "sort"
+)
+
+// The keypoint functions locate objects that template matching can't find,
+// because they are rotated, partially occluded or seen in perspective.
+// Corners are detected with the FAST-9 test, ranked by their Harris response,
+// and described by rotated BRIEF descriptors, like in ORB. Descriptors are
+// matched by their Hamming distance, and RANSAC estimates the homography that
+// maps one image's keypoints onto another image's keypoints.
+
+// Keypoint is a corner found by FindKeypoints.
+type Keypoint struct {
+  // X is the corner's column.
+  X int
+  // Y is the corner's row.
+  Y int
+  // Score is the corner's Harris response. Higher is better.
+  Score float64
+  // Angle is the corner's orientation, in radians.
+  // The orientation points from the corner towards its patch's intensity
+  // centroid, and makes descriptors invariant to rotation.
+  Angle float64
+}
+
+// Descriptor is a 256-bit binary description of a keypoint's surroundings.
+type Descriptor [4]uint64
+
+// KeypointMatch pairs up keypoints that have similar descriptors.
+type KeypointMatch struct {
+  // Query is the keypoint's index in the query descriptors.
+  Query int
+  // Train is the keypoint's index in the train descriptors.
+  Train int
+  // Distance is the Hamming distance between the descriptors.
+  Distance int
+}
+
+// Homography is a perspective transformation between two images.
+// The matrix is stored in row-major order, and its last element is 1.
+type Homography [9]float64
+
+// ErrNoHomography is returned when the point matches don't determine a
+// homography.
+var ErrNoHomography = errors.New(
+    "Not enough consistent point matches for a homography")
+
+const (
+  // keypointBorder is the distance between keypoints and the image's edges.
+  // This leaves room for the orientation and descriptor patches.
+  keypointBorder = 16
+  // keypointPatchRadius is the radius of the orientation patch.
+  keypointPatchRadius = 15
+  // briefSampleRadius bounds the distance between the descriptor's sampling
+  // points and the keypoint, so rotated samples stay inside the border.
+  briefSampleRadius = 11
+  // harrisRadius is the radius of the window used by the Harris response.
+  harrisRadius = 3
+  // harrisK is the sensitivity parameter in the Harris response.
+  harrisK = 0.04
+)
+
+// RgbaFindKeypoints returns the corners in an RGBA image.
+// A pixel is a corner if 9 contiguous pixels on the radius 3 circle around it
+// are all brighter, or all darker, than the pixel by more than the threshold.
+// Only local maxima are kept, and the corners are sorted by their Harris
+// response, from best to worst. At most maxKeypoints corners are returned,
+// unless maxKeypoints is zero or negative. Corners are not reported near the
+// image's edges, where their descriptors would not fit.
+func RgbaFindKeypoints(rgbaImage []byte, width int, height int,
+    threshold int, maxKeypoints int) []Keypoint {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(rgbaImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return NewRgbaView(rgbaImage, width, height).FindKeypoints(threshold,
+      maxKeypoints)
+}
+
+// FindKeypoints returns the corners in a view.
+// This is the view equivalent of RgbaFindKeypoints. The corners' coordinates
+// are relative to the view's top-left corner.
+func (v RgbaView) FindKeypoints(threshold int, maxKeypoints int) []Keypoint {
+  v.check("View")
+  if v.Width <= 2 * keypointBorder || v.Height <= 2 * keypointBorder {
+    return nil
+  }
+
+  gray := v.gray()
+  scores := make([]int32, v.Width * v.Height)
+  grayFastScores(gray, scores, v.Width, v.Height, threshold, keypointBorder)
+
+  var keypoints []Keypoint
+  for y := keypointBorder; y < v.Height - keypointBorder; y += 1 {
+    for x := keypointBorder; x < v.Width - keypointBorder; x += 1 {
+      if !fastLocalMaximum(scores, v.Width, x, y) {
+        continue
+      }
+      keypoints = append(keypoints, Keypoint{X: x, Y: y,
+          Score: harrisResponse(gray, v.Width, x, y),
+          Angle: patchAngle(gray, v.Width, x, y)})
+    }
+  }
+
+  sort.SliceStable(keypoints, func(i int, j int) bool {
+    return keypoints[i].Score > keypoints[j].Score
+  })
+  if maxKeypoints > 0 && len(keypoints) > maxKeypoints {
+    keypoints = keypoints[:maxKeypoints]
+  }
+  return keypoints
+}
+
+// RgbaDescribeKeypoints computes the descriptors of keypoints in an RGBA
+// image.
+// It returns one descriptor for each keypoint. The descriptors are rotated by
+// the keypoints' angles, so they can be matched across rotated images.
+func RgbaDescribeKeypoints(rgbaImage []byte, width int, height int,
+    keypoints []Keypoint) []Descriptor {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(rgbaImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return NewRgbaView(rgbaImage, width, height).DescribeKeypoints(keypoints)
+}
+
+// DescribeKeypoints computes the descriptors of keypoints in a view.
+// This is the view equivalent of RgbaDescribeKeypoints. Keypoints that are
+// close to the view's edges are described using the edge pixels.
+func (v RgbaView) DescribeKeypoints(keypoints []Keypoint) []Descriptor {
+  v.check("View")
+  if len(keypoints) == 0 || v.Empty() {
+    return nil
+  }
+
+  gray := v.gray()
+  descriptors := make([]Descriptor, len(keypoints))
+  for i, keypoint := range keypoints {
+    sin, cos := math.Sincos(keypoint.Angle)
+    rotate := func(x int, y int) (int, int) {
+      fx, fy := float64(x), float64(y)
+      return keypoint.X + int(math.Round(cos * fx - sin * fy)),
+          keypoint.Y + int(math.Round(sin * fx + cos * fy))
+    }
+    for bit, pair := range briefPattern {
+      x1, y1 := rotate(pair[0], pair[1])
+      x2, y2 := rotate(pair[2], pair[3])
+      if boxSum(gray, v.Width, v.Height, x1, y1) <
+          boxSum(gray, v.Width, v.Height, x2, y2) {
+        descriptors[i][bit / 64] |= 1 << (bit % 64)
+      }
+    }
+  }
+  return descriptors
+}
+
+// Distance returns the Hamming distance between two descriptors.
+func (d Descriptor) Distance(other Descriptor) int {
+  distance := 0
+  for i := range d {
+    distance += bits.OnesCount64(d[i] ^ other[i])
+  }
+  return distance
+}
+
+// MatchDescriptors pairs each query descriptor with its closest train
+// descriptor.
+// Pairs whose distance exceeds maxDistance are dropped. If crossCheck is true,
+// pairs are only kept if the query descriptor is also the closest one to the
+// train descriptor. Ties are broken in favor of lower indexes. The matches are
+// sorted by their Query values.
+func MatchDescriptors(query []Descriptor, train []Descriptor,
+    maxDistance int, crossCheck bool) []KeypointMatch {
+  var matches []KeypointMatch
+  for i := range query {
+    j, distance := closestDescriptor(query[i], train)
+    if j < 0 || distance > maxDistance {
+      continue
+    }
+    if crossCheck {
+      if k, _ := closestDescriptor(train[j], query); k != i {
+        continue
+      }
+    }
+    matches = append(matches, KeypointMatch{Query: i, Train: j,
+        Distance: distance})
+  }
+  return matches
+}
+
+// Project applies the homography to a point.
+func (h Homography) Project(x float64, y float64) (float64, float64) {
+  w := h[6] * x + h[7] * y + h[8]
+  return (h[0] * x + h[1] * y + h[2]) / w, (h[3] * x + h[4] * y + h[5]) / w
+}
+
+// FindHomography estimates the homography that maps points onto other points.
+// The estimate is robust to outliers. RANSAC fits homographies to random
+// 4-point samples, keeps the one that maps the most points within tolerance
+// pixels of their targets, then refits it to all those inliers. The seed makes
+// the random samples reproducible. It returns the homography, a slice that
+// flags the inliers, and ErrNoHomography if fewer than 4 points are
+// consistent with any of the sampled homographies.
+func FindHomography(from [][2]float64, to [][2]float64, tolerance float64,
+    iterations int, seed int64) (Homography, []bool, error) {
+  if len(from) != len(to) {
+    panic("Point slices have different lengths")
+  }
+  if len(from) < 4 {
+    return Homography{}, nil, ErrNoHomography
+  }
+
+  random := rand.New(rand.NewSource(seed))
+  var bestInliers []bool
+  bestCount := 0
+  for iteration := 0; iteration < iterations; iteration += 1 {
+    sample := random.Perm(len(from))[:4]
+    homography, ok := fitHomography(from, to, sample)
+    if !ok {
+      continue
+    }
+    inliers, count := homographyInliers(homography, from, to, tolerance)
+    if count > bestCount {
+      bestInliers, bestCount = inliers, count
+    }
+  }
+  if bestCount < 4 {
+    return Homography{}, nil, ErrNoHomography
+  }
+
+  var indexes []int
+  for i, inlier := range bestInliers {
+    if inlier {
+      indexes = append(indexes, i)
+    }
+  }
+  homography, ok := fitHomography(from, to, indexes)
+  if !ok {
+    return Homography{}, nil, ErrNoHomography
+  }
+  inliers, _ := homographyInliers(homography, from, to, tolerance)
+  return homography, inliers, nil
+}
+
+// gray returns a packed grayscale copy of a non-empty checked view.
+func (v RgbaView) gray() []byte {
+  gray := make([]byte, v.Width * v.Height)
+  rgbaToGray(v, gray)
+  return gray
+}
+
+// fastLocalMaximum returns true if a corner's score beats its neighbors'.
+// Ties are broken in scan order, so plateaus yield a single corner.
+func fastLocalMaximum(scores []int32, width int, x int, y int) bool {
+  score := scores[y * width + x]
+  if score == 0 {
+    return false
+  }
+  for dy := -1; dy <= 1; dy += 1 {
+    for dx := -1; dx <= 1; dx += 1 {
+      neighbor := scores[(y + dy) * width + x + dx]
+      before := dy < 0 || (dy == 0 && dx < 0)
+      if neighbor > score || (before && neighbor == score) {
+        return false
+      }
+    }
+  }
+  return true
+}
+
+// harrisResponse computes the Harris corner response around a pixel.
+// The gradients are computed by Sobel filters.
+func harrisResponse(gray []byte, width int, x int, y int) float64 {
+  pixel := func(x int, y int) int {
+    return int(gray[y * width + x])
+  }
+  var sxx, syy, sxy float64
+  for wy := y - harrisRadius; wy <= y + harrisRadius; wy += 1 {
+    for wx := x - harrisRadius; wx <= x + harrisRadius; wx += 1 {
+      gx := float64((pixel(wx + 1, wy - 1) + 2 * pixel(wx + 1, wy) +
+          pixel(wx + 1, wy + 1)) - (pixel(wx - 1, wy - 1) +
+          2 * pixel(wx - 1, wy) + pixel(wx - 1, wy + 1)))
+      gy := float64((pixel(wx - 1, wy + 1) + 2 * pixel(wx, wy + 1) +
+          pixel(wx + 1, wy + 1)) - (pixel(wx - 1, wy - 1) +
+          2 * pixel(wx, wy - 1) + pixel(wx + 1, wy - 1)))
+      sxx += gx * gx
+      syy += gy * gy
+      sxy += gx * gy
+    }
+  }
+  trace := sxx + syy
+  return sxx * syy - sxy * sxy - harrisK * trace * trace
+}
+
+// patchAngle computes the direction of a patch's intensity centroid.
+func patchAngle(gray []byte, width int, x int, y int) float64 {
+  var m10, m01 int
+  for dy := -keypointPatchRadius; dy <= keypointPatchRadius; dy += 1 {
+    for dx := -keypointPatchRadius; dx <= keypointPatchRadius; dx += 1 {
+      if dx * dx + dy * dy > keypointPatchRadius * keypointPatchRadius {
+        continue
+      }
+      value := int(gray[(y + dy) * width + x + dx])
+      m10 += dx * value
+      m01 += dy * value
+    }
+  }
+  return math.Atan2(float64(m01), float64(m10))
+}
+
+// boxSum adds up the 5x5 grayscale pixels centered at a point.
+// Smoothing the samples makes the descriptors less sensitive to noise.
+// Pixels outside the image are replaced by the closest edge pixels.
+func boxSum(gray []byte, width int, height int, x int, y int) int {
+  sum := 0
+  for dy := -2; dy <= 2; dy += 1 {
+    row := clamp(y + dy, 0, height - 1) * width
+    for dx := -2; dx <= 2; dx += 1 {
+      sum += int(gray[row + clamp(x + dx, 0, width - 1)])
+    }
+  }
+  return sum
+}
+
+// briefPattern holds the point pairs compared by the descriptors.
+// Each pair is stored as x1, y1, x2, y2, relative to the keypoint.
+var briefPattern = buildBriefPattern()
+
+// buildBriefPattern generates the descriptor's point pairs.
+// The points are spread uniformly over a disc. They are generated by a fixed
+// xorshift sequence, so descriptors never change across releases.
+func buildBriefPattern() [256][4]int {
+  state := uint32(2463534242)
+  randomCoordinate := func() int {
+    state ^= state << 13
+    state ^= state >> 17
+    state ^= state << 5
+    return int(state % (2 * briefSampleRadius + 1)) - briefSampleRadius
+  }
+  randomPoint := func() (int, int) {
+    for {
+      x, y := randomCoordinate(), randomCoordinate()
+      if x * x + y * y <= briefSampleRadius * briefSampleRadius {
+        return x, y
+      }
+    }
+  }
+
+  var pattern [256][4]int
+  for i := range pattern {
+    pattern[i][0], pattern[i][1] = randomPoint()
+    pattern[i][2], pattern[i][3] = randomPoint()
+    for pattern[i][2] == pattern[i][0] && pattern[i][3] == pattern[i][1] {
+      pattern[i][2], pattern[i][3] = randomPoint()
+    }
+  }
+  return pattern
+}
+
+// closestDescriptor returns the index of the closest candidate and its
+// distance.
+// It returns -1 if there are no candidates.
+func closestDescriptor(descriptor Descriptor,
+    candidates []Descriptor) (int, int) {
+  best, bestDistance := -1, 0
+  for i, candidate := range candidates {
+    distance := descriptor.Distance(candidate)
+    if best < 0 || distance < bestDistance {
+      best, bestDistance = i, distance
+    }
+  }
+  return best, bestDistance
+}
+
+// homographyInliers flags the points that a homography maps within tolerance
+// pixels of their targets.
+// It returns the flags and the number of inliers.
+func homographyInliers(homography Homography, from [][2]float64,
+    to [][2]float64, tolerance float64) ([]bool, int) {
+  inliers := make([]bool, len(from))
+  count := 0
+  for i := range from {
+    x, y := homography.Project(from[i][0], from[i][1])
+    dx, dy := x - to[i][0], y - to[i][1]
+    // NOTE: NaN distances, caused by points projected to infinity, fail this
+    //       test.
+    if dx * dx + dy * dy <= tolerance * tolerance {
+      inliers[i] = true
+      count += 1
+    }
+  }
+  return inliers, count
+}
+
+// fitHomography computes the least-squares homography for some point pairs.
+// The points are normalized so that their centroid is at the origin and their
+// average distance from it is sqrt(2), which keeps the linear system well
+// conditioned. It returns false if the points don't determine a homography.
+func fitHomography(from [][2]float64, to [][2]float64,
+    indexes []int) (Homography, bool) {
+  if len(indexes) < 4 {
+    return Homography{}, false
+  }
+  fromCenter, fromScale := pointNormalization(from, indexes)
+  toCenter, toScale := pointNormalization(to, indexes)
+
+  // The normal equations of the direct linear transformation, with the
+  // homography's last element fixed to 1.
+  var system [8][9]float64
+  for _, i := range indexes {
+    x := (from[i][0] - fromCenter[0]) * fromScale
+    y := (from[i][1] - fromCenter[1]) * fromScale
+    u := (to[i][0] - toCenter[0]) * toScale
+    v := (to[i][1] - toCenter[1]) * toScale
+    rows := [2][9]float64{
+      {x, y, 1, 0, 0, 0, -u * x, -u * y, u},
+      {0, 0, 0, x, y, 1, -v * x, -v * y, v},
+    }
+    for _, row := range rows {
+      for j := 0; j < 8; j += 1 {
+        for k := 0; k < 9; k += 1 {
+          system[j][k] += row[j] * row[k]
+        }
+      }
+    }
+  }
+  solution, ok := solveLinearSystem(system)
+  if !ok {
+    return Homography{}, false
+  }
+
+  // Undo the normalization: H = inverse(T_to) * Hn * T_from.
+  normalized := Homography{solution[0], solution[1], solution[2],
+      solution[3], solution[4], solution[5], solution[6], solution[7], 1}
+  fromTransform := Homography{fromScale, 0, -fromScale * fromCenter[0],
+      0, fromScale, -fromScale * fromCenter[1], 0, 0, 1}
+  toInverse := Homography{1 / toScale, 0, toCenter[0], 0, 1 / toScale,
+      toCenter[1], 0, 0, 1}
+  homography := toInverse.multiply(normalized).multiply(fromTransform)
+  if math.Abs(homography[8]) < 1e-12 {
+    return Homography{}, false
+  }
+  for i := range homography {
+    homography[i] /= homography[8]
+  }
+  return homography, true
+}
+
+// pointNormalization returns the centroid of some points and the scale that
+// makes their average distance from the centroid sqrt(2).
+func pointNormalization(points [][2]float64, indexes []int) ([2]float64,
+    float64) {
+  var center [2]float64
+  for _, i := range indexes {
+    center[0] += points[i][0]
+    center[1] += points[i][1]
+  }
+  center[0] /= float64(len(indexes))
+  center[1] /= float64(len(indexes))
+
+  distance := 0.0
+  for _, i := range indexes {
+    distance += math.Hypot(points[i][0] - center[0], points[i][1] - center[1])
+  }
+  distance /= float64(len(indexes))
+  if distance == 0 {
+    return center, 1
+  }
+  return center, math.Sqrt2 / distance
+}
+
+// multiply returns the matrix product of two homographies.
+func (h Homography) multiply(other Homography) Homography {
+  var product Homography
+  for row := 0; row < 3; row += 1 {
+    for column := 0; column < 3; column += 1 {
+      for k := 0; k < 3; k += 1 {
+        product[row * 3 + column] += h[row * 3 + k] * other[k * 3 + column]
+      }
+    }
+  }
+  return product
+}
+
+// solveLinearSystem solves an 8x8 linear system by Gaussian elimination.
+// The last column holds the right-hand side. It returns false if the system
+// is singular.
+func solveLinearSystem(system [8][9]float64) ([8]float64, bool) {
+  for column := 0; column < 8; column += 1 {
+    pivot := column
+    for row := column + 1; row < 8; row += 1 {
+      if math.Abs(system[row][column]) > math.Abs(system[pivot][column]) {
+        pivot = row
+      }
+    }
+    if math.Abs(system[pivot][column]) < 1e-10 {
+      return [8]float64{}, false
+    }
+    system[column], system[pivot] = system[pivot], system[column]
+
+    for row := column + 1; row < 8; row += 1 {
+      factor := system[row][column] / system[column][column]
+      for k := column; k < 9; k += 1 {
+        system[row][k] -= factor * system[column][k]
+      }
+    }
+  }
+
+  var solution [8]float64
+  for row := 7; row >= 0; row -= 1 {
+    value := system[row][8]
+    for k := row + 1; k < 8; k += 1 {
+      value -= system[row][k] * solution[k]
+    }
+    solution[row] = value / system[row][row]
+  }
+  return solution, true
+}
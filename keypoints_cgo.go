@@ -0,0 +1,26 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/keypoints.c"
+import "C"  // cgo
+
+import (
+  "unsafe"
+)
+
+// rgbaToGray converts a non-empty checked view into a packed grayscale image.
+func rgbaToGray(v RgbaView, gray []byte) {
+  C.GoRgbaToGray(unsafe.Pointer(&v.Pix[0]), unsafe.Pointer(&gray[0]),
+      C.int(v.Width), C.int(v.Height), C.int(v.Stride / 4))
+}
+
+// grayFastScores computes the FAST-9 corner score of every grayscale pixel.
+// Non-corners and the pixels closer than border to the image's edges get a
+// zero score. The border must be at least 3, and the image must be larger
+// than twice the border in both directions.
+func grayFastScores(gray []byte, scores []int32, width int, height int,
+    threshold int, border int) {
+  C.GoGrayFastScores(unsafe.Pointer(&gray[0]), unsafe.Pointer(&scores[0]),
+      C.int(width), C.int(height), C.int(threshold), C.int(border))
+}
@@ -0,0 +1,75 @@
+//go:build !cgo
+
+package imageutil
+
+// The kernels below are the pure Go versions of the functions in
+// c/keypoints.c.
+
+// rgbaToGray converts a non-empty checked view into a packed grayscale image.
+func rgbaToGray(v RgbaView, gray []byte) {
+  for y := 0; y < v.Height; y += 1 {
+    row := v.Pix[v.PixOffset(0, y):]
+    for x := 0; x < v.Width; x += 1 {
+      gray[y * v.Width + x] = pixelGray(row[x * 4], row[x * 4 + 1],
+          row[x * 4 + 2])
+    }
+  }
+}
+
+// The 16 pixels on a radius 3 circle, clockwise from the top.
+var (
+  fastCircleX = [16]int{0, 1, 2, 3, 3, 3, 2, 1, 0, -1, -2, -3, -3, -3, -2, -1}
+  fastCircleY = [16]int{-3, -3, -2, -1, 0, 1, 2, 3, 3, 3, 2, 1, 0, -1, -2, -3}
+)
+
+// fastHasArc returns true if a 16-bit circle mask has 9 contiguous set bits.
+// The mask wraps around, so the arc can cross the circle's start.
+func fastHasArc(mask uint32) bool {
+  circle := mask | mask << 16
+  run := circle
+  for i := 1; i < 9; i += 1 {
+    run &= circle >> i
+  }
+  return run != 0
+}
+
+// grayFastScores computes the FAST-9 corner score of every grayscale pixel.
+// Non-corners and the pixels closer than border to the image's edges get a
+// zero score. The border must be at least 3, and the image must be larger
+// than twice the border in both directions.
+func grayFastScores(gray []byte, scores []int32, width int, height int,
+    threshold int, border int) {
+  for i := range scores[:width * height] {
+    scores[i] = 0
+  }
+
+  var offsets [16]int
+  for i := range offsets {
+    offsets[i] = fastCircleY[i] * width + fastCircleX[i]
+  }
+
+  for y := border; y < height - border; y += 1 {
+    for x := border; x < width - border; x += 1 {
+      center := y * width + x
+      value := int(gray[center])
+      var bright, dark uint32
+      var brightSum, darkSum int32
+      for i, offset := range offsets {
+        circleValue := int(gray[center + offset])
+        if circleValue > value + threshold {
+          bright |= 1 << i
+          brightSum += int32(circleValue - value - threshold)
+        } else if circleValue < value - threshold {
+          dark |= 1 << i
+          darkSum += int32(value - circleValue - threshold)
+        }
+      }
+
+      if fastHasArc(bright) {
+        scores[center] = brightSum
+      } else if fastHasArc(dark) {
+        scores[center] = darkSum
+      }
+    }
+  }
+}
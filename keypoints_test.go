@@ -0,0 +1,200 @@
+package imageutil
+
+import (
+  "math"
+  "math/rand"
+  "testing"
+)
+
+func TestRgbaFindKeypoints(t *testing.T) {
+  // A bright square on a dark background has corners at its 4 corners.
+  width, height := 128, 96
+  rgbaImage := make([]byte, width * height * 4)
+  for y := 0; y < height; y += 1 {
+    for x := 0; x < width; x += 1 {
+      offset := (y * width + x) * 4
+      rgbaImage[offset + 3] = 0xff
+      if x >= 40 && x < 88 && y >= 30 && y < 70 {
+        rgbaImage[offset], rgbaImage[offset + 1] = 0xe0, 0xc0
+        rgbaImage[offset + 2] = 0xa0
+      }
+    }
+  }
+
+  keypoints := RgbaFindKeypoints(rgbaImage, width, height, 40, 0)
+  corners := [][2]int{{40, 30}, {87, 30}, {40, 69}, {87, 69}}
+  found := make([]bool, len(corners))
+  for _, keypoint := range keypoints {
+    near := false
+    for i, corner := range corners {
+      if absInt(keypoint.X - corner[0]) <= 2 &&
+          absInt(keypoint.Y - corner[1]) <= 2 {
+        found[i], near = true, true
+      }
+    }
+    if !near {
+      t.Errorf("Keypoint %v is not near a corner\n", keypoint)
+    }
+  }
+  for i, corner := range corners {
+    if !found[i] {
+      t.Error("No keypoint found near corner ", corner)
+    }
+  }
+
+  for i := 1; i < len(keypoints); i += 1 {
+    if keypoints[i].Score > keypoints[i - 1].Score {
+      t.Error("Keypoints are not sorted by score")
+    }
+  }
+  if limited := RgbaFindKeypoints(rgbaImage, width, height, 40, 2);
+      len(limited) != 2 || limited[0] != keypoints[0] {
+    t.Error("Incorrect limited keypoints: ", limited)
+  }
+  if tiny := RgbaFindKeypoints(rgbaImage, 32, 32, 40, 0); len(tiny) != 0 {
+    t.Error("Keypoints found in an image smaller than the borders: ", tiny)
+  }
+}
+
+func TestMatchDescriptors(t *testing.T) {
+  query := []Descriptor{{0xff}, {0, 1}, {0, 0, 0, 0xffff}}
+  train := []Descriptor{{0, 3}, {0xfe}, {0xff, 0, 0, 1}, {0, 0, 0, 0}}
+
+  if distance := query[0].Distance(train[2]); distance != 1 {
+    t.Error("Incorrect descriptor distance: ", distance)
+  }
+
+  matches := MatchDescriptors(query, train, 256, false)
+  expected := []KeypointMatch{{0, 1, 1}, {1, 0, 1}, {2, 3, 16}}
+  if len(matches) != len(expected) {
+    t.Fatal("Incorrect matches: ", matches)
+  }
+  for i := range expected {
+    if matches[i] != expected[i] {
+      t.Errorf("Match %d is %v instead of %v\n", i, matches[i], expected[i])
+    }
+  }
+
+  // The train descriptor closest to query 2 is closer to query 1.
+  matches = MatchDescriptors(query, train, 256, true)
+  if len(matches) != 2 || matches[1] != expected[1] {
+    t.Error("Incorrect cross-checked matches: ", matches)
+  }
+  matches = MatchDescriptors(query, train, 8, false)
+  if len(matches) != 2 {
+    t.Error("Incorrect distance-limited matches: ", matches)
+  }
+}
+
+func TestFindHomography(t *testing.T) {
+  golden := Homography{0.9, -0.2, 30, 0.15, 1.1, -12, 0.0004, -0.0002, 1}
+  random := rand.New(rand.NewSource(7))
+  var from, to [][2]float64
+  var goldInliers []bool
+  for i := 0; i < 60; i += 1 {
+    x, y := random.Float64() * 500, random.Float64() * 400
+    u, v := golden.Project(x, y)
+    inlier := i % 4 != 0
+    if !inlier {
+      u, v = u + 20 + random.Float64() * 50, v - 30
+    }
+    from = append(from, [2]float64{x, y})
+    to = append(to, [2]float64{u, v})
+    goldInliers = append(goldInliers, inlier)
+  }
+
+  homography, inliers, err := FindHomography(from, to, 1, 200, 1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  for i := range goldInliers {
+    if inliers[i] != goldInliers[i] {
+      t.Errorf("Point %d inlier flag is %v\n", i, inliers[i])
+    }
+  }
+  for i := range homography {
+    if math.Abs(homography[i] - golden[i]) > 1e-6 * math.Max(1,
+        math.Abs(golden[i])) {
+      t.Errorf("Homography %v does not match %v\n", homography, golden)
+      break
+    }
+  }
+
+  if _, _, err := FindHomography(from[:3], to[:3], 1, 200,
+      1); err != ErrNoHomography {
+    t.Error("Too few points did not return ErrNoHomography: ", err)
+  }
+  collinear := [][2]float64{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {4, 4}}
+  if _, _, err := FindHomography(collinear, collinear, 1, 50,
+      1); err != ErrNoHomography {
+    t.Error("Collinear points did not return ErrNoHomography: ", err)
+  }
+}
+
+func TestKeypointsRotatedImage(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  // Rotate the image by 20 degrees around its center.
+  sin, cos := math.Sincos(20 * math.Pi / 180)
+  centerX, centerY := float64(width) / 2, float64(height) / 2
+  matrix := [6]float64{cos, -sin, centerX - cos * centerX + sin * centerY,
+      sin, cos, centerY - sin * centerX - cos * centerY}
+  var rotated []byte
+  if err := AffineWarpRgba(image.Pix, width, height, matrix, width, height,
+      0x000000ff, &rotated); err != nil {
+    t.Fatal(err)
+  }
+
+  keypoints := RgbaFindKeypoints(image.Pix, width, height, 20, 500)
+  rotatedKeypoints := RgbaFindKeypoints(rotated, width, height, 20, 500)
+  if len(keypoints) < 100 || len(rotatedKeypoints) < 100 {
+    t.Fatalf("Too few keypoints: %d and %d\n", len(keypoints),
+        len(rotatedKeypoints))
+  }
+  matches := MatchDescriptors(
+      RgbaDescribeKeypoints(image.Pix, width, height, keypoints),
+      RgbaDescribeKeypoints(rotated, width, height, rotatedKeypoints), 64,
+      true)
+
+  var from, to [][2]float64
+  for _, match := range matches {
+    query, train := keypoints[match.Query], rotatedKeypoints[match.Train]
+    from = append(from, [2]float64{float64(query.X), float64(query.Y)})
+    to = append(to, [2]float64{float64(train.X), float64(train.Y)})
+  }
+  homography, inliers, err := FindHomography(from, to, 3, 500, 1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  inlierCount := 0
+  for _, inlier := range inliers {
+    if inlier {
+      inlierCount += 1
+    }
+  }
+  if inlierCount < 100 {
+    t.Errorf("Only %d inliers out of %d matches\n", inlierCount,
+        len(matches))
+  }
+
+  for _, point := range [][2]float64{{100, 100}, {256, 256}, {400, 150}} {
+    x, y := homography.Project(point[0], point[1])
+    goldX := matrix[0] * point[0] + matrix[1] * point[1] + matrix[2]
+    goldY := matrix[3] * point[0] + matrix[4] * point[1] + matrix[5]
+    if math.Hypot(x - goldX, y - goldY) > 2 {
+      t.Errorf("Point %v projected to (%f, %f) instead of (%f, %f)\n", point,
+          x, y, goldX, goldY)
+    }
+  }
+}
+
+func absInt(x int) int {
+  if x < 0 {
+    return -x
+  }
+  return x
+}
@@ -1,10 +1,7 @@
 package imageutil
 
-// #include "c/matchers.c"
-import "C"  // cgo
-
 import (
-  "unsafe"
+  "image"
 )
 
 // RgbaCheckCrop returns true if an image is a cropped version of another one.
@@ -23,21 +20,30 @@ func RgbaCheckCrop(haystack []byte, hayWidth int, hayHeight int,
     panic("Needle width and height do not match buffer size")
   }
 
+  return NewRgbaView(haystack, hayWidth, hayHeight).CheckCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop)
+}
+
+// CheckCrop returns true if a needle view is a cropped version of this view.
+// This is the view equivalent of RgbaCheckCrop.
+func (v RgbaView) CheckCrop(needle RgbaView, needleLeft int,
+    needleTop int) bool {
+  v.check("Haystack view")
+  needle.check("Needle view")
+
   // NOTE: These checks are also intended to prevent segmentation faults, but
   //       we don't have to panic here.
-  if needleLeft < 0 || needleLeft + needleWidth > hayWidth {
+  if needleLeft < 0 || needleLeft + needle.Width > v.Width {
     return false
   }
-  if needleTop < 0 || needleTop + needleHeight > hayHeight {
+  if needleTop < 0 || needleTop + needle.Height > v.Height {
     return false
   }
+  if needle.Empty() {
+    return true
+  }
 
-  // NOTE: The haystack's height is irrelevant to the actual matching logic,
-  //       so it is omitted.
-  cresult := C.GoRgbaCheckCrop(unsafe.Pointer(&haystack[0]),
-      unsafe.Pointer(&needle[0]), C.int(hayWidth), C.int(needleWidth),
-      C.int(needleHeight), C.int(needleLeft), C.int(needleTop))
-  return cresult != 0
+  return rgbaCheckCrop(v, needle, needleLeft, needleTop)
 }
 
 // RgbaCheckMaskedCrop checks if an image is a crop&mask from another image.
@@ -57,26 +63,33 @@ func RgbaCheckMaskedCrop(haystack []byte, hayWidth int, hayHeight int,
     panic("Needle width and height do not match buffer size")
   }
 
+  return NewRgbaView(haystack, hayWidth, hayHeight).CheckMaskedCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop,
+      rgbaMask)
+}
+
+// CheckMaskedCrop checks if a needle view is a crop&mask from this view.
+// This is the view equivalent of RgbaCheckMaskedCrop.
+func (v RgbaView) CheckMaskedCrop(needle RgbaView, needleLeft int,
+    needleTop int, rgbaMask uint32) bool {
+  v.check("Haystack view")
+  needle.check("Needle view")
+
   // NOTE: These checks are also intended to prevent segmentation faults, but
   //       we don't have to panic here.
-  if needleLeft < 0 || needleLeft + needleWidth > hayWidth {
+  if needleLeft < 0 || needleLeft + needle.Width > v.Width {
     return false
   }
-  if needleTop < 0 || needleTop + needleHeight > hayHeight {
+  if needleTop < 0 || needleTop + needle.Height > v.Height {
     return false
   }
+  if needle.Empty() {
+    return true
+  }
 
-  // RGBA -> ARGB, because Intel is little-endian.
-  argbMask := uint32(((rgbaMask & 0xff) << 24) | ((rgbaMask & 0xff00) << 8) |
-      ((rgbaMask & 0xff0000) >> 8) | ((rgbaMask & 0xff000000) >> 24))
-
-  // NOTE: The haystack's height is irrelevant to the actual matching logic,
-  //       so it is omitted.
-  cresult := C.GoRgbaCheckMaskedCrop(unsafe.Pointer(&haystack[0]),
-      unsafe.Pointer(&needle[0]), C.int(hayWidth), C.int(needleWidth),
-      C.int(needleHeight), C.int(needleLeft), C.int(needleTop),
-      C.uint32_t(argbMask))
-  return cresult != 0
+  // The kernels compare the mask with pixels loaded from memory.
+  return rgbaCheckMaskedCrop(v, needle, needleLeft, needleTop,
+      packRgba(rgbaMask))
 }
 
 // RgbaDiffMaskedCrop diffs an image with a crop&mask of another image.
@@ -94,26 +107,33 @@ func RgbaDiffMaskedCrop(haystack []byte, hayWidth int, hayHeight int,
     panic("Needle width and height do not match buffer size")
   }
 
+  return NewRgbaView(haystack, hayWidth, hayHeight).DiffMaskedCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop,
+      rgbaMask)
+}
+
+// DiffMaskedCrop diffs a needle view with a crop&mask of this view.
+// This is the view equivalent of RgbaDiffMaskedCrop.
+func (v RgbaView) DiffMaskedCrop(needle RgbaView, needleLeft int,
+    needleTop int, rgbaMask uint32) int64 {
+  v.check("Haystack view")
+  needle.check("Needle view")
+
   // NOTE: These checks are also intended to prevent segmentation faults, but
   //       we don't have to panic here.
-  if needleLeft < 0 || needleLeft + needleWidth > hayWidth {
+  if needleLeft < 0 || needleLeft + needle.Width > v.Width {
     return 0
   }
-  if needleTop < 0 || needleTop + needleHeight > hayHeight {
+  if needleTop < 0 || needleTop + needle.Height > v.Height {
+    return 0
+  }
+  if needle.Empty() {
     return 0
   }
 
-  // RGBA -> ARGB, because Intel is little-endian.
-  argbMask := uint32(((rgbaMask & 0xff) << 24) | ((rgbaMask & 0xff00) << 8) |
-      ((rgbaMask & 0xff0000) >> 8) | ((rgbaMask & 0xff000000) >> 24))
-
-  // NOTE: The haystack's height is irrelevant to the actual matching logic,
-  //       so it is omitted.
-  cresult := C.GoRgbaDiffMaskedCrop(unsafe.Pointer(&haystack[0]),
-      unsafe.Pointer(&needle[0]), C.int(hayWidth), C.int(needleWidth),
-      C.int(needleHeight), C.int(needleLeft), C.int(needleTop),
-      C.uint32_t(argbMask))
-  return int64(cresult)
+  // The kernels combine the mask with pixels loaded from memory.
+  return rgbaDiffMaskedCrop(v, needle, needleLeft, needleTop,
+      packRgba(rgbaMask))
 }
 
 // RgbaDiffThresholdCrop diffs an image with a crop&threshold of another image.
@@ -131,23 +151,34 @@ func RgbaDiffThresholdCrop(haystack []byte, hayWidth int, hayHeight int,
     panic("Needle width and height do not match buffer size")
   }
 
+  return NewRgbaView(haystack, hayWidth, hayHeight).DiffThresholdCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop,
+      minRed, maxRed, minGreen, maxGreen, minBlue, maxBlue)
+}
+
+// DiffThresholdCrop diffs a needle view with a crop&threshold of this view.
+// This is the view equivalent of RgbaDiffThresholdCrop.
+func (v RgbaView) DiffThresholdCrop(needle RgbaView, needleLeft int,
+    needleTop int, minRed int, maxRed int, minGreen int, maxGreen int,
+    minBlue int, maxBlue int) int {
+  v.check("Haystack view")
+  needle.check("Needle view")
+
   // NOTE: These checks are also intended to prevent segmentation faults, but
   //       we don't have to panic here.
-  if needleLeft < 0 || needleLeft + needleWidth > hayWidth {
+  if needleLeft < 0 || needleLeft + needle.Width > v.Width {
     return 0
   }
-  if needleTop < 0 || needleTop + needleHeight > hayHeight {
+  if needleTop < 0 || needleTop + needle.Height > v.Height {
+    return 0
+  }
+  if needle.Empty() {
     return 0
   }
 
-  // NOTE: The haystack's height is irrelevant to the actual matching logic,
-  //       so it is omitted.
-  cresult := C.GoRgbaDiffThresholdCrop(unsafe.Pointer(&haystack[0]),
-      unsafe.Pointer(&needle[0]), C.int(hayWidth), C.int(needleWidth),
-      C.int(needleHeight), C.int(needleLeft), C.int(needleTop),
-      C.uint8_t(minRed), C.uint8_t(minGreen), C.uint8_t(minBlue),
-      C.uint8_t(maxRed), C.uint8_t(maxGreen), C.uint8_t(maxBlue))
-  return int(cresult)
+  return rgbaDiffThresholdCrop(v, needle, needleLeft, needleTop,
+      uint8(minRed), uint8(minGreen), uint8(minBlue), uint8(maxRed),
+      uint8(maxGreen), uint8(maxBlue))
 }
 
 
@@ -161,9 +192,48 @@ func HashForRgbaFindCrop(needle []byte, needleWidth int,
     panic("Needle width and height do not match buffer size")
   }
 
-  chash := C.GoHashForRgbaFindCrop(unsafe.Pointer(&needle[0]),
-      C.int(needleWidth), C.int(needleHeight))
-  return uint32(chash)
+  return NewRgbaView(needle, needleWidth, needleHeight).HashForFindCrop()
+}
+
+// HashForFindCrop computes the needle hash needed by FindCrop.
+// This is the view equivalent of HashForRgbaFindCrop, and the two functions
+// return the same hash for the same pixels.
+func (v RgbaView) HashForFindCrop() uint32 {
+  v.check("Needle view")
+  if v.Empty() {
+    return 0
+  }
+
+  return hashForRgbaFindCrop(v, 0xffffffff)
+}
+
+// HashForRgbaFindMaskedCrop computes the masked needle hash needed by
+// RgbaFindMaskedCrop.
+// The mask is applied to the needle's pixels while they are hashed, so the
+// needle does not need to be masked in advance. It returns the hash that
+// HashForRgbaFindCrop would return for the masked needle.
+func HashForRgbaFindMaskedCrop(needle []byte, needleWidth int,
+    needleHeight int, rgbaMask uint32) uint32 {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(needle, needleWidth,
+      needleHeight).HashForFindMaskedCrop(rgbaMask)
+}
+
+// HashForFindMaskedCrop computes the masked needle hash needed by
+// FindMaskedCrop.
+// This is the view equivalent of HashForRgbaFindMaskedCrop.
+func (v RgbaView) HashForFindMaskedCrop(rgbaMask uint32) uint32 {
+  v.check("Needle view")
+  if v.Empty() {
+    return 0
+  }
+
+  return hashForRgbaFindCrop(v, packRgba(rgbaMask))
 }
 
 // RgbaFindCrop looks for a needle image in a hastack image.
@@ -181,18 +251,27 @@ func RgbaFindCrop(haystack []byte, hayWidth int, hayHeight int, needle []byte,
   if len(needle) < needleWidth * needleHeight * 4 {
     panic("Needle width and height do not match buffer size")
   }
-  if cap(scratch) < hayWidth * 4 {
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleHash, scratch)
+}
+
+// FindCrop looks for a needle view in this view.
+// This is the view equivalent of RgbaFindCrop. The scratch space capacity must
+// be at least 4 * v.Width. The match coordinates are relative to the view's
+// top-left corner.
+func (v RgbaView) FindCrop(needle RgbaView, needleHash uint32,
+    scratch []byte) (int, int, int) {
+  v.check("Haystack view")
+  needle.check("Needle view")
+  if cap(scratch) < v.Width * 4 {
     panic("Insufficent scratch buffer capacity")
   }
+  if needle.Empty() || needle.Width > v.Width || needle.Height > v.Height {
+    return 0, 0, 0
+  }
 
-  var cmatchLeft C.int
-  var cmatchTop C.int
-  ccount := C.GoRgbaFindCrop(unsafe.Pointer(&haystack[0]),
-      unsafe.Pointer(&needle[0]), C.int(hayWidth), C.int(hayHeight),
-      C.int(needleWidth), C.int(needleHeight), C.uint32_t(needleHash),
-      unsafe.Pointer(&scratch[0]), &cmatchLeft, &cmatchTop)
-
-  return int(ccount), int(cmatchLeft), int(cmatchTop)
+  return rgbaFindCrop(v, needle, needleHash, scratch[:cap(scratch)])
 }
 
 // RgbaFindMaskedCrop looks for a masked needle image in a hastack image.
@@ -211,21 +290,628 @@ func RgbaFindMaskedCrop(haystack []byte, hayWidth int, hayHeight int,
   if len(needle) < needleWidth * needleHeight * 4 {
     panic("Needle width and height do not match buffer size")
   }
-  if cap(scratch) < hayWidth * 4 {
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindMaskedCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), rgbaMask, needleHash,
+      scratch)
+}
+
+// FindMaskedCrop looks for a masked needle view in this view.
+// This is the view equivalent of RgbaFindMaskedCrop. The scratch space
+// capacity must be at least 4 * v.Width. The match coordinates are relative to
+// the view's top-left corner.
+func (v RgbaView) FindMaskedCrop(needle RgbaView, rgbaMask uint32,
+    needleHash uint32, scratch []byte) (int, int, int) {
+  v.check("Haystack view")
+  needle.check("Needle view")
+  if cap(scratch) < v.Width * 4 {
+    panic("Insufficent scratch buffer capacity")
+  }
+  if needle.Empty() || needle.Width > v.Width || needle.Height > v.Height {
+    return 0, 0, 0
+  }
+
+  // The kernels combine the mask with pixels loaded from memory.
+  return rgbaFindMaskedCrop(v, needle, packRgba(rgbaMask), needleHash,
+      scratch[:cap(scratch)])
+}
+
+// RgbaFindCropParallel looks for a needle image in a haystack image using many
+// goroutines.
+// This is the parallel equivalent of RgbaFindCrop, and it returns the same
+// results. The scratch space is allocated internally. The number of goroutines
+// is set by SetConcurrency.
+func RgbaFindCropParallel(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int,
+    needleHash uint32) (int, int, int) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindCropParallel(
+      NewRgbaView(needle, needleWidth, needleHeight), needleHash)
+}
+
+// FindCropParallel looks for a needle view in this view using many goroutines.
+// This is the view equivalent of RgbaFindCropParallel.
+func (v RgbaView) FindCropParallel(needle RgbaView,
+    needleHash uint32) (int, int, int) {
+  v.check("Haystack view")
+  needle.check("Needle view")
+  return v.findInBands(needle, func(band RgbaView,
+      scratch []byte) (int, int, int) {
+    return rgbaFindCrop(band, needle, needleHash, scratch)
+  })
+}
+
+// RgbaFindMaskedCropParallel looks for a masked needle image in a haystack
+// image using many goroutines.
+// This is the parallel equivalent of RgbaFindMaskedCrop, and it returns the
+// same results. The scratch space is allocated internally. The number of
+// goroutines is set by SetConcurrency.
+func RgbaFindMaskedCropParallel(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, rgbaMask uint32,
+    needleHash uint32) (int, int, int) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindMaskedCropParallel(
+      NewRgbaView(needle, needleWidth, needleHeight), rgbaMask, needleHash)
+}
+
+// FindMaskedCropParallel looks for a masked needle view in this view using
+// many goroutines.
+// This is the view equivalent of RgbaFindMaskedCropParallel.
+func (v RgbaView) FindMaskedCropParallel(needle RgbaView, rgbaMask uint32,
+    needleHash uint32) (int, int, int) {
+  v.check("Haystack view")
+  needle.check("Needle view")
+  pixelMask := packRgba(rgbaMask)
+  return v.findInBands(needle, func(band RgbaView,
+      scratch []byte) (int, int, int) {
+    return rgbaFindMaskedCrop(band, needle, pixelMask, needleHash, scratch)
+  })
+}
+
+// findInBands runs a Rabin-Karp search kernel over horizontal bands.
+// Each band is responsible for a range of needle top positions. A band's view
+// extends needle.Height - 1 rows past its last position, so matches that
+// straddle band boundaries are found exactly once. The results are combined
+// into the results of a single search over the whole view.
+func (v RgbaView) findInBands(needle RgbaView, findFunc func(band RgbaView,
+    scratch []byte) (int, int, int)) (int, int, int) {
+  if needle.Empty() || needle.Width > v.Width || needle.Height > v.Height {
+    return 0, 0, 0
+  }
+
+  positions := v.Height - needle.Height + 1
+  minBandSize := parallelMinRows
+  if minBandSize < needle.Height {
+    // The overlap between bands should not dominate the work.
+    minBandSize = needle.Height
+  }
+  bands := splitBands(positions, minBandSize)
+
+  counts := make([]int, bands)
+  lefts := make([]int, bands)
+  tops := make([]int, bands)
+  runBands(positions, bands, func(band int, start int, end int) {
+    bandView, _ := v.Crop(0, start, v.Width, end - start + needle.Height - 1)
+    scratch := make([]byte, v.Width * 4)
+    counts[band], lefts[band], tops[band] = findFunc(bandView, scratch)
+    tops[band] += start
+  })
+
+  // The last match is in the last band that has any matches.
+  matchCount, matchLeft, matchTop := 0, 0, 0
+  for band := 0; band < bands; band += 1 {
+    if counts[band] == 0 {
+      continue
+    }
+    matchCount += counts[band]
+    matchLeft, matchTop = lefts[band], tops[band]
+  }
+  return matchCount, matchLeft, matchTop
+}
+
+// validateHayAndNeedle checks that the haystack and needle buffers can hold
+// their images.
+func validateHayAndNeedle(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int) error {
+  if err := validateRgba("Haystack", haystack, hayWidth,
+      hayHeight); err != nil {
+    return err
+  }
+  return validateRgba("Needle", needle, needleWidth, needleHeight)
+}
+
+// RgbaCheckCropChecked is the error-returning equivalent of RgbaCheckCrop.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// instead of panicing when an image's dimensions don't match its buffer.
+func RgbaCheckCropChecked(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleLeft int,
+    needleTop int) (bool, error) {
+  if err := validateHayAndNeedle(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight); err != nil {
+    return false, err
+  }
+  return RgbaCheckCrop(haystack, hayWidth, hayHeight, needle, needleWidth,
+      needleHeight, needleLeft, needleTop), nil
+}
+
+// RgbaCheckMaskedCropChecked is the error-returning equivalent of
+// RgbaCheckMaskedCrop.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// instead of panicing when an image's dimensions don't match its buffer.
+func RgbaCheckMaskedCropChecked(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleLeft int,
+    needleTop int, rgbaMask uint32) (bool, error) {
+  if err := validateHayAndNeedle(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight); err != nil {
+    return false, err
+  }
+  return RgbaCheckMaskedCrop(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight, needleLeft, needleTop, rgbaMask), nil
+}
+
+// RgbaDiffMaskedCropChecked is the error-returning equivalent of
+// RgbaDiffMaskedCrop.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// instead of panicing when an image's dimensions don't match its buffer.
+func RgbaDiffMaskedCropChecked(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleLeft int,
+    needleTop int, rgbaMask uint32) (int64, error) {
+  if err := validateHayAndNeedle(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight); err != nil {
+    return 0, err
+  }
+  return RgbaDiffMaskedCrop(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight, needleLeft, needleTop, rgbaMask), nil
+}
+
+// RgbaDiffThresholdCropChecked is the error-returning equivalent of
+// RgbaDiffThresholdCrop.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// instead of panicing when an image's dimensions don't match its buffer.
+func RgbaDiffThresholdCropChecked(haystack []byte, hayWidth int,
+    hayHeight int, needle []byte, needleWidth int, needleHeight int,
+    needleLeft int, needleTop int, minRed int, maxRed int, minGreen int,
+    maxGreen int, minBlue int, maxBlue int) (int, error) {
+  if err := validateHayAndNeedle(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight); err != nil {
+    return 0, err
+  }
+  return RgbaDiffThresholdCrop(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight, needleLeft, needleTop, minRed, maxRed,
+      minGreen, maxGreen, minBlue, maxBlue), nil
+}
+
+// HashForRgbaFindCropChecked is the error-returning equivalent of
+// HashForRgbaFindCrop.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// instead of panicing when the needle's dimensions don't match its buffer.
+func HashForRgbaFindCropChecked(needle []byte, needleWidth int,
+    needleHeight int) (uint32, error) {
+  if err := validateRgba("Needle", needle, needleWidth,
+      needleHeight); err != nil {
+    return 0, err
+  }
+  return HashForRgbaFindCrop(needle, needleWidth, needleHeight), nil
+}
+
+// RgbaFindCropChecked is the error-returning equivalent of RgbaFindCrop.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// when an image's dimensions don't match its buffer, and an error wrapping
+// ErrScratchTooSmall when the scratch space is too small, instead of panicing.
+func RgbaFindCropChecked(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleHash uint32,
+    scratch []byte) (int, int, int, error) {
+  if err := validateHayAndNeedle(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight); err != nil {
+    return 0, 0, 0, err
+  }
+  if err := validateScratch(scratch, hayWidth); err != nil {
+    return 0, 0, 0, err
+  }
+  count, matchLeft, matchTop := RgbaFindCrop(haystack, hayWidth, hayHeight,
+      needle, needleWidth, needleHeight, needleHash, scratch)
+  return count, matchLeft, matchTop, nil
+}
+
+// RgbaFindMaskedCropChecked is the error-returning equivalent of
+// RgbaFindMaskedCrop.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// when an image's dimensions don't match its buffer, and an error wrapping
+// ErrScratchTooSmall when the scratch space is too small, instead of panicing.
+// It returns ErrNeedleNotMasked if the needle has bits outside the mask.
+func RgbaFindMaskedCropChecked(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, rgbaMask uint32,
+    needleHash uint32, scratch []byte) (int, int, int, error) {
+  if err := validateHayAndNeedle(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight); err != nil {
+    return 0, 0, 0, err
+  }
+  if err := validateScratch(scratch, hayWidth); err != nil {
+    return 0, 0, 0, err
+  }
+  if !needleMasked(NewRgbaView(needle, needleWidth, needleHeight),
+      rgbaMask) {
+    return 0, 0, 0, ErrNeedleNotMasked
+  }
+  count, matchLeft, matchTop := RgbaFindMaskedCrop(haystack, hayWidth,
+      hayHeight, needle, needleWidth, needleHeight, rgbaMask, needleHash,
+      scratch)
+  return count, matchLeft, matchTop, nil
+}
+
+// HashForRgbaFindCrop64 computes the 64-bit needle hash needed by
+// RgbaFindCrop64.
+// The hash's top 32 bits are the hash returned by HashForRgbaFindCrop.
+func HashForRgbaFindCrop64(needle []byte, needleWidth int,
+    needleHeight int) uint64 {
+  return HashForRgbaFindMaskedCrop64(needle, needleWidth, needleHeight,
+      0xffffffff)
+}
+
+// HashForRgbaFindMaskedCrop64 computes the 64-bit masked needle hash needed by
+// RgbaFindMaskedCrop64.
+// The mask is applied to the needle's pixels while they are hashed, so the
+// needle does not need to be masked in advance.
+func HashForRgbaFindMaskedCrop64(needle []byte, needleWidth int,
+    needleHeight int, rgbaMask uint32) uint64 {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(needle, needleWidth,
+      needleHeight).HashForFindMaskedCrop64(rgbaMask)
+}
+
+// HashForFindCrop64 computes the 64-bit needle hash needed by FindCrop64.
+// This is the view equivalent of HashForRgbaFindCrop64.
+func (v RgbaView) HashForFindCrop64() uint64 {
+  return v.HashForFindMaskedCrop64(0xffffffff)
+}
+
+// HashForFindMaskedCrop64 computes the 64-bit masked needle hash needed by
+// FindMaskedCrop64.
+// This is the view equivalent of HashForRgbaFindMaskedCrop64.
+func (v RgbaView) HashForFindMaskedCrop64(rgbaMask uint32) uint64 {
+  v.check("Needle view")
+  if v.Empty() {
+    return 0
+  }
+
+  return hashForRgbaFindCrop64(v, packRgba(rgbaMask))
+}
+
+// RgbaFindCrop64 looks for a needle image in a haystack image using 64-bit
+// hashes.
+// This returns the same results as RgbaFindCrop, but verifies far fewer false
+// positives on large, repetitive haystacks. The scratch space capacity must be
+// at least 8 * hayWidth. The needle's hash can be computed by
+// HashForRgbaFindCrop64.
+func RgbaFindCrop64(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleHash uint64,
+    scratch []byte) (int, int, int) {
+  return RgbaFindMaskedCrop64(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight, 0xffffffff, needleHash, scratch)
+}
+
+// RgbaFindMaskedCrop64 looks for a masked needle image in a haystack image
+// using 64-bit hashes.
+// This returns the same results as RgbaFindMaskedCrop, but verifies far fewer
+// false positives on large, repetitive haystacks. The scratch space capacity
+// must be at least 8 * hayWidth. The needle is assumed to have been masked.
+// Its hash can be computed by HashForRgbaFindMaskedCrop64.
+func RgbaFindMaskedCrop64(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, rgbaMask uint32,
+    needleHash uint64, scratch []byte) (int, int, int) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindMaskedCrop64(
+      NewRgbaView(needle, needleWidth, needleHeight), rgbaMask, needleHash,
+      scratch)
+}
+
+// FindCrop64 looks for a needle view in this view using 64-bit hashes.
+// This is the view equivalent of RgbaFindCrop64. The scratch space capacity
+// must be at least 8 * v.Width.
+func (v RgbaView) FindCrop64(needle RgbaView, needleHash uint64,
+    scratch []byte) (int, int, int) {
+  return v.FindMaskedCrop64(needle, 0xffffffff, needleHash, scratch)
+}
+
+// FindMaskedCrop64 looks for a masked needle view in this view using 64-bit
+// hashes.
+// This is the view equivalent of RgbaFindMaskedCrop64. The scratch space
+// capacity must be at least 8 * v.Width.
+func (v RgbaView) FindMaskedCrop64(needle RgbaView, rgbaMask uint32,
+    needleHash uint64, scratch []byte) (int, int, int) {
+  v.check("Haystack view")
+  needle.check("Needle view")
+  if cap(scratch) < v.Width * 8 {
     panic("Insufficent scratch buffer capacity")
   }
+  if needle.Empty() || needle.Width > v.Width || needle.Height > v.Height {
+    return 0, 0, 0
+  }
+
+  // The kernels combine the mask with pixels loaded from memory.
+  return rgbaFindCrop64(v, needle, packRgba(rgbaMask), needleHash,
+      scratch[:cap(scratch)])
+}
+
+// RgbaFindCropInRect looks for a needle image inside a search rectangle of a
+// haystack image.
+// This is RgbaFindCrop, restricted to the matches that lie entirely inside the
+// search rectangle. The rolling hash only runs over the search rectangle, which
+// is clipped to the haystack's bounds. The match coordinates are relative to
+// the haystack's top-left corner. The scratch space capacity must be at least
+// 4 * searchRect.Dx().
+func RgbaFindCropInRect(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleHash uint32,
+    searchRect image.Rectangle, scratch []byte) (int, int, int) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindCropInRect(
+      NewRgbaView(needle, needleWidth, needleHeight), needleHash, searchRect,
+      scratch)
+}
+
+// FindCropInRect looks for a needle view inside a search rectangle of this
+// view.
+// This is the view equivalent of RgbaFindCropInRect. The search rectangle and
+// the match coordinates are relative to the view's top-left corner.
+func (v RgbaView) FindCropInRect(needle RgbaView, needleHash uint32,
+    searchRect image.Rectangle, scratch []byte) (int, int, int) {
+  v.check("Haystack view")
+  region, origin := v.searchRegion(searchRect)
+  count, matchLeft, matchTop := region.FindCrop(needle, needleHash, scratch)
+  return offsetMatch(count, matchLeft, matchTop, origin)
+}
+
+// RgbaFindMaskedCropInRect looks for a masked needle image inside a search
+// rectangle of a haystack image.
+// This is RgbaFindMaskedCrop, restricted to the matches that lie entirely
+// inside the search rectangle. The search rectangle is handled like in
+// RgbaFindCropInRect.
+func RgbaFindMaskedCropInRect(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, rgbaMask uint32,
+    needleHash uint32, searchRect image.Rectangle,
+    scratch []byte) (int, int, int) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindMaskedCropInRect(
+      NewRgbaView(needle, needleWidth, needleHeight), rgbaMask, needleHash,
+      searchRect, scratch)
+}
+
+// FindMaskedCropInRect looks for a masked needle view inside a search
+// rectangle of this view.
+// This is the view equivalent of RgbaFindMaskedCropInRect. The search
+// rectangle and the match coordinates are relative to the view's top-left
+// corner.
+func (v RgbaView) FindMaskedCropInRect(needle RgbaView, rgbaMask uint32,
+    needleHash uint32, searchRect image.Rectangle,
+    scratch []byte) (int, int, int) {
+  v.check("Haystack view")
+  region, origin := v.searchRegion(searchRect)
+  count, matchLeft, matchTop := region.FindMaskedCrop(needle, rgbaMask,
+      needleHash, scratch)
+  return offsetMatch(count, matchLeft, matchTop, origin)
+}
+
+// RgbaFindCrop64InRect looks for a needle image inside a search rectangle of a
+// haystack image using 64-bit hashes.
+// This is RgbaFindCrop64, restricted to the matches that lie entirely inside
+// the search rectangle. The search rectangle is handled like in
+// RgbaFindCropInRect. The scratch space capacity must be at least
+// 8 * searchRect.Dx().
+func RgbaFindCrop64InRect(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleHash uint64,
+    searchRect image.Rectangle, scratch []byte) (int, int, int) {
+  return RgbaFindMaskedCrop64InRect(haystack, hayWidth, hayHeight, needle,
+      needleWidth, needleHeight, 0xffffffff, needleHash, searchRect, scratch)
+}
+
+// RgbaFindMaskedCrop64InRect looks for a masked needle image inside a search
+// rectangle of a haystack image using 64-bit hashes.
+// This is RgbaFindMaskedCrop64, restricted to the matches that lie entirely
+// inside the search rectangle. The search rectangle is handled like in
+// RgbaFindCropInRect. The scratch space capacity must be at least
+// 8 * searchRect.Dx().
+func RgbaFindMaskedCrop64InRect(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, rgbaMask uint32,
+    needleHash uint64, searchRect image.Rectangle,
+    scratch []byte) (int, int, int) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindMaskedCrop64InRect(
+      NewRgbaView(needle, needleWidth, needleHeight), rgbaMask, needleHash,
+      searchRect, scratch)
+}
+
+// FindCrop64InRect looks for a needle view inside a search rectangle of this
+// view using 64-bit hashes.
+// This is the view equivalent of RgbaFindCrop64InRect.
+func (v RgbaView) FindCrop64InRect(needle RgbaView, needleHash uint64,
+    searchRect image.Rectangle, scratch []byte) (int, int, int) {
+  return v.FindMaskedCrop64InRect(needle, 0xffffffff, needleHash, searchRect,
+      scratch)
+}
+
+// FindMaskedCrop64InRect looks for a masked needle view inside a search
+// rectangle of this view using 64-bit hashes.
+// This is the view equivalent of RgbaFindMaskedCrop64InRect.
+func (v RgbaView) FindMaskedCrop64InRect(needle RgbaView, rgbaMask uint32,
+    needleHash uint64, searchRect image.Rectangle,
+    scratch []byte) (int, int, int) {
+  v.check("Haystack view")
+  region, origin := v.searchRegion(searchRect)
+  count, matchLeft, matchTop := region.FindMaskedCrop64(needle, rgbaMask,
+      needleHash, scratch)
+  return offsetMatch(count, matchLeft, matchTop, origin)
+}
+
+// offsetMatch turns a search region's match coordinates into coordinates
+// relative to the region's parent.
+// The arguments are a search's results, followed by the region's top-left
+// corner in its parent.
+func offsetMatch(count int, matchLeft int, matchTop int,
+    origin image.Point) (int, int, int) {
+  if count == 0 {
+    return 0, 0, 0
+  }
+  return count, matchLeft + origin.X, matchTop + origin.Y
+}
+
+// needleMasked returns true if a needle has no bits outside a mask.
+// Masked searches never match needles that fail this check.
+func needleMasked(needle RgbaView, rgbaMask uint32) bool {
+  maskBytes := [4]byte{byte(rgbaMask >> 24), byte(rgbaMask >> 16),
+      byte(rgbaMask >> 8), byte(rgbaMask)}
+  masked := true
+  needle.rows(func(row []byte) {
+    for i, b := range row {
+      if b &^ maskBytes[i & 3] != 0 {
+        masked = false
+        return
+      }
+    }
+  })
+  return masked
+}
+
+// CropMatch is a scored placement of a needle image in a haystack image.
+type CropMatch struct {
+  // Left is the X coordinate of the needle's top-left corner.
+  Left int
+  // Top is the Y coordinate of the needle's top-left corner.
+  Top int
+  // Diff is the placement's RgbaDiffMaskedCrop score. Lower is better.
+  Diff int64
+}
+
+// RgbaFindBestCrop finds the placements of a needle image in a haystack image
+// that have the lowest diffs.
+// Every placement is scored like in RgbaDiffMaskedCrop, so the needle is
+// assumed to have been masked. The best placements found so far are kept in
+// matches while the haystack is scanned. A placement is dropped if it overlaps
+// a kept placement that is at least as good, and it replaces the kept
+// placements that it overlaps otherwise, so near-duplicate hits around a match
+// are collapsed into the match. The search needs no memory beyond the matches
+// slice. At most len(matches) placements are stored in matches, from best to
+// worst, and ties are broken in scan order. It returns the number of
+// placements stored.
+func RgbaFindBestCrop(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, rgbaMask uint32,
+    matches []CropMatch) int {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
 
-  // RGBA -> ARGB, because Intel is little-endian.
-  argbMask := uint32(((rgbaMask & 0xff) << 24) | ((rgbaMask & 0xff00) << 8) |
-      ((rgbaMask & 0xff0000) >> 8) | ((rgbaMask & 0xff000000) >> 24))
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindBestCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), rgbaMask, matches)
+}
 
-  var cmatchLeft C.int
-  var cmatchTop C.int
-  ccount := C.GoRgbaFindMaskedCrop(unsafe.Pointer(&haystack[0]),
-      unsafe.Pointer(&needle[0]), C.int(hayWidth), C.int(hayHeight),
-      C.int(needleWidth), C.int(needleHeight), C.uint32_t(argbMask),
-      C.uint32_t(needleHash), unsafe.Pointer(&scratch[0]), &cmatchLeft,
-      &cmatchTop)
+// FindBestCrop finds the placements of a needle view in this view that have
+// the lowest diffs.
+// This is the view equivalent of RgbaFindBestCrop. The match coordinates are
+// relative to the view's top-left corner.
+func (v RgbaView) FindBestCrop(needle RgbaView, rgbaMask uint32,
+    matches []CropMatch) int {
+  v.check("Haystack view")
+  needle.check("Needle view")
+  if len(matches) == 0 || needle.Empty() || needle.Width > v.Width ||
+      needle.Height > v.Height {
+    return 0
+  }
 
-  return int(ccount), int(cmatchLeft), int(cmatchTop)
+  // The kernels combine the mask with pixels loaded from memory.
+  return rgbaFindBestCrop(v, needle, packRgba(rgbaMask), matches)
+}
+
+// RgbaFindBestCropInRect finds the placements of a needle image inside a
+// search rectangle of a haystack image that have the lowest diffs.
+// This is RgbaFindBestCrop, restricted to the placements that lie entirely
+// inside the search rectangle. The search rectangle is handled like in
+// RgbaFindCropInRect.
+func RgbaFindBestCropInRect(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, rgbaMask uint32,
+    searchRect image.Rectangle, matches []CropMatch) int {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).FindBestCropInRect(
+      NewRgbaView(needle, needleWidth, needleHeight), rgbaMask, searchRect,
+      matches)
+}
+
+// FindBestCropInRect finds the placements of a needle view inside a search
+// rectangle of this view that have the lowest diffs.
+// This is the view equivalent of RgbaFindBestCropInRect. The search rectangle
+// and the match coordinates are relative to the view's top-left corner.
+func (v RgbaView) FindBestCropInRect(needle RgbaView, rgbaMask uint32,
+    searchRect image.Rectangle, matches []CropMatch) int {
+  v.check("Haystack view")
+  region, origin := v.searchRegion(searchRect)
+  count := region.FindBestCrop(needle, rgbaMask, matches)
+  for i := 0; i < count; i += 1 {
+    _, matches[i].Left, matches[i].Top = offsetMatch(1, matches[i].Left,
+        matches[i].Top, origin)
+  }
+  return count
 }
@@ -0,0 +1,208 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/matchers.c"
+import "C"  // cgo
+
+import (
+  "image"
+  "unsafe"
+)
+
+// The kernels below take non-empty views that have been checked. The needle
+// must fit inside the haystack at the given position.
+//
+// NOTE: The haystack's height is irrelevant to the actual matching logic, so
+//       it is omitted from the C calls that verify a single position.
+
+// rgbaCheckCrop returns true if a needle is a cropped version of a haystack.
+func rgbaCheckCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int) bool {
+  cresult := C.GoRgbaCheckCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Stride / 4),
+      C.int(needle.Stride / 4), C.int(needle.Width), C.int(needle.Height),
+      C.int(needleLeft), C.int(needleTop))
+  return cresult != 0
+}
+
+// rgbaCheckMaskedCrop checks if a needle is a crop&mask from a haystack.
+// The mask is a packed pixel.
+func rgbaCheckMaskedCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, pixelMask uint32) bool {
+  cresult := C.GoRgbaCheckMaskedCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Stride / 4),
+      C.int(needle.Stride / 4), C.int(needle.Width), C.int(needle.Height),
+      C.int(needleLeft), C.int(needleTop), C.uint32_t(pixelMask),
+      C.int(simdLevel))
+  return cresult != 0
+}
+
+// rgbaDiffMaskedCrop diffs a needle with a crop&mask of a haystack.
+// The mask is a packed pixel.
+func rgbaDiffMaskedCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, pixelMask uint32) int64 {
+  cresult := C.GoRgbaDiffMaskedCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Stride / 4),
+      C.int(needle.Stride / 4), C.int(needle.Width), C.int(needle.Height),
+      C.int(needleLeft), C.int(needleTop), C.uint32_t(pixelMask),
+      C.int(simdLevel))
+  return int64(cresult)
+}
+
+// rgbaDiffThresholdCrop diffs a needle with a crop&threshold of a haystack.
+func rgbaDiffThresholdCrop(haystack RgbaView, needle RgbaView,
+    needleLeft int, needleTop int, minRed uint8, minGreen uint8,
+    minBlue uint8, maxRed uint8, maxGreen uint8, maxBlue uint8) int {
+  cresult := C.GoRgbaDiffThresholdCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Stride / 4),
+      C.int(needle.Stride / 4), C.int(needle.Width), C.int(needle.Height),
+      C.int(needleLeft), C.int(needleTop), C.uint8_t(minRed),
+      C.uint8_t(minGreen), C.uint8_t(minBlue), C.uint8_t(maxRed),
+      C.uint8_t(maxGreen), C.uint8_t(maxBlue), C.int(simdLevel))
+  return int(cresult)
+}
+
+// hashForRgbaFindCrop computes the Rabin-Karp hash of a masked needle.
+// The mask is a packed pixel.
+func hashForRgbaFindCrop(needle RgbaView, pixelMask uint32) uint32 {
+  chash := C.GoHashForRgbaFindCrop(unsafe.Pointer(&needle.Pix[0]),
+      C.int(needle.Width), C.int(needle.Height), C.int(needle.Stride / 4),
+      C.uint32_t(pixelMask))
+  return uint32(chash)
+}
+
+// hashForRgbaFindCrop64 computes the 64-bit hash of a masked needle.
+// The mask is a packed pixel.
+func hashForRgbaFindCrop64(needle RgbaView, pixelMask uint32) uint64 {
+  chash := C.GoHashForRgbaFindCrop64(unsafe.Pointer(&needle.Pix[0]),
+      C.int(needle.Width), C.int(needle.Height), C.int(needle.Stride / 4),
+      C.uint32_t(pixelMask))
+  return uint64(chash)
+}
+
+// rgbaFindCrop looks for a needle in a haystack.
+// The needle must not be larger than the haystack, and the scratch space must
+// be at least 4 * haystack.Width bytes long.
+func rgbaFindCrop(haystack RgbaView, needle RgbaView, needleHash uint32,
+    scratch []byte) (int, int, int) {
+  var cmatchLeft C.int
+  var cmatchTop C.int
+  ccount := C.GoRgbaFindCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Width),
+      C.int(haystack.Height), C.int(haystack.Stride / 4), C.int(needle.Width),
+      C.int(needle.Height), C.int(needle.Stride / 4), C.uint32_t(needleHash),
+      unsafe.Pointer(&scratch[0]), &cmatchLeft, &cmatchTop)
+  return int(ccount), int(cmatchLeft), int(cmatchTop)
+}
+
+// rgbaFindMaskedCrop looks for a masked needle in a haystack.
+// The mask is a packed pixel. The needle must not be larger than the haystack,
+// and the scratch space must be at least 4 * haystack.Width bytes long.
+func rgbaFindMaskedCrop(haystack RgbaView, needle RgbaView, pixelMask uint32,
+    needleHash uint32, scratch []byte) (int, int, int) {
+  var cmatchLeft C.int
+  var cmatchTop C.int
+  ccount := C.GoRgbaFindMaskedCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Width),
+      C.int(haystack.Height), C.int(haystack.Stride / 4), C.int(needle.Width),
+      C.int(needle.Height), C.int(needle.Stride / 4), C.uint32_t(pixelMask),
+      C.uint32_t(needleHash), unsafe.Pointer(&scratch[0]), &cmatchLeft,
+      &cmatchTop, C.int(simdLevel))
+  return int(ccount), int(cmatchLeft), int(cmatchTop)
+}
+
+// rgbaFindCrops looks for many same-sized needles in a haystack.
+// The needles are stored one after another, and are sorted by their hashes.
+// The needles must not be larger than the haystack, and the scratch space must
+// be at least 4 * haystack.Width bytes long. Matches are stored as (needle,
+// left, top) triples, until the matches slice fills up. It returns the total
+// number of matches.
+func rgbaFindCrops(haystack RgbaView, needles []byte, needleWidth int,
+    needleHeight int, hashes []uint32, scratch []byte, matches []int32) int {
+  ccount := C.GoRgbaFindCrops(unsafe.Pointer(&haystack.Pix[0]),
+      C.int(haystack.Width), C.int(haystack.Height),
+      C.int(haystack.Stride / 4), unsafe.Pointer(&needles[0]),
+      C.int(needleWidth), C.int(needleHeight), C.int(len(hashes)),
+      unsafe.Pointer(&hashes[0]), unsafe.Pointer(&scratch[0]),
+      unsafe.Pointer(&matches[0]), C.int(len(matches) / 3))
+  return int(ccount)
+}
+
+// rgbaFindCrop64 looks for a masked needle in a haystack using 64-bit hashes.
+// The mask is a packed pixel. The needle must not be larger than the haystack,
+// and the scratch space must be at least 8 * haystack.Width bytes long.
+func rgbaFindCrop64(haystack RgbaView, needle RgbaView, pixelMask uint32,
+    needleHash uint64, scratch []byte) (int, int, int) {
+  var cmatchLeft C.int
+  var cmatchTop C.int
+  ccount := C.GoRgbaFindCrop64(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Width),
+      C.int(haystack.Height), C.int(haystack.Stride / 4), C.int(needle.Width),
+      C.int(needle.Height), C.int(needle.Stride / 4), C.uint32_t(pixelMask),
+      C.uint64_t(needleHash), unsafe.Pointer(&scratch[0]), &cmatchLeft,
+      &cmatchTop, C.int(simdLevel))
+  return int(ccount), int(cmatchLeft), int(cmatchTop)
+}
+
+// rgbaCheckKeyedCrop checks if a needle's keyed pixels match a haystack.
+func rgbaCheckKeyedCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, alphaCutoff int) bool {
+  cresult := C.GoRgbaCheckKeyedCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Stride / 4),
+      C.int(needle.Stride / 4), C.int(needle.Width), C.int(needle.Height),
+      C.int(needleLeft), C.int(needleTop), C.int(alphaCutoff))
+  return cresult != 0
+}
+
+// rgbaDiffKeyedCrop diffs a needle's keyed pixels with a haystack.
+func rgbaDiffKeyedCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, alphaCutoff int) int64 {
+  cresult := C.GoRgbaDiffKeyedCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Stride / 4),
+      C.int(needle.Stride / 4), C.int(needle.Width), C.int(needle.Height),
+      C.int(needleLeft), C.int(needleTop), C.int(alphaCutoff))
+  return int64(cresult)
+}
+
+// rgbaFindKeyedCrop looks for a needle's keyed pixels in a haystack.
+// The anchor must be a non-empty rectangle of keyed pixels inside the needle.
+// The needle must not be larger than the haystack, and the scratch space must
+// be at least 4 * haystack.Width bytes long.
+func rgbaFindKeyedCrop(haystack RgbaView, needle RgbaView,
+    anchor image.Rectangle, anchorHash uint32, alphaCutoff int,
+    scratch []byte) (int, int, int) {
+  var cmatchLeft C.int
+  var cmatchTop C.int
+  ccount := C.GoRgbaFindKeyedCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Width),
+      C.int(haystack.Height), C.int(haystack.Stride / 4), C.int(needle.Width),
+      C.int(needle.Height), C.int(needle.Stride / 4), C.int(anchor.Min.X),
+      C.int(anchor.Min.Y), C.int(anchor.Dx()), C.int(anchor.Dy()),
+      C.uint32_t(anchorHash), C.int(alphaCutoff), unsafe.Pointer(&scratch[0]),
+      &cmatchLeft, &cmatchTop)
+  return int(ccount), int(cmatchLeft), int(cmatchTop)
+}
+
+// rgbaFindBestCrop finds the needle placements with the lowest diffs.
+// The mask is a packed pixel. The needle must not be larger than the haystack,
+// and the matches slice must not be empty. It returns the number of matches
+// stored.
+func rgbaFindBestCrop(haystack RgbaView, needle RgbaView, pixelMask uint32,
+    matches []CropMatch) int {
+  positions := make([]int32, 2 * len(matches))
+  diffs := make([]int64, len(matches))
+  ccount := C.GoRgbaFindBestCrop(unsafe.Pointer(&haystack.Pix[0]),
+      unsafe.Pointer(&needle.Pix[0]), C.int(haystack.Width),
+      C.int(haystack.Height), C.int(haystack.Stride / 4), C.int(needle.Width),
+      C.int(needle.Height), C.int(needle.Stride / 4), C.uint32_t(pixelMask),
+      (*C.int32_t)(unsafe.Pointer(&positions[0])),
+      (*C.int64_t)(unsafe.Pointer(&diffs[0])), C.int(len(matches)),
+      C.int(simdLevel))
+  count := int(ccount)
+  for i := 0; i < count; i += 1 {
+    matches[i] = CropMatch{Left: int(positions[2 * i]),
+        Top: int(positions[2 * i + 1]), Diff: diffs[i]}
+  }
+  return count
+}
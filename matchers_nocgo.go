@@ -0,0 +1,460 @@
+//go:build !cgo
+
+package imageutil
+
+import (
+  "bytes"
+  "encoding/binary"
+  "image"
+  "sort"
+)
+
+// The kernels below take non-empty views that have been checked. The needle
+// must fit inside the haystack at the given position. They are the pure Go
+// versions of the functions in c/matchers.c.
+
+// rgbaCheckCrop returns true if a needle is a cropped version of a haystack.
+func rgbaCheckCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int) bool {
+  rowSize := needle.Width * 4
+  for y := 0; y < needle.Height; y += 1 {
+    hayStart := haystack.PixOffset(needleLeft, needleTop + y)
+    needleStart := needle.PixOffset(0, y)
+    if !bytes.Equal(haystack.Pix[hayStart:hayStart + rowSize],
+        needle.Pix[needleStart:needleStart + rowSize]) {
+      return false
+    }
+  }
+  return true
+}
+
+// pixelMaskBytes returns the RGBA bytes in a packed pixel mask.
+func pixelMaskBytes(pixelMask uint32) [4]byte {
+  var maskBytes [4]byte
+  binary.NativeEndian.PutUint32(maskBytes[:], pixelMask)
+  return maskBytes
+}
+
+// rgbaCheckMaskedCrop checks if a needle is a crop&mask from a haystack.
+// The mask is a packed pixel.
+func rgbaCheckMaskedCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, pixelMask uint32) bool {
+  maskBytes := pixelMaskBytes(pixelMask)
+  rowSize := needle.Width * 4
+  for y := 0; y < needle.Height; y += 1 {
+    hayRow := haystack.Pix[haystack.PixOffset(needleLeft, needleTop + y):]
+    needleRow := needle.Pix[needle.PixOffset(0, y):]
+    for i := 0; i < rowSize; i += 1 {
+      if hayRow[i] & maskBytes[i & 3] != needleRow[i] {
+        return false
+      }
+    }
+  }
+  return true
+}
+
+// rgbaDiffMaskedCrop diffs a needle with a crop&mask of a haystack.
+// The mask is a packed pixel.
+func rgbaDiffMaskedCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, pixelMask uint32) int64 {
+  maskBytes := pixelMaskBytes(pixelMask)
+  rowSize := needle.Width * 4
+  var diff int64
+  for y := 0; y < needle.Height; y += 1 {
+    hayRow := haystack.Pix[haystack.PixOffset(needleLeft, needleTop + y):]
+    needleRow := needle.Pix[needle.PixOffset(0, y):]
+    for i := 0; i < rowSize; i += 1 {
+      hayChannel := int64(hayRow[i] & maskBytes[i & 3])
+      needleChannel := int64(needleRow[i])
+      if hayChannel >= needleChannel {
+        diff += hayChannel - needleChannel
+      } else {
+        diff += needleChannel - hayChannel
+      }
+    }
+  }
+  return diff
+}
+
+// rgbaDiffThresholdCrop diffs a needle with a crop&threshold of a haystack.
+func rgbaDiffThresholdCrop(haystack RgbaView, needle RgbaView,
+    needleLeft int, needleTop int, minRed uint8, minGreen uint8,
+    minBlue uint8, maxRed uint8, maxGreen uint8, maxBlue uint8) int {
+  diff := 0
+  for y := 0; y < needle.Height; y += 1 {
+    hayRow := haystack.Pix[haystack.PixOffset(needleLeft, needleTop + y):]
+    needleRow := needle.Pix[needle.PixOffset(0, y):]
+    for i := 0; i < needle.Width * 4; i += 4 {
+      r, g, b := hayRow[i], hayRow[i + 1], hayRow[i + 2]
+      var hayAlpha byte
+      if r >= minRed && g >= minGreen && b >= minBlue &&
+          r <= maxRed && g <= maxGreen && b <= maxBlue {
+        hayAlpha = 0xff
+      }
+      if hayAlpha != needleRow[i + 3] {
+        diff += 1
+      }
+    }
+  }
+  return diff
+}
+
+// The Rabin-Karp hash constants. These must match the ones in c/matchers.c.
+const (
+  // Multiplicative constant across column hashes.
+  hashKx = 1000000007
+  // Multiplicative constant across a column.
+  hashKy = 1000000007
+  // Hash modulo.
+  hashM = 2000000011
+
+  // The second set of constants used by the 64-bit hashes.
+  hashKx2 = 911382323
+  hashKy2 = 972663749
+  hashM2 = 2147483647
+)
+
+// (a * b) % m
+func mulMod(a uint32, b uint32, m uint32) uint32 {
+  return uint32((uint64(a) * uint64(b)) % uint64(m))
+}
+
+// (a - b) % m
+func modSub(a uint32, b uint32, m uint32) uint32 {
+  return uint32((uint64(m) + uint64(a) - uint64(b)) % uint64(m))
+}
+
+// (a * b + c) % m
+func mulModAdd(a uint32, b uint32, c uint32, m uint32) uint32 {
+  return uint32((uint64(a) * uint64(b) + uint64(c)) % uint64(m))
+}
+
+// canonicalPixel returns the value hashed for the pixel at a given offset.
+// This matches pixelCanonical in c/pixel.h.
+func canonicalPixel(pix []byte, offset int) uint32 {
+  return binary.LittleEndian.Uint32(pix[offset:offset + 4])
+}
+
+// rabinKarpHash computes the Rabin-Karp hash of a needle.
+// The canonical mask is applied to canonical pixels before they are hashed.
+func rabinKarpHash(needle RgbaView, canonicalMask uint32, kx uint32,
+    ky uint32, m uint32) uint32 {
+  var hash uint32
+  for x := 0; x < needle.Width; x += 1 {
+    var chash uint32
+    for y := 0; y < needle.Height; y += 1 {
+      pixel := canonicalPixel(needle.Pix, needle.PixOffset(x, y))
+      chash = mulModAdd(chash, ky, pixel & canonicalMask, m)
+    }
+    hash = mulModAdd(hash, kx, chash, m)
+  }
+  return hash
+}
+
+// canonicalMask converts a packed pixel mask into a mask for canonical pixels.
+func canonicalMask(pixelMask uint32) uint32 {
+  maskBytes := pixelMaskBytes(pixelMask)
+  return binary.LittleEndian.Uint32(maskBytes[:])
+}
+
+// hashForRgbaFindCrop computes the Rabin-Karp hash of a masked needle.
+// The mask is a packed pixel.
+func hashForRgbaFindCrop(needle RgbaView, pixelMask uint32) uint32 {
+  return rabinKarpHash(needle, canonicalMask(pixelMask), hashKx, hashKy,
+      hashM)
+}
+
+// hashForRgbaFindCrop64 computes the 64-bit hash of a masked needle.
+// The mask is a packed pixel.
+func hashForRgbaFindCrop64(needle RgbaView, pixelMask uint32) uint64 {
+  mask := canonicalMask(pixelMask)
+  hash1 := rabinKarpHash(needle, mask, hashKx, hashKy, hashM)
+  hash2 := rabinKarpHash(needle, mask, hashKx2, hashKy2, hashM2)
+  return uint64(hash1) << 32 | uint64(hash2)
+}
+
+// rabinKarpScan computes the Rabin-Karp hashes of all the haystack windows
+// that have the given size.
+// The canonical mask is applied to canonical haystack pixels before they are
+// hashed. The hit function is called with every window's hash and position,
+// in scan order.
+func rabinKarpScan(haystack RgbaView, needleWidth int, needleHeight int,
+    canonicalMask uint32, scratch []byte,
+    hitFunc func(hash uint32, needleLeft int, needleTop int)) {
+  kxW := uint32(1)  // kx ^ w % m
+  for x := 0; x < needleWidth; x += 1 {
+    kxW = mulMod(kxW, hashKx, hashM)
+  }
+  kyH := uint32(1)  // ky ^ h % m
+  for y := 0; y < needleHeight; y += 1 {
+    kyH = mulMod(kyH, hashKy, hashM)
+  }
+
+  // The column hashes are stored in the scratch space.
+  chash := func(x int) uint32 {
+    return binary.NativeEndian.Uint32(scratch[x * 4:x * 4 + 4])
+  }
+  setChash := func(x int, value uint32) {
+    binary.NativeEndian.PutUint32(scratch[x * 4:x * 4 + 4], value)
+  }
+  pixel := func(x int, y int) uint32 {
+    return canonicalPixel(haystack.Pix, haystack.PixOffset(x, y)) &
+        canonicalMask
+  }
+
+  for x := 0; x < haystack.Width; x += 1 {
+    var value uint32
+    for y := 0; y < needleHeight; y += 1 {
+      value = mulModAdd(value, hashKy, pixel(x, y), hashM)
+    }
+    setChash(x, value)
+  }
+
+  for top := 0; top + needleHeight <= haystack.Height; top += 1 {
+    if top > 0 {
+      // Roll the column hashes down by one row.
+      newY, oldY := top + needleHeight - 1, top - 1
+      for x := 0; x < haystack.Width; x += 1 {
+        value := mulModAdd(chash(x), hashKy, pixel(x, newY), hashM)
+        value = modSub(value, mulMod(pixel(x, oldY), kyH, hashM), hashM)
+        setChash(x, value)
+      }
+    }
+
+    var hash uint32
+    for x := 0; x < haystack.Width; x += 1 {
+      hash = mulModAdd(hash, hashKx, chash(x), hashM)
+      if x >= needleWidth {
+        hash = modSub(hash, mulMod(chash(x - needleWidth), kxW, hashM),
+            hashM)
+      }
+      if x + 1 >= needleWidth {
+        hitFunc(hash, x - needleWidth + 1, top)
+      }
+    }
+  }
+}
+
+// rabinKarpFind runs the Rabin-Karp search shared by the find kernels.
+// Each window whose hash matches the needle's hash is verified by calling the
+// check function. It returns the number of matches and the coordinates of the
+// last match.
+func rabinKarpFind(haystack RgbaView, needle RgbaView, needleHash uint32,
+    canonicalMask uint32, scratch []byte,
+    checkFunc func(needleLeft int, needleTop int) bool) (int, int, int) {
+  matchCount, matchLeft, matchTop := 0, 0, 0
+  rabinKarpScan(haystack, needle.Width, needle.Height, canonicalMask, scratch,
+      func(hash uint32, needleLeft int, needleTop int) {
+        if hash == needleHash && checkFunc(needleLeft, needleTop) {
+          matchCount += 1
+          matchLeft, matchTop = needleLeft, needleTop
+        }
+      })
+  return matchCount, matchLeft, matchTop
+}
+
+// rgbaFindCrop looks for a needle in a haystack.
+// The needle must not be larger than the haystack, and the scratch space must
+// be at least 4 * haystack.Width bytes long.
+func rgbaFindCrop(haystack RgbaView, needle RgbaView, needleHash uint32,
+    scratch []byte) (int, int, int) {
+  return rabinKarpFind(haystack, needle, needleHash, 0xffffffff, scratch,
+      func(needleLeft int, needleTop int) bool {
+        return rgbaCheckCrop(haystack, needle, needleLeft, needleTop)
+      })
+}
+
+// rgbaFindMaskedCrop looks for a masked needle in a haystack.
+// The mask is a packed pixel. The needle must not be larger than the haystack,
+// and the scratch space must be at least 4 * haystack.Width bytes long.
+func rgbaFindMaskedCrop(haystack RgbaView, needle RgbaView, pixelMask uint32,
+    needleHash uint32, scratch []byte) (int, int, int) {
+  return rabinKarpFind(haystack, needle, needleHash,
+      canonicalMask(pixelMask), scratch,
+      func(needleLeft int, needleTop int) bool {
+        return rgbaCheckMaskedCrop(haystack, needle, needleLeft, needleTop,
+            pixelMask)
+      })
+}
+
+// rgbaFindCrops looks for many same-sized needles in a haystack.
+// The needles are stored one after another, and are sorted by their hashes.
+// The needles must not be larger than the haystack, and the scratch space must
+// be at least 4 * haystack.Width bytes long. Matches are stored as (needle,
+// left, top) triples, until the matches slice fills up. It returns the total
+// number of matches.
+func rgbaFindCrops(haystack RgbaView, needles []byte, needleWidth int,
+    needleHeight int, hashes []uint32, scratch []byte, matches []int32) int {
+  needleSize := needleWidth * needleHeight * 4
+  matchCount := 0
+  rabinKarpScan(haystack, needleWidth, needleHeight, 0xffffffff, scratch,
+      func(hash uint32, needleLeft int, needleTop int) {
+        i := sort.Search(len(hashes), func(i int) bool {
+          return hashes[i] >= hash
+        })
+        for ; i < len(hashes) && hashes[i] == hash; i += 1 {
+          needle := NewRgbaView(needles[i * needleSize:(i + 1) * needleSize],
+              needleWidth, needleHeight)
+          if !rgbaCheckCrop(haystack, needle, needleLeft, needleTop) {
+            continue
+          }
+          if matchCount < len(matches) / 3 {
+            matches[matchCount * 3] = int32(i)
+            matches[matchCount * 3 + 1] = int32(needleLeft)
+            matches[matchCount * 3 + 2] = int32(needleTop)
+          }
+          matchCount += 1
+        }
+      })
+  return matchCount
+}
+
+// rgbaFindCrop64 looks for a masked needle in a haystack using 64-bit hashes.
+// The mask is a packed pixel. The needle must not be larger than the haystack,
+// and the scratch space must be at least 8 * haystack.Width bytes long.
+//
+// NOTE: Unlike the C version, this only computes the second hash for windows
+//       whose first hash matches. The results are the same.
+func rgbaFindCrop64(haystack RgbaView, needle RgbaView, pixelMask uint32,
+    needleHash uint64, scratch []byte) (int, int, int) {
+  mask := canonicalMask(pixelMask)
+  return rabinKarpFind(haystack, needle, uint32(needleHash >> 32), mask,
+      scratch, func(needleLeft int, needleTop int) bool {
+        window, _ := haystack.Crop(needleLeft, needleTop, needle.Width,
+            needle.Height)
+        if rabinKarpHash(window, mask, hashKx2, hashKy2,
+            hashM2) != uint32(needleHash) {
+          return false
+        }
+        return rgbaCheckMaskedCrop(haystack, needle, needleLeft, needleTop,
+            pixelMask)
+      })
+}
+
+// rgbaCheckKeyedCrop checks if a needle's keyed pixels match a haystack.
+func rgbaCheckKeyedCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, alphaCutoff int) bool {
+  for y := 0; y < needle.Height; y += 1 {
+    hayRow := haystack.Pix[haystack.PixOffset(needleLeft, needleTop + y):]
+    needleRow := needle.Pix[needle.PixOffset(0, y):]
+    for i := 0; i < needle.Width * 4; i += 4 {
+      if int(needleRow[i + 3]) < alphaCutoff {
+        continue
+      }
+      if hayRow[i] != needleRow[i] || hayRow[i + 1] != needleRow[i + 1] ||
+          hayRow[i + 2] != needleRow[i + 2] {
+        return false
+      }
+    }
+  }
+  return true
+}
+
+// rgbaDiffKeyedCrop diffs a needle's keyed pixels with a haystack.
+func rgbaDiffKeyedCrop(haystack RgbaView, needle RgbaView, needleLeft int,
+    needleTop int, alphaCutoff int) int64 {
+  var diff int64
+  for y := 0; y < needle.Height; y += 1 {
+    hayRow := haystack.Pix[haystack.PixOffset(needleLeft, needleTop + y):]
+    needleRow := needle.Pix[needle.PixOffset(0, y):]
+    for i := 0; i < needle.Width * 4; i += 4 {
+      if int(needleRow[i + 3]) < alphaCutoff {
+        continue
+      }
+      for channel := i; channel < i + 3; channel += 1 {
+        hayChannel := int64(hayRow[channel])
+        needleChannel := int64(needleRow[channel])
+        if hayChannel >= needleChannel {
+          diff += hayChannel - needleChannel
+        } else {
+          diff += needleChannel - hayChannel
+        }
+      }
+    }
+  }
+  return diff
+}
+
+// rgbaFindKeyedCrop looks for a needle's keyed pixels in a haystack.
+// The anchor must be a non-empty rectangle of keyed pixels inside the needle.
+// The needle must not be larger than the haystack, and the scratch space must
+// be at least 4 * haystack.Width bytes long.
+func rgbaFindKeyedCrop(haystack RgbaView, needle RgbaView,
+    anchor image.Rectangle, anchorHash uint32, alphaCutoff int,
+    scratch []byte) (int, int, int) {
+  // The anchor is scanned over the part of the haystack where it can appear
+  // while the whole needle fits in the haystack. A window at (x, y) in this
+  // region corresponds to a needle at (x, y) in the haystack.
+  region, _ := haystack.Crop(anchor.Min.X, anchor.Min.Y,
+      haystack.Width - needle.Width + anchor.Dx(),
+      haystack.Height - needle.Height + anchor.Dy())
+
+  matchCount, matchLeft, matchTop := 0, 0, 0
+  rabinKarpScan(region, anchor.Dx(), anchor.Dy(),
+      canonicalMask(packRgba(0xffffff00)), scratch,
+      func(hash uint32, needleLeft int, needleTop int) {
+        if hash == anchorHash && rgbaCheckKeyedCrop(haystack, needle,
+            needleLeft, needleTop, alphaCutoff) {
+          matchCount += 1
+          matchLeft, matchTop = needleLeft, needleTop
+        }
+      })
+  return matchCount, matchLeft, matchTop
+}
+
+// rgbaFindBestCrop finds the needle placements with the lowest diffs.
+// The mask is a packed pixel. The needle must not be larger than the haystack,
+// and the matches slice must not be empty. It returns the number of matches
+// stored.
+func rgbaFindBestCrop(haystack RgbaView, needle RgbaView, pixelMask uint32,
+    matches []CropMatch) int {
+  placeWidth := haystack.Width - needle.Width + 1
+  placeHeight := haystack.Height - needle.Height + 1
+  overlaps := func(match CropMatch, left int, top int) bool {
+    dx, dy := left - match.Left, top - match.Top
+    return dx > -needle.Width && dx < needle.Width &&
+        dy > -needle.Height && dy < needle.Height
+  }
+
+  matchCount := 0
+  for top := 0; top < placeHeight; top += 1 {
+    for left := 0; left < placeWidth; left += 1 {
+      diff := rgbaDiffMaskedCrop(haystack, needle, left, top, pixelMask)
+      if matchCount == len(matches) && diff >= matches[matchCount - 1].Diff {
+        continue
+      }
+
+      suppressed := false
+      for _, match := range matches[:matchCount] {
+        if overlaps(match, left, top) && match.Diff <= diff {
+          suppressed = true
+          break
+        }
+      }
+      if suppressed {
+        continue
+      }
+
+      // The remaining overlapping matches are worse than the placement.
+      keptCount := 0
+      for _, match := range matches[:matchCount] {
+        if !overlaps(match, left, top) {
+          matches[keptCount] = match
+          keptCount += 1
+        }
+      }
+      matchCount = keptCount
+
+      // The worst match is dropped when the matches are full.
+      slot := min(matchCount, len(matches) - 1)
+      for ; slot > 0 && matches[slot - 1].Diff > diff; slot -= 1 {
+        matches[slot] = matches[slot - 1]
+      }
+      matches[slot] = CropMatch{Left: left, Top: top, Diff: diff}
+      if matchCount < len(matches) {
+        matchCount += 1
+      }
+    }
+  }
+  return matchCount
+}
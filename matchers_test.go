@@ -1,6 +1,8 @@
 package imageutil
 
 import (
+  "image"
+  "reflect"
   "testing"
 )
 
@@ -314,3 +316,362 @@ func TestRgbaFindMaskedCrop(t *testing.T) {
     }
   }
 }
+
+func TestRgbaFindCropParallel(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  // Stamp copies of a needle all over the image, so that some copies
+  // straddle the band boundaries for every worker count.
+  var needle []byte
+  CropRgba(image.Pix, width, height, 100, 100, 12, 20, &needle)
+  for y := 0; y + 20 <= height; y += 37 {
+    for x := 0; x + 12 <= width; x += 151 {
+      for row := 0; row < 20; row += 1 {
+        copy(image.Pix[image.PixOffset(x, y + row):],
+            needle[row * 48:(row + 1) * 48])
+      }
+    }
+  }
+
+  scratch := make([]byte, width * 4)
+  hash := HashForRgbaFindCrop(needle, 12, 20)
+  goldCount, goldX, goldY := RgbaFindCrop(image.Pix, width, height, needle,
+      12, 20, hash, scratch)
+  mask := uint32(0xf0f0f0ff)
+  var maskedNeedle []byte
+  CropRgba(needle, 12, 20, 0, 0, 12, 20, &maskedNeedle)
+  MaskRgba(maskedNeedle, BuildRgbaMask(mask))
+  maskedHash := HashForRgbaFindCrop(maskedNeedle, 12, 20)
+  goldMaskedCount, goldMaskedX, goldMaskedY := RgbaFindMaskedCrop(image.Pix,
+      width, height, maskedNeedle, 12, 20, mask, maskedHash, scratch)
+  if goldCount < 2 || goldMaskedCount < goldCount {
+    t.Fatalf("Bad sequential match counts %d and %d\n", goldCount,
+        goldMaskedCount)
+  }
+
+  previous := SetConcurrency(0)
+  defer SetConcurrency(previous)
+  for workers := 1; workers <= 9; workers += 1 {
+    SetConcurrency(workers)
+
+    count, matchX, matchY := RgbaFindCropParallel(image.Pix, width, height,
+        needle, 12, 20, hash)
+    if count != goldCount || matchX != goldX || matchY != goldY {
+      t.Errorf("%d workers: count %d, matchX %d, matchY %d, expected %d, "+
+          "%d, %d\n", workers, count, matchX, matchY, goldCount, goldX, goldY)
+    }
+
+    count, matchX, matchY = RgbaFindMaskedCropParallel(image.Pix, width,
+        height, maskedNeedle, 12, 20, mask, maskedHash)
+    if count != goldMaskedCount || matchX != goldMaskedX ||
+        matchY != goldMaskedY {
+      t.Errorf("%d workers: masked count %d, matchX %d, matchY %d, expected "+
+          "%d, %d, %d\n", workers, count, matchX, matchY, goldMaskedCount,
+          goldMaskedX, goldMaskedY)
+    }
+  }
+}
+
+func TestHashForRgbaFindMaskedCrop(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  var cropBytes []byte
+  for _, rgbaMask := range []uint32{0xffffffff, 0xc0f0e0ff, 0xf0f0f000} {
+    CropRgba(image.Pix, width, height, 100, 200, 16, 8, &cropBytes)
+    hash := HashForRgbaFindMaskedCrop(cropBytes, 16, 8, rgbaMask)
+    hash64 := HashForRgbaFindMaskedCrop64(cropBytes, 16, 8, rgbaMask)
+
+    MaskRgba(cropBytes, BuildRgbaMask(rgbaMask))
+    if goldHash := HashForRgbaFindCrop(cropBytes, 16, 8); hash != goldHash {
+      t.Errorf("Mask %08x: masked hash %d does not match pre-masked hash %d",
+          rgbaMask, hash, goldHash)
+    }
+    if goldHash := HashForRgbaFindCrop64(cropBytes, 16, 8); hash64 !=
+        goldHash {
+      t.Errorf("Mask %08x: masked 64-bit hash %d does not match pre-masked " +
+          "hash %d", rgbaMask, hash64, goldHash)
+    }
+    if uint32(hash64 >> 32) != hash {
+      t.Errorf("Mask %08x: 64-bit hash %d does not start with 32-bit hash %d",
+          rgbaMask, hash64, hash)
+    }
+  }
+}
+
+func TestRgbaFindCrop64(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  // A tiled haystack is full of near-matches, which stresses the hash checks.
+  tiled := make([]byte, width * height * 4)
+  for y := 0; y < height; y += 1 {
+    for x := 0; x < width; x += 1 {
+      offset := image.PixOffset(x % 24, y % 16)
+      copy(tiled[(y * width + x) * 4:], image.Pix[offset:offset + 4])
+    }
+  }
+
+  cases := [][5]int {
+    { 0, 0, 16, 8, 0xffffffff },
+    { 10, 10, 16, 8, 0xffffffff },
+    { 500, 300, 12, 84, 0xffffffff },
+    { 0, 0, 16, 8, 0xc0f0e0ff },
+    { 500, 300, 12, 84, 0xc0f0e0ff },
+    { 100, 0, 24, 16, 0xc0c0c0ff },
+  }
+
+  scratch32 := make([]byte, width * 4)
+  scratch64 := make([]byte, width * 8)
+  var cropBytes []byte
+  for _, haystack := range [][]byte{image.Pix, tiled} {
+    for _, testCase := range cases {
+      xOffset, yOffset := testCase[0], testCase[1]
+      xSize, ySize := testCase[2], testCase[3]
+      rgbaMask := uint32(testCase[4])
+
+      CropRgba(haystack, width, height, xOffset, yOffset, xSize, ySize,
+          &cropBytes)
+      MaskRgba(cropBytes, BuildRgbaMask(rgbaMask))
+
+      goldCount, goldX, goldY := RgbaFindMaskedCrop(haystack, width, height,
+          cropBytes, xSize, ySize, rgbaMask,
+          HashForRgbaFindCrop(cropBytes, xSize, ySize), scratch32)
+      hash := HashForRgbaFindMaskedCrop64(cropBytes, xSize, ySize, rgbaMask)
+      count, matchX, matchY := RgbaFindMaskedCrop64(haystack, width, height,
+          cropBytes, xSize, ySize, rgbaMask, hash, scratch64)
+      if count != goldCount || matchX != goldX || matchY != goldY {
+        t.Errorf("Wrong answer on case %v - count %d, matchX %d, matchY %d " +
+            "instead of %d, %d, %d", testCase, count, matchX, matchY,
+            goldCount, goldX, goldY)
+      }
+
+      if rgbaMask != 0xffffffff {
+        continue
+      }
+      count, matchX, matchY = RgbaFindCrop64(haystack, width, height,
+          cropBytes, xSize, ySize, HashForRgbaFindCrop64(cropBytes, xSize,
+          ySize), scratch64)
+      if count != goldCount || matchX != goldX || matchY != goldY {
+        t.Errorf("Wrong unmasked answer on case %v - count %d, matchX %d, " +
+            "matchY %d", testCase, count, matchX, matchY)
+      }
+    }
+  }
+}
+
+func TestRgbaFindCropInRect(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  // Stamp copies of a needle in the image's top and bottom halves.
+  var needle []byte
+  CropRgba(fruits.Pix, width, height, 100, 100, 12, 20, &needle)
+  for _, corner := range []image.Point{{30, 40}, {300, 300}, {400, 480}} {
+    for row := 0; row < 20; row += 1 {
+      copy(fruits.Pix[fruits.PixOffset(corner.X, corner.Y + row):],
+          needle[row * 48:(row + 1) * 48])
+    }
+  }
+
+  cases := []struct {
+    searchRect image.Rectangle
+    count, matchX, matchY int
+  } {
+    { image.Rect(0, 0, width, height), 4, 400, 480 },
+    { image.Rect(0, 256, width, height), 2, 400, 480 },
+    { image.Rect(0, 256, width, 492), 1, 300, 300 },
+    { image.Rect(0, 256, width, 499), 1, 300, 300 },
+    { image.Rect(0, 256, width, 500), 2, 400, 480 },
+    { image.Rect(-50, -50, 200, 200), 2, 100, 100 },
+    { image.Rect(301, 0, 412, 1000), 1, 400, 480 },
+    { image.Rect(301, 0, 411, 1000), 0, 0, 0 },
+    { image.Rect(600, 600, 700, 700), 0, 0, 0 },
+    { image.Rect(10, 10, 10, 10), 0, 0, 0 },
+  }
+
+  hash := HashForRgbaFindCrop(needle, 12, 20)
+  hash64 := HashForRgbaFindCrop64(needle, 12, 20)
+  mask := uint32(0xf0f0f0ff)
+  var maskedNeedle []byte
+  CropRgba(needle, 12, 20, 0, 0, 12, 20, &maskedNeedle)
+  MaskRgba(maskedNeedle, BuildRgbaMask(mask))
+  maskedHash := HashForRgbaFindCrop(maskedNeedle, 12, 20)
+  finder, err := NewFinder(needle, 12, 20)
+  if err != nil {
+    t.Fatal(err)
+  }
+  scratch := make([]byte, width * 8)
+  for _, testCase := range cases {
+    count, matchX, matchY := RgbaFindCropInRect(fruits.Pix, width, height,
+        needle, 12, 20, hash, testCase.searchRect, scratch)
+    if count != testCase.count || matchX != testCase.matchX ||
+        matchY != testCase.matchY {
+      t.Errorf("RgbaFindCropInRect on %v returned count %d, matchX %d, " +
+          "matchY %d", testCase.searchRect, count, matchX, matchY)
+    }
+
+    count, matchX, matchY = RgbaFindCrop64InRect(fruits.Pix, width, height,
+        needle, 12, 20, hash64, testCase.searchRect, scratch)
+    if count != testCase.count || matchX != testCase.matchX ||
+        matchY != testCase.matchY {
+      t.Errorf("RgbaFindCrop64InRect on %v returned count %d, matchX %d, " +
+          "matchY %d", testCase.searchRect, count, matchX, matchY)
+    }
+
+    count, matchX, matchY = finder.FindInRect(fruits.Pix, width, height,
+        testCase.searchRect)
+    if count != testCase.count || matchX != testCase.matchX ||
+        matchY != testCase.matchY {
+      t.Errorf("Finder.FindInRect on %v returned count %d, matchX %d, " +
+          "matchY %d", testCase.searchRect, count, matchX, matchY)
+    }
+
+    // The masked search finds at least the unmasked matches.
+    count, _, _ = RgbaFindMaskedCropInRect(fruits.Pix, width, height,
+        maskedNeedle, 12, 20, mask, maskedHash, testCase.searchRect, scratch)
+    if count < testCase.count {
+      t.Errorf("RgbaFindMaskedCropInRect on %v returned count %d",
+          testCase.searchRect, count)
+    }
+  }
+}
+
+func TestRgbaFindBestCrop(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // A small haystack keeps the brute-force golden search fast.
+  width, height := 96, 64
+  var haystack []byte
+  CropRgba(fruits.Pix, fruits.Bounds().Dx(), fruits.Bounds().Dy(), 200, 150,
+      width, height, &haystack)
+  mask := uint32(0xf0f0f0ff)
+  var needle []byte
+  CropRgba(haystack, width, height, 40, 30, 8, 6, &needle)
+  MaskRgba(needle, BuildRgbaMask(mask))
+
+  // A near-copy of the needle should be the second best match.
+  for row := 0; row < 6; row += 1 {
+    copy(haystack[((5 + row) * width + 10) * 4:],
+        needle[row * 32:(row + 1) * 32])
+  }
+  haystack[(7 * width + 12) * 4] ^= 0x10
+
+  // The golden matches come from a greedy search over all the placements.
+  placeWidth, placeHeight := width - 8 + 1, height - 6 + 1
+  diffs := make([]int64, placeWidth * placeHeight)
+  for top := 0; top < placeHeight; top += 1 {
+    for left := 0; left < placeWidth; left += 1 {
+      diffs[top * placeWidth + left] = RgbaDiffMaskedCrop(haystack, width,
+          height, needle, 8, 6, left, top, mask)
+    }
+  }
+  var goldMatches []CropMatch
+  for {
+    best := -1
+    for i, diff := range diffs {
+      if diff >= 0 && (best < 0 || diff < diffs[best]) {
+        best = i
+      }
+    }
+    if best < 0 {
+      break
+    }
+    match := CropMatch{best % placeWidth, best / placeWidth, diffs[best]}
+    goldMatches = append(goldMatches, match)
+    for i := range diffs {
+      dx, dy := i % placeWidth - match.Left, i / placeWidth - match.Top
+      if dx > -8 && dx < 8 && dy > -6 && dy < 6 {
+        diffs[i] = -1
+      }
+    }
+  }
+
+  if goldMatches[0] != (CropMatch{40, 30, 0}) ||
+      goldMatches[1] != (CropMatch{10, 5, 16}) {
+    t.Fatal("Incorrect golden matches: ", goldMatches[:2])
+  }
+
+  // The strong matches agree with the greedy search. The weak matches can
+  // differ, because the candidate list forgets the placements suppressed by
+  // candidates that are replaced later.
+  for _, k := range []int{1, 2, 10} {
+    matches := make([]CropMatch, k)
+    count := RgbaFindBestCrop(haystack, width, height, needle, 8, 6, mask,
+        matches)
+    if !reflect.DeepEqual(matches[:count], goldMatches[:k]) {
+      t.Errorf("Top-%d matches %v, expected %v", k, matches[:count],
+          goldMatches[:k])
+    }
+  }
+  matches := make([]CropMatch, len(goldMatches) + 5)
+  count := RgbaFindBestCrop(haystack, width, height, needle, 8, 6, mask,
+      matches)
+  if count < 10 || count > len(goldMatches) {
+    t.Error("Incorrect match count: ", count)
+  }
+  for i, match := range matches[:count] {
+    if diff := RgbaDiffMaskedCrop(haystack, width, height, needle, 8, 6,
+        match.Left, match.Top, mask); diff != match.Diff {
+      t.Errorf("Match %v has diff %d\n", match, diff)
+    }
+    if i > 0 && match.Diff < matches[i - 1].Diff {
+      t.Errorf("Match %v is better than match %v\n", match, matches[i - 1])
+    }
+    for _, other := range matches[:i] {
+      if absInt(match.Left - other.Left) < 8 &&
+          absInt(match.Top - other.Top) < 6 {
+        t.Errorf("Match %v overlaps match %v\n", match, other)
+      }
+    }
+  }
+
+  inRectCases := []struct {
+    searchRect image.Rectangle
+    match CropMatch
+  } {
+    { image.Rect(0, 0, width, height), CropMatch{40, 30, 0} },
+    { image.Rect(-10, -10, 20, 15), CropMatch{10, 5, 16} },
+    { image.Rect(30, 20, 60, 50), CropMatch{40, 30, 0} },
+  }
+  for _, testCase := range inRectCases {
+    matches := make([]CropMatch, 1)
+    if count := RgbaFindBestCropInRect(haystack, width, height, needle, 8, 6,
+        mask, testCase.searchRect, matches); count != 1 ||
+        matches[0] != testCase.match {
+      t.Errorf("RgbaFindBestCropInRect on %v returned %v\n",
+          testCase.searchRect, matches[:count])
+    }
+  }
+  if count := RgbaFindBestCropInRect(haystack, width, height, needle, 8, 6,
+      mask, image.Rect(0, 0, 7, 40), make([]CropMatch, 3)); count != 0 {
+    t.Error("Search rectangle narrower than the needle returned matches: ",
+        count)
+  }
+
+  view := NewRgbaView(haystack, width, height)
+  if count := view.FindBestCrop(view, mask, make([]CropMatch, 3));
+      count != 1 {
+    t.Error("Haystack-sized needle returned match count: ", count)
+  }
+  if count := view.FindBestCrop(NewRgbaView(needle, 8, 6), mask, nil);
+      count != 0 {
+    t.Error("Empty matches slice returned match count: ", count)
+  }
+}
@@ -0,0 +1,378 @@
+package imageutil
+
+import (
+  "bufio"
+  "bytes"
+  "fmt"
+  "image"
+  "io"
+  "os"
+  "strconv"
+  "strings"
+)
+
+// The Netpbm formats store uncompressed pixels after a short text header, so
+// they are easy to produce and parse in other tools. PAM files with the
+// RGB_ALPHA tuple type hold exactly the bytes of a raw RGBA buffer. PPM files
+// drop the alpha channel, and PGM files only hold gray values. Only the
+// binary variants of the formats, with 8-bit samples, are supported.
+
+// RgbaToPam encodes a raw RGBA-encoded image into a PAM file.
+// It returns any error encountered.
+func RgbaToPam(rawImage []byte, width int, height int,
+    fileName string) error {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return err
+  }
+  return writeImageFile(fileName, func(w io.Writer) error {
+    return EncodeRgbaPam(w, rawImage, width, height)
+  })
+}
+
+// RgbaToPpm encodes a raw RGBA-encoded image into a PPM file.
+// It returns any error encountered.
+func RgbaToPpm(rawImage []byte, width int, height int,
+    fileName string) error {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return err
+  }
+  return writeImageFile(fileName, func(w io.Writer) error {
+    return EncodeRgbaPpm(w, rawImage, width, height)
+  })
+}
+
+// RgbaToPgm encodes a raw RGBA-encoded image into a PGM file.
+// It returns any error encountered.
+func RgbaToPgm(rawImage []byte, width int, height int,
+    fileName string) error {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return err
+  }
+  return writeImageFile(fileName, func(w io.Writer) error {
+    return EncodeRgbaPgm(w, rawImage, width, height)
+  })
+}
+
+// EncodeRgbaPam encodes a raw RGBA-encoded image as PAM into a writer.
+// The file uses the RGB_ALPHA tuple type, and its pixel data is a verbatim
+// copy of the image's buffer. It returns any error encountered, including an
+// error wrapping ErrInvalidDimensions or ErrBufferTooSmall when the image's
+// dimensions don't match its buffer.
+func EncodeRgbaPam(w io.Writer, rawImage []byte, width int,
+    height int) error {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return err
+  }
+  if _, err := fmt.Fprintf(w, "P7\nWIDTH %d\nHEIGHT %d\nDEPTH 4\n" +
+      "MAXVAL 255\nTUPLTYPE RGB_ALPHA\nENDHDR\n", width, height);
+      err != nil {
+    return err
+  }
+  _, err := w.Write(rawImage[:width * height * 4])
+  return err
+}
+
+// EncodeRgbaPpm encodes a raw RGBA-encoded image as PPM into a writer.
+// The alpha channel is dropped. It returns any error encountered, including
+// an error wrapping ErrInvalidDimensions or ErrBufferTooSmall when the image's
+// dimensions don't match its buffer.
+func EncodeRgbaPpm(w io.Writer, rawImage []byte, width int,
+    height int) error {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return err
+  }
+  if _, err := fmt.Fprintf(w, "P6\n%d %d\n255\n", width, height); err != nil {
+    return err
+  }
+
+  row := make([]byte, width * 3)
+  for y := 0; y < height; y += 1 {
+    rgbaRow := rawImage[y * width * 4:]
+    for x := 0; x < width; x += 1 {
+      copy(row[x * 3:x * 3 + 3], rgbaRow[x * 4:x * 4 + 3])
+    }
+    if _, err := w.Write(row); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// EncodeRgbaPgm encodes a raw RGBA-encoded image as PGM into a writer.
+// The pixels are converted to gray values using the same weights as the
+// keypoint and perceptual hash functions, and the alpha channel is dropped.
+// It returns any error encountered, including an error wrapping
+// ErrInvalidDimensions or ErrBufferTooSmall when the image's dimensions don't
+// match its buffer.
+func EncodeRgbaPgm(w io.Writer, rawImage []byte, width int,
+    height int) error {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return err
+  }
+  if _, err := fmt.Fprintf(w, "P5\n%d %d\n255\n", width, height); err != nil {
+    return err
+  }
+
+  row := make([]byte, width)
+  for y := 0; y < height; y += 1 {
+    rgbaRow := rawImage[y * width * 4:]
+    for x := 0; x < width; x += 1 {
+      row[x] = pixelGray(rgbaRow[x * 4], rgbaRow[x * 4 + 1],
+          rgbaRow[x * 4 + 2])
+    }
+    if _, err := w.Write(row); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+// ReadRgbaNetpbm decodes a PAM, PPM or PGM file into a raw RGBA buffer.
+// It returns the decoded image and any error encountered. See
+// DecodeRgbaNetpbm for details.
+func ReadRgbaNetpbm(fileName string) (*image.RGBA, error) {
+  f, err := os.Open(fileName)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  return DecodeRgbaNetpbm(f)
+}
+
+// DecodeRgbaNetpbm decodes a PAM, PPM or PGM image from a reader into a raw
+// RGBA buffer.
+// The format is identified by the file's magic number. PAM files can have the
+// RGB_ALPHA, RGB, GRAYSCALE_ALPHA or GRAYSCALE tuple types. Gray values are
+// copied into the red, green and blue channels, and images without an alpha
+// channel are opaque. RGB_ALPHA pixel data is copied verbatim, so encoding an
+// image with EncodeRgbaPam and decoding it produces the same bytes. Headers
+// that are malformed or use unsupported features return an error wrapping
+// ErrInvalidHeader, and dimensions that are too large return an error
+// wrapping ErrInvalidDimensions. Truncated pixel data returns
+// io.ErrUnexpectedEOF.
+func DecodeRgbaNetpbm(r io.Reader) (*image.RGBA, error) {
+  reader := bufio.NewReader(r)
+  magic := make([]byte, 2)
+  if _, err := io.ReadFull(reader, magic); err != nil {
+    return nil, err
+  }
+
+  var width, height, depth int
+  var err error
+  switch string(magic) {
+  case "P5", "P6":
+    depth = 1
+    if magic[1] == '6' {
+      depth = 3
+    }
+    width, height, err = readPnmHeader(reader)
+  case "P7":
+    width, height, depth, err = readPamHeader(reader)
+  default:
+    return nil, fmt.Errorf("Unknown Netpbm magic number %q: %w", magic,
+        ErrInvalidHeader)
+  }
+  if err != nil {
+    return nil, err
+  }
+  size, err := rgbaSize("Image", width, height)
+  if err != nil {
+    return nil, err
+  }
+
+  // The samples are read before the RGBA buffer is allocated, so headers
+  // with made-up sizes cannot cause huge allocations.
+  samples, err := readImageData(reader, size / 4 * depth)
+  if err != nil {
+    return nil, err
+  }
+  if depth == 4 {
+    return &image.RGBA{Pix: samples, Stride: width * 4,
+      Rect: image.Rect(0, 0, width, height)}, nil
+  }
+
+  rgbaImage := image.NewRGBA(image.Rect(0, 0, width, height))
+  for i := 0; i < width * height; i += 1 {
+    pixel := rgbaImage.Pix[i * 4:i * 4 + 4]
+    switch depth {
+    case 1:
+      value := samples[i]
+      pixel[0], pixel[1], pixel[2], pixel[3] = value, value, value, 0xff
+    case 2:
+      value := samples[i * 2]
+      pixel[0], pixel[1], pixel[2], pixel[3] = value, value, value,
+          samples[i * 2 + 1]
+    case 3:
+      copy(pixel, samples[i * 3:i * 3 + 3])
+      pixel[3] = 0xff
+    }
+  }
+  return rgbaImage, nil
+}
+
+// readPnmHeader parses the rest of a PPM or PGM header.
+// The magic number must have been consumed already.
+func readPnmHeader(reader *bufio.Reader) (int, int, error) {
+  var values [3]int
+  for i := range values {
+    token, err := readPnmToken(reader)
+    if err != nil {
+      return 0, 0, err
+    }
+    if values[i], err = strconv.Atoi(token); err != nil {
+      return 0, 0, fmt.Errorf("Invalid Netpbm header value %q: %w", token,
+          ErrInvalidHeader)
+    }
+  }
+  if values[2] != 255 {
+    return 0, 0, fmt.Errorf("Unsupported Netpbm maxval %d: %w", values[2],
+        ErrInvalidHeader)
+  }
+  return values[0], values[1], nil
+}
+
+// readPnmToken reads a whitespace-separated token from a PPM or PGM header.
+// Comments are skipped. The single whitespace character after the token is
+// consumed, so the pixel data starts right after the last token.
+func readPnmToken(reader *bufio.Reader) (string, error) {
+  var token []byte
+  for {
+    b, err := reader.ReadByte()
+    if err != nil {
+      if err == io.EOF {
+        err = io.ErrUnexpectedEOF
+      }
+      return "", err
+    }
+    switch {
+    case b == '#' && len(token) == 0:
+      if _, err := readHeaderLine(reader); err != nil {
+        return "", err
+      }
+    case b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' ||
+        b == '\f':
+      if len(token) > 0 {
+        return string(token), nil
+      }
+    default:
+      if len(token) == maxHeaderLine {
+        return "", fmt.Errorf("Netpbm header token longer than %d bytes: %w",
+            maxHeaderLine, ErrInvalidHeader)
+      }
+      token = append(token, b)
+    }
+  }
+}
+
+// maxHeaderLine is the length of the longest Netpbm header line or token that
+// is accepted.
+const maxHeaderLine = 1024
+
+// readHeaderLine reads a line from a Netpbm header.
+// Lines longer than maxHeaderLine return an error wrapping ErrInvalidHeader,
+// so a header without newlines is not buffered without bound.
+func readHeaderLine(reader *bufio.Reader) (string, error) {
+  line, err := reader.ReadSlice('\n')
+  if err == bufio.ErrBufferFull || len(line) > maxHeaderLine {
+    return "", fmt.Errorf("Netpbm header line longer than %d bytes: %w",
+        maxHeaderLine, ErrInvalidHeader)
+  }
+  if err != nil {
+    if err == io.EOF {
+      err = io.ErrUnexpectedEOF
+    }
+    return "", err
+  }
+  return string(line), nil
+}
+
+// pamTupleDepths maps the supported PAM tuple types to their depths.
+var pamTupleDepths = map[string]int{
+  "GRAYSCALE": 1,
+  "GRAYSCALE_ALPHA": 2,
+  "RGB": 3,
+  "RGB_ALPHA": 4,
+}
+
+// readPamHeader parses the rest of a PAM header.
+// The magic number must have been consumed already.
+func readPamHeader(reader *bufio.Reader) (int, int, int, error) {
+  fields := map[string]string{}
+  for {
+    line, err := readHeaderLine(reader)
+    if err != nil {
+      return 0, 0, 0, err
+    }
+    line = strings.TrimSpace(line)
+    if line == "ENDHDR" {
+      break
+    }
+    if line == "" || line[0] == '#' {
+      continue
+    }
+    // Only the fields used below are kept, so headers with many lines don't
+    // use up memory.
+    key, value, _ := strings.Cut(line, " ")
+    switch key {
+    case "WIDTH", "HEIGHT", "DEPTH", "MAXVAL", "TUPLTYPE":
+      fields[key] = strings.TrimSpace(value)
+    }
+  }
+
+  var values [4]int
+  for i, key := range []string{"WIDTH", "HEIGHT", "DEPTH", "MAXVAL"} {
+    value, err := strconv.Atoi(fields[key])
+    if err != nil {
+      return 0, 0, 0, fmt.Errorf("Invalid PAM %s %q: %w", key, fields[key],
+          ErrInvalidHeader)
+    }
+    values[i] = value
+  }
+  if values[3] != 255 {
+    return 0, 0, 0, fmt.Errorf("Unsupported PAM maxval %d: %w", values[3],
+        ErrInvalidHeader)
+  }
+  if depth, ok := pamTupleDepths[fields["TUPLTYPE"]]; !ok ||
+      depth != values[2] {
+    return 0, 0, 0, fmt.Errorf("Unsupported PAM tuple type %q of depth %d: %w",
+        fields["TUPLTYPE"], values[2], ErrInvalidHeader)
+  }
+  return values[0], values[1], values[2], nil
+}
+
+// readImageData reads an image's pixel data.
+// The buffer grows as the data arrives, instead of being allocated based on
+// the size in the image's header, so a truncated or hostile file fails with
+// io.ErrUnexpectedEOF without allocating much more memory than its size.
+func readImageData(r io.Reader, size int) ([]byte, error) {
+  var buffer bytes.Buffer
+  read, err := buffer.ReadFrom(io.LimitReader(r, int64(size)))
+  if err != nil {
+    return nil, err
+  }
+  if read < int64(size) {
+    return nil, io.ErrUnexpectedEOF
+  }
+  return buffer.Bytes(), nil
+}
+
+// writeImageFile creates a file and writes an image into it.
+// It returns any error encountered, including errors closing the file.
+func writeImageFile(fileName string, encode func(w io.Writer) error) error {
+  f, err := os.Create(fileName)
+  if err != nil {
+    return err
+  }
+
+  writer := bufio.NewWriter(f)
+  if err := encode(writer); err != nil {
+    f.Close()
+    return err
+  }
+  if err := writer.Flush(); err != nil {
+    f.Close()
+    return err
+  }
+  return f.Close()
+}
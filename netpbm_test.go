@@ -0,0 +1,189 @@
+package imageutil
+
+import (
+  "bytes"
+  "errors"
+  "io"
+  "os"
+  "strings"
+  "testing"
+)
+
+func TestRgbaToPam(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  // PAM files store the buffer verbatim, including non-opaque alpha.
+  rawImage := make([]byte, len(fruits.Pix))
+  copy(rawImage, fruits.Pix)
+  for i := 3; i < len(rawImage); i += 4 {
+    rawImage[i] = byte(i / 7)
+  }
+  if err := RgbaToPam(rawImage, width, height,
+      "test_tmp/fruits.pam"); err != nil {
+    t.Fatal(err)
+  }
+  decoded, err := ReadRgbaNetpbm("test_tmp/fruits.pam")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if decoded.Rect != fruits.Rect || !bytes.Equal(decoded.Pix, rawImage) {
+    t.Error("PAM round trip mismatch")
+  }
+
+  var buffer bytes.Buffer
+  if err := EncodeRgbaPam(&buffer, rawImage, 2, 1); err != nil {
+    t.Fatal(err)
+  }
+  goldHeader := "P7\nWIDTH 2\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\n" +
+      "TUPLTYPE RGB_ALPHA\nENDHDR\n"
+  if buffer.String() != goldHeader + string(rawImage[:8]) {
+    t.Errorf("Incorrect PAM encoding: %q\n", buffer.String())
+  }
+  if err := EncodeRgbaPam(&buffer, rawImage, width, height + 1);
+      !errors.Is(err, ErrBufferTooSmall) {
+    t.Error("Small buffer did not return ErrBufferTooSmall: ", err)
+  }
+  // Invalid images don't leave empty files behind.
+  if err := RgbaToPam(rawImage, width, height + 1,
+      "test_tmp/fruits_invalid.pam"); !errors.Is(err, ErrBufferTooSmall) {
+    t.Error("Small buffer did not return ErrBufferTooSmall: ", err)
+  }
+  if _, err := os.Stat("test_tmp/fruits_invalid.pam"); !os.IsNotExist(err) {
+    t.Error("Invalid image created a file: ", err)
+  }
+}
+
+func TestRgbaToPpmPgm(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  if err := RgbaToPpm(fruits.Pix, width, height,
+      "test_tmp/fruits.ppm"); err != nil {
+    t.Fatal(err)
+  }
+  decoded, err := ReadRgbaNetpbm("test_tmp/fruits.ppm")
+  if err != nil {
+    t.Fatal(err)
+  }
+  // The fruits image is opaque, so PPM files keep all its data.
+  if !bytes.Equal(decoded.Pix, fruits.Pix) {
+    t.Error("PPM round trip mismatch")
+  }
+
+  if err := RgbaToPgm(fruits.Pix, width, height,
+      "test_tmp/fruits.pgm"); err != nil {
+    t.Fatal(err)
+  }
+  decoded, err = ReadRgbaNetpbm("test_tmp/fruits.pgm")
+  if err != nil {
+    t.Fatal(err)
+  }
+  gray := NewRgbaView(fruits.Pix, width, height).gray()
+  for i, value := range gray {
+    pixel := decoded.Pix[i * 4:i * 4 + 4]
+    if pixel[0] != value || pixel[1] != value || pixel[2] != value ||
+        pixel[3] != 0xff {
+      t.Fatalf("PGM pixel %d is %v instead of gray %d\n", i, pixel, value)
+    }
+  }
+}
+
+func TestDecodeRgbaNetpbm(t *testing.T) {
+  pixels := "\x10\x20\x30\x40\x50\x60\x70\x80\x90\xa0\xb0\xc0"
+  cases := []struct {
+    data string
+    rgba string
+  } {
+    { "P5 4 1 255\n" + pixels[:4],
+      "\x10\x10\x10\xff\x20\x20\x20\xff\x30\x30\x30\xff\x40\x40\x40\xff" },
+    { "P6\n# comment\n2 # more\n2\n255\t" + pixels,
+      "\x10\x20\x30\xff\x40\x50\x60\xff\x70\x80\x90\xff\xa0\xb0\xc0\xff" },
+    { "P7\nWIDTH 2\nHEIGHT 1\n# comment\nDEPTH 2\nMAXVAL 255\n" +
+      "TUPLTYPE GRAYSCALE_ALPHA\nENDHDR\n" + pixels[:4],
+      "\x10\x10\x10\x20\x30\x30\x30\x40" },
+    { "P7\nHEIGHT 1\nWIDTH 4\nDEPTH 3\nMAXVAL 255\nTUPLTYPE RGB\nENDHDR\n" +
+      pixels,
+      "\x10\x20\x30\xff\x40\x50\x60\xff\x70\x80\x90\xff\xa0\xb0\xc0\xff" },
+    { "P7\nWIDTH 0\nHEIGHT 0\nDEPTH 1\nMAXVAL 255\nTUPLTYPE GRAYSCALE\n" +
+      "ENDHDR\n", "" },
+  }
+  for _, testCase := range cases {
+    decoded, err := DecodeRgbaNetpbm(strings.NewReader(testCase.data))
+    if err != nil {
+      t.Errorf("Decoding %q failed: %v\n", testCase.data, err)
+      continue
+    }
+    if string(decoded.Pix) != testCase.rgba {
+      t.Errorf("Decoding %q returned %q instead of %q\n", testCase.data,
+          decoded.Pix, testCase.rgba)
+    }
+  }
+
+  invalidHeaders := []string{
+    "P3 1 1 255\n",
+    "P6 1 1 65535\n",
+    "P6 1 x 255\n",
+    "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 3\nMAXVAL 255\nTUPLTYPE RGB_ALPHA\n" +
+        "ENDHDR\n",
+    "P7\nWIDTH 1\nHEIGHT 1\nDEPTH 4\nMAXVAL 255\nENDHDR\n",
+    "P6 -1 1 255\n",
+  }
+  for _, data := range invalidHeaders {
+    if _, err := DecodeRgbaNetpbm(strings.NewReader(data)); !errors.Is(err,
+        ErrInvalidHeader) && !errors.Is(err, ErrInvalidDimensions) {
+      t.Errorf("Invalid header %q returned %v\n", data, err)
+    }
+  }
+
+  // Header lines and tokens are not buffered without bound.
+  longLine := strings.Repeat("A", 1 << 20)
+  longHeaders := []string{
+    "P7\n" + longLine,
+    "P7\n# " + longLine + "\nENDHDR\n",
+    "P6 " + strings.Repeat("1", 1 << 20) + " 1 255\n",
+    "P6 # " + longLine + "\n1 1 255\n",
+  }
+  for _, data := range longHeaders {
+    if _, err := DecodeRgbaNetpbm(strings.NewReader(data)); !errors.Is(err,
+        ErrInvalidHeader) {
+      t.Errorf("Long header %q returned %v\n", data[:10], err)
+    }
+  }
+
+  // Headers with sizes that are valid but don't match the data are not
+  // trusted with allocations: 2^31 x (2^30 - 1) and 60000 x 60000.
+  hugeHeaders := []string{
+    "P6 2147483648 1073741823 255\n" + pixels,
+    "P5 60000 60000 255\n" + pixels,
+    "P7\nWIDTH 2147483648\nHEIGHT 1073741823\nDEPTH 4\nMAXVAL 255\n" +
+        "TUPLTYPE RGB_ALPHA\nENDHDR\n" + pixels,
+  }
+  for _, data := range hugeHeaders {
+    if _, err := DecodeRgbaNetpbm(strings.NewReader(data)); err !=
+        io.ErrUnexpectedEOF {
+      t.Errorf("Huge header %q returned %v instead of io.ErrUnexpectedEOF\n",
+          data[:20], err)
+    }
+  }
+  // Images whose byte size does not fit in an int are rejected.
+  if _, err := DecodeRgbaNetpbm(strings.NewReader(
+      "P6 4611686018427387904 4 255\n")); !errors.Is(err,
+      ErrInvalidDimensions) {
+    t.Error("Overflowing size did not return ErrInvalidDimensions: ", err)
+  }
+
+  truncated := []string{"P6 2 2", "P6 2 2 255\n" + pixels[:5],
+      "P7\nWIDTH 1\n", "P5 10 1 255\n"}
+  for _, data := range truncated {
+    if _, err := DecodeRgbaNetpbm(strings.NewReader(data)); err == nil {
+      t.Errorf("Truncated data %q did not return an error\n", data)
+    }
+  }
+}
@@ -1,12 +1,5 @@
 package imageutil
 
-// #include "c/objects.c"
-import "C"  // cgo
-
-import (
-  "unsafe"
-)
-
 // RgbaFindPillars returns the tallest vertical strips in an image.
 func RgbaFindPillars(rgbaImage []byte, width int, height int,
     minRed int, maxRed int, minGreen int, maxGreen int, minBlue int,
@@ -14,11 +7,29 @@ func RgbaFindPillars(rgbaImage []byte, width int, height int,
   if cap(rgbaImage) < 4 * width * height {
     panic("RGBA image capacity inconsistent with width / height")
   }
-  C.GoRgbaFindPillars(unsafe.Pointer(&rgbaImage[0]),
-      unsafe.Pointer(&pillars[0][0]), C.int(width), C.int(height),
-      C.int(len(pillars)), C.uint8_t(minRed), C.uint8_t(minGreen),
-      C.uint8_t(minBlue), C.uint8_t(maxRed), C.uint8_t(maxGreen),
-      C.uint8_t(maxBlue))
+  view := RgbaView{Pix: rgbaImage[:4 * width * height], Width: width,
+      Height: height, Stride: 4 * width}
+  view.FindPillars(minRed, maxRed, minGreen, maxGreen, minBlue, maxBlue,
+      pillars)
+}
+
+// FindPillars returns the tallest vertical strips in a view.
+// This is the view equivalent of RgbaFindPillars. The pillars' coordinates are
+// relative to the view's top-left corner.
+func (v RgbaView) FindPillars(minRed int, maxRed int, minGreen int,
+    maxGreen int, minBlue int, maxBlue int, pillars [][4]int32) {
+  v.check("View")
+  if len(pillars) == 0 {
+    return
+  }
+  if v.Empty() {
+    for i := range pillars {
+      pillars[i] = [4]int32{}
+    }
+    return
+  }
+  rgbaFindPillars(v, pillars, uint8(minRed), uint8(minGreen), uint8(minBlue),
+      uint8(maxRed), uint8(maxGreen), uint8(maxBlue))
 }
 
 // RgbaFindPuddle locates contiguous areas in an image.
@@ -30,16 +41,123 @@ func RgbaFindPuddle(rgbaImage []byte, width int, height int,
   if cap(rgbaImage) < 4 * width * height {
     panic("RGBA image capacity inconsistent with width / height")
   }
-  result := C.GoRgbaFindPuddle(unsafe.Pointer(&rgbaImage[0]),
-      unsafe.Pointer(&puddlePixels[0][0]), C.int(width), C.int(height),
-      C.int(startY), C.int(len(puddlePixels)), C.uint8_t(minRed),
-      C.uint8_t(minGreen), C.uint8_t(minBlue), C.uint8_t(maxRed),
-      C.uint8_t(maxGreen), C.uint8_t(maxBlue))
-  return int(result)
+  view := RgbaView{Pix: rgbaImage[:4 * width * height], Width: width,
+      Height: height, Stride: 4 * width}
+  return view.FindPuddle(minRed, maxRed, minGreen, maxGreen, minBlue, maxBlue,
+      startY, puddlePixels)
+}
+
+// FindPuddle locates contiguous areas in a view.
+// This is the view equivalent of RgbaFindPuddle. Puddles do not extend beyond
+// the view's edges, and the puddle pixels' coordinates are relative to the
+// view's top-left corner.
+func (v RgbaView) FindPuddle(minRed int, maxRed int, minGreen int,
+    maxGreen int, minBlue int, maxBlue int, startY int,
+    puddlePixels [][2]int32) int {
+  v.check("View")
+  if startY < 0 {
+    startY = 0
+  }
+  if v.Empty() || startY >= v.Height || len(puddlePixels) == 0 {
+    return 0
+  }
+  return rgbaFindPuddle(v, startY, puddlePixels, uint8(minRed),
+      uint8(minGreen), uint8(minBlue), uint8(maxRed), uint8(maxGreen),
+      uint8(maxBlue))
+}
+
+// RgbaFindPuddleParallel locates contiguous areas in an image using many
+// goroutines.
+// This is the parallel equivalent of RgbaFindPuddle, and it returns the same
+// results. The search for the puddle's first pixel is split across goroutines,
+// and the puddle itself is filled by a single goroutine. The number of
+// goroutines is set by SetConcurrency.
+func RgbaFindPuddleParallel(rgbaImage []byte, width int, height int,
+    minRed int, maxRed int, minGreen int, maxGreen int, minBlue int,
+    maxBlue int, startY int, puddlePixels [][2]int32) int {
+  if cap(rgbaImage) < 4 * width * height {
+    panic("RGBA image capacity inconsistent with width / height")
+  }
+  view := RgbaView{Pix: rgbaImage[:4 * width * height], Width: width,
+      Height: height, Stride: 4 * width}
+  return view.FindPuddleParallel(minRed, maxRed, minGreen, maxGreen, minBlue,
+      maxBlue, startY, puddlePixels)
+}
+
+// FindPuddleParallel locates contiguous areas in a view using many goroutines.
+// This is the view equivalent of RgbaFindPuddleParallel.
+func (v RgbaView) FindPuddleParallel(minRed int, maxRed int, minGreen int,
+    maxGreen int, minBlue int, maxBlue int, startY int,
+    puddlePixels [][2]int32) int {
+  v.check("View")
+  if startY < 0 {
+    startY = 0
+  }
+  if v.Empty() || startY >= v.Height || len(puddlePixels) == 0 {
+    return 0
+  }
+  rows := v.Height - startY
+
+  // The puddle starts in the first row where any band found a starting pixel.
+  bands := splitBands(rows, parallelMinRows)
+  startRows := make([]int, bands)
+  runBands(rows, bands, func(band int, start int, end int) {
+    startRows[band] = rgbaFindPuddleRow(v, startY + start, startY + end,
+        uint8(minRed), uint8(minGreen), uint8(minBlue), uint8(maxRed),
+        uint8(maxGreen), uint8(maxBlue))
+  })
+  for _, startRow := range startRows {
+    if startRow >= 0 {
+      return v.FindPuddle(minRed, maxRed, minGreen, maxGreen, minBlue,
+          maxBlue, startRow, puddlePixels)
+    }
+  }
+  return 0
 }
 
 // RgbaResetPuddles resets the Alpha channel of all pixles to 255.
 // This is useful after running puddle searches over an image.
 func RgbaResetPuddles(rgbaImage []byte) {
-  C.GoRgbaResetPuddles(unsafe.Pointer(&rgbaImage[0]), C.int(len(rgbaImage)))
+  if len(rgbaImage) == 0 {
+    return
+  }
+  rgbaResetPuddles(rgbaImage)
+}
+
+// ResetPuddles resets the Alpha channel of all the pixels in a view to 255.
+// This is the view equivalent of RgbaResetPuddles.
+func (v RgbaView) ResetPuddles() {
+  v.check("View")
+  v.rows(func(row []byte) {
+    RgbaResetPuddles(row)
+  })
+}
+
+// RgbaFindPillarsChecked is the error-returning equivalent of RgbaFindPillars.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// instead of panicing when the image's dimensions don't match its buffer.
+func RgbaFindPillarsChecked(rgbaImage []byte, width int, height int,
+    minRed int, maxRed int, minGreen int, maxGreen int, minBlue int,
+    maxBlue int, pillars [][4]int32) error {
+  err := validateRgba("Image", rgbaImage[:cap(rgbaImage)], width, height)
+  if err != nil {
+    return err
+  }
+  RgbaFindPillars(rgbaImage, width, height, minRed, maxRed, minGreen,
+      maxGreen, minBlue, maxBlue, pillars)
+  return nil
+}
+
+// RgbaFindPuddleChecked is the error-returning equivalent of RgbaFindPuddle.
+// It returns an error wrapping ErrInvalidDimensions or ErrBufferTooSmall
+// instead of panicing when the image's dimensions don't match its buffer.
+func RgbaFindPuddleChecked(rgbaImage []byte, width int, height int,
+    minRed int, maxRed int, minGreen int, maxGreen int, minBlue int,
+    maxBlue int, startY int, puddlePixels [][2]int32) (int, error) {
+  err := validateRgba("Image", rgbaImage[:cap(rgbaImage)], width, height)
+  if err != nil {
+    return 0, err
+  }
+  return RgbaFindPuddle(rgbaImage, width, height, minRed, maxRed, minGreen,
+      maxGreen, minBlue, maxBlue, startY, puddlePixels), nil
 }
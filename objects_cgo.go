@@ -0,0 +1,52 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/objects.c"
+import "C"  // cgo
+
+import (
+  "unsafe"
+)
+
+// rgbaFindPillars finds the tallest vertical strips in a checked view.
+func rgbaFindPillars(v RgbaView, pillars [][4]int32, minRed uint8,
+    minGreen uint8, minBlue uint8, maxRed uint8, maxGreen uint8,
+    maxBlue uint8) {
+  C.GoRgbaFindPillars(unsafe.Pointer(&v.Pix[0]),
+      unsafe.Pointer(&pillars[0][0]), C.int(v.Width), C.int(v.Height),
+      C.int(v.Stride / 4), C.int(len(pillars)), C.uint8_t(minRed),
+      C.uint8_t(minGreen), C.uint8_t(minBlue), C.uint8_t(maxRed),
+      C.uint8_t(maxGreen), C.uint8_t(maxBlue))
+}
+
+// rgbaFindPuddle finds a contiguous area in a checked view.
+// It returns the size of the area that it found.
+func rgbaFindPuddle(v RgbaView, startY int, puddlePixels [][2]int32,
+    minRed uint8, minGreen uint8, minBlue uint8, maxRed uint8, maxGreen uint8,
+    maxBlue uint8) int {
+  result := C.GoRgbaFindPuddle(unsafe.Pointer(&v.Pix[0]),
+      unsafe.Pointer(&puddlePixels[0][0]), C.int(v.Width), C.int(v.Height),
+      C.int(v.Stride / 4), C.int(startY), C.int(len(puddlePixels)),
+      C.uint8_t(minRed), C.uint8_t(minGreen), C.uint8_t(minBlue),
+      C.uint8_t(maxRed), C.uint8_t(maxGreen), C.uint8_t(maxBlue))
+  return int(result)
+}
+
+// rgbaFindPuddleRow finds the first row where a puddle search would start.
+// Only rows between startY (inclusive) and endY (exclusive) are searched. It
+// returns -1 if none of the rows would start a puddle.
+func rgbaFindPuddleRow(v RgbaView, startY int, endY int, minRed uint8,
+    minGreen uint8, minBlue uint8, maxRed uint8, maxGreen uint8,
+    maxBlue uint8) int {
+  result := C.GoRgbaFindPuddleRow(unsafe.Pointer(&v.Pix[0]), C.int(v.Width),
+      C.int(v.Stride / 4), C.int(startY), C.int(endY), C.uint8_t(minRed),
+      C.uint8_t(minGreen), C.uint8_t(minBlue), C.uint8_t(maxRed),
+      C.uint8_t(maxGreen), C.uint8_t(maxBlue))
+  return int(result)
+}
+
+// rgbaResetPuddles sets the Alpha channel of all the pixels in a slice to 255.
+func rgbaResetPuddles(rgbaBytes []byte) {
+  C.GoRgbaResetPuddles(unsafe.Pointer(&rgbaBytes[0]), C.int(len(rgbaBytes)))
+}
@@ -0,0 +1,131 @@
+//go:build !cgo
+
+package imageutil
+
+// The functions below are the pure Go versions of the functions in
+// c/objects.c. They must produce the same results, down to the order in which
+// pillars and puddle pixels are reported.
+
+// rgbaFindPillars finds the tallest vertical strips in a checked view.
+func rgbaFindPillars(v RgbaView, pillars [][4]int32, minRed uint8,
+    minGreen uint8, minBlue uint8, maxRed uint8, maxGreen uint8,
+    maxBlue uint8) {
+  for i := range pillars {
+    pillars[i] = [4]int32{}
+  }
+  minPillar := &pillars[0]
+  minHeight := int32(0)
+
+  for x := 0; x < v.Width; x += 1 {
+    pillarHeight := int32(0)
+    for y := 0; y < v.Height; y += 1 {
+      offset := v.PixOffset(x, y)
+      r, g, b := v.Pix[offset], v.Pix[offset + 1], v.Pix[offset + 2]
+      if r >= minRed && g >= minGreen && b >= minBlue &&
+          r <= maxRed && g <= maxGreen && b <= maxBlue {
+        pillarHeight += 1
+        continue
+      }
+
+      if pillarHeight > minHeight {
+        *minPillar = [4]int32{pillarHeight, int32(x), int32(y) - pillarHeight,
+            int32(y) - 1}
+        minHeight = pillarHeight
+        for i := range pillars {
+          if pillars[i][0] < minHeight {
+            minHeight = pillars[i][0]
+            minPillar = &pillars[i]
+          }
+        }
+      }
+      pillarHeight = 0
+    }
+  }
+}
+
+// rgbaFindPuddle finds a contiguous area in a checked view.
+// It returns the size of the area that it found.
+func rgbaFindPuddle(v RgbaView, startY int, puddlePixels [][2]int32,
+    minRed uint8, minGreen uint8, minBlue uint8, maxRed uint8, maxGreen uint8,
+    maxBlue uint8) int {
+  // visit marks a pixel as part of the puddle, if it belongs to one.
+  visit := func(x int, y int) bool {
+    offset := v.PixOffset(x, y)
+    r, g, b := v.Pix[offset], v.Pix[offset + 1], v.Pix[offset + 2]
+    if r < minRed || g < minGreen || b < minBlue ||
+        r > maxRed || g > maxGreen || b > maxBlue {
+      return false
+    }
+    if v.Pix[offset + 3] == 0 {
+      return false
+    }
+    v.Pix[offset + 3] = 0
+    return true
+  }
+
+  for y0 := startY; y0 < v.Height; y0 += 1 {
+    for x0 := 0; x0 < v.Width; x0 += 1 {
+      if !visit(x0, y0) {
+        continue
+      }
+
+      // Found a puddle.
+      puddlePixels[0] = [2]int32{int32(x0), int32(y0)}
+      puddleSize := 1
+      if puddleSize == len(puddlePixels) {
+        return puddleSize
+      }
+      for puddleIn := 0; puddleIn < puddleSize; puddleIn += 1 {
+        px, py := int(puddlePixels[puddleIn][0]), int(puddlePixels[puddleIn][1])
+        for dx := -1; dx <= 1; dx += 1 {
+          for dy := -1; dy <= 1; dy += 1 {
+            x, y := px + dx, py + dy
+            if x < 0 || x >= v.Width || y < 0 || y >= v.Height {
+              continue
+            }
+            if !visit(x, y) {
+              continue
+            }
+
+            puddlePixels[puddleSize] = [2]int32{int32(x), int32(y)}
+            puddleSize += 1
+            if puddleSize == len(puddlePixels) {
+              return puddleSize
+            }
+          }
+        }
+      }
+      return puddleSize
+    }
+  }
+  return 0  // Did not find a puddle.
+}
+
+// rgbaFindPuddleRow finds the first row where a puddle search would start.
+// Only rows between startY (inclusive) and endY (exclusive) are searched. It
+// returns -1 if none of the rows would start a puddle.
+func rgbaFindPuddleRow(v RgbaView, startY int, endY int, minRed uint8,
+    minGreen uint8, minBlue uint8, maxRed uint8, maxGreen uint8,
+    maxBlue uint8) int {
+  for y := startY; y < endY; y += 1 {
+    for x := 0; x < v.Width; x += 1 {
+      offset := v.PixOffset(x, y)
+      r, g, b := v.Pix[offset], v.Pix[offset + 1], v.Pix[offset + 2]
+      if r < minRed || g < minGreen || b < minBlue ||
+          r > maxRed || g > maxGreen || b > maxBlue {
+        continue
+      }
+      if v.Pix[offset + 3] != 0 {
+        return y
+      }
+    }
+  }
+  return -1
+}
+
+// rgbaResetPuddles sets the Alpha channel of all the pixels in a slice to 255.
+func rgbaResetPuddles(rgbaBytes []byte) {
+  for i := 3; i < len(rgbaBytes); i += 4 {
+    rgbaBytes[i] = 255
+  }
+}
@@ -136,3 +136,58 @@ func TestRgbaFindPuddles(t *testing.T) {
     t.Error("Puddle 2 pixel data hash mismatch. Got :", hexHash)
   }
 }
+
+func TestRgbaFindPuddleParallel(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  // findAll finds all the puddles in the image, and returns their pixels.
+  findAll := func(findFunc func([]byte, [][2]int32) int) [][2]int32 {
+    pixels := make([]byte, len(image.Pix))
+    copy(pixels, image.Pix)
+    puddlePixels := make([][2]int32, width * height)
+    var allPixels [][2]int32
+    for {
+      size := findFunc(pixels, puddlePixels)
+      if size == 0 {
+        return allPixels
+      }
+      allPixels = append(allPixels, puddlePixels[:size]...)
+    }
+  }
+
+  goldPixels := findAll(func(pixels []byte, puddle [][2]int32) int {
+    return RgbaFindPuddle(pixels, width, height, 230, 255, 150, 220, 0, 120,
+        0, puddle)
+  })
+  if len(goldPixels) == 0 {
+    t.Fatal("No puddles found")
+  }
+
+  previous := SetConcurrency(0)
+  defer SetConcurrency(previous)
+  for _, workers := range []int{1, 2, 3, 8} {
+    SetConcurrency(workers)
+    puddlePixels := findAll(func(pixels []byte, puddle [][2]int32) int {
+      return RgbaFindPuddleParallel(pixels, width, height, 230, 255, 150, 220,
+          0, 120, 0, puddle)
+    })
+    if !reflect.DeepEqual(puddlePixels, goldPixels) {
+      t.Errorf("Puddle pixels mismatch with %d workers\n", workers)
+    }
+
+    // Empty images have no puddles.
+    puddle := make([][2]int32, 8)
+    if size := RgbaFindPuddleParallel(nil, 0, 5, 0, 255, 0, 255, 0, 255, 0,
+        puddle); size != 0 {
+      t.Errorf("Zero-width image has a %d-pixel puddle\n", size)
+    }
+    if size := RgbaFindPuddleParallel(nil, 5, 0, 0, 255, 0, 255, 0, 255, 0,
+        puddle); size != 0 {
+      t.Errorf("Zero-height image has a %d-pixel puddle\n", size)
+    }
+  }
+}
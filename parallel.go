@@ -0,0 +1,74 @@
+package imageutil
+
+import (
+  "runtime"
+  "sync"
+  "sync/atomic"
+)
+
+// concurrency is the number of goroutines used by the Parallel functions.
+// Zero means that runtime.GOMAXPROCS decides.
+var concurrency atomic.Int32
+
+// The smallest amount of work worth handing to a separate goroutine.
+const (
+  // parallelMinPixels is the smallest band used by per-pixel filters.
+  parallelMinPixels = 1 << 14
+  // parallelMinRows is the smallest band used by image searches.
+  parallelMinRows = 16
+)
+
+// SetConcurrency sets the number of goroutines used by the Parallel functions.
+// Zero or negative values restore the default, which is the value returned by
+// runtime.GOMAXPROCS. The results of the Parallel functions do not depend on
+// this setting. It returns the previous setting.
+func SetConcurrency(workers int) int {
+  if workers < 0 {
+    workers = 0
+  }
+  return int(concurrency.Swap(int32(workers)))
+}
+
+// Concurrency returns the number of goroutines used by the Parallel functions.
+func Concurrency() int {
+  if workers := int(concurrency.Load()); workers > 0 {
+    return workers
+  }
+  return runtime.GOMAXPROCS(0)
+}
+
+// splitBands returns the number of bands that a range should be split into.
+// Each band is at least minBandSize long, unless the whole range is shorter.
+func splitBands(count int, minBandSize int) int {
+  bands := Concurrency()
+  if maxBands := count / minBandSize; bands > maxBands {
+    bands = maxBands
+  }
+  if bands < 1 {
+    bands = 1
+  }
+  return bands
+}
+
+// runBands splits [0, count) into contiguous bands and processes them.
+// Each band is processed by a call to bandFunc, in its own goroutine. Bands
+// are numbered in increasing order of their start, so results can be combined
+// deterministically. It returns after all the bands have been processed.
+func runBands(count int, bands int, bandFunc func(band int, start int,
+    end int)) {
+  if bands == 1 {
+    bandFunc(0, 0, count)
+    return
+  }
+
+  var wait sync.WaitGroup
+  wait.Add(bands)
+  for band := 0; band < bands; band += 1 {
+    start, end := count * band / bands, count * (band + 1) / bands
+    go func(band int, start int, end int) {
+      defer wait.Done()
+      bandFunc(band, start, end)
+    }(band, start, end)
+  }
+  wait.Wait()
+}
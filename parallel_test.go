@@ -0,0 +1,56 @@
+package imageutil
+
+import (
+  "runtime"
+  "testing"
+)
+
+func TestSetConcurrency(t *testing.T) {
+  previous := SetConcurrency(3)
+  defer SetConcurrency(previous)
+
+  if workers := Concurrency(); workers != 3 {
+    t.Error("Concurrency did not return the set value: ", workers)
+  }
+  if old := SetConcurrency(-1); old != 3 {
+    t.Error("SetConcurrency did not return the previous value: ", old)
+  }
+  if workers := Concurrency(); workers != runtime.GOMAXPROCS(0) {
+    t.Error("Default concurrency does not match GOMAXPROCS: ", workers)
+  }
+}
+
+func TestRunBands(t *testing.T) {
+  previous := SetConcurrency(0)
+  defer SetConcurrency(previous)
+
+  for workers := 1; workers <= 8; workers += 1 {
+    SetConcurrency(workers)
+    for _, count := range []int{0, 1, 7, 16, 100, 1000} {
+      bands := splitBands(count, 16)
+      if bands < 1 || bands > workers || (bands > 1 && count / bands < 16) {
+        t.Errorf("Bad band count %d for %d items and %d workers\n", bands,
+            count, workers)
+      }
+
+      covered := make([]int, count)
+      bandStarts := make([]int, bands)
+      runBands(count, bands, func(band int, start int, end int) {
+        bandStarts[band] = start
+        for i := start; i < end; i += 1 {
+          covered[i] += 1
+        }
+      })
+      for i, coverCount := range covered {
+        if coverCount != 1 {
+          t.Fatalf("Item %d of %d covered %d times\n", i, count, coverCount)
+        }
+      }
+      for band := 1; band < bands; band += 1 {
+        if bandStarts[band] <= bandStarts[band - 1] {
+          t.Errorf("Bands %d and %d are out of order\n", band - 1, band)
+        }
+      }
+    }
+  }
+}
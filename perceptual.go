@@ -0,0 +1,326 @@
+package imageutil
+
+import (
+  "math"
+  "math/bits"
+  "sort"
+)
+
+// The perceptual hashes summarize an image's appearance in 64 bits. Unlike
+// HashForRgbaFindCrop, small changes to the image, such as re-encoding,
+// resizing or slight color shifts, only flip a few of the hash's bits, so
+// near-duplicate images have hashes with small Hamming distances. The hashes
+// are computed over the image's gray values. Their bits are stored in
+// row-major order, starting with the most significant bit.
+
+// PerceptualHash is a 64-bit perceptual image hash.
+type PerceptualHash uint64
+
+// Distance returns the Hamming distance between two perceptual hashes.
+func (h PerceptualHash) Distance(other PerceptualHash) int {
+  return bits.OnesCount64(uint64(h ^ other))
+}
+
+// RgbaAverageHash computes an RGBA image's average hash (aHash).
+// The image is shrunk to 8x8 pixels, and each bit is set if a pixel is
+// brighter than the average of all 64 pixels.
+func RgbaAverageHash(rgbaImage []byte, width int,
+    height int) PerceptualHash {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(rgbaImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return NewRgbaView(rgbaImage, width, height).AverageHash()
+}
+
+// AverageHash computes a view's average hash (aHash).
+// This is the view equivalent of RgbaAverageHash.
+func (v RgbaView) AverageHash() PerceptualHash {
+  v.check("View")
+  if v.Empty() {
+    return 0
+  }
+
+  thumbnail := v.grayThumbnail(8, 8)
+  average := 0.0
+  for _, value := range thumbnail {
+    average += value
+  }
+  average /= 64
+
+  var hash PerceptualHash
+  for _, value := range thumbnail {
+    hash <<= 1
+    if value > average {
+      hash |= 1
+    }
+  }
+  return hash
+}
+
+// RgbaDifferenceHash computes an RGBA image's difference hash (dHash).
+// The image is shrunk to 9x8 pixels, and each bit is set if a pixel is
+// brighter than its right neighbor.
+func RgbaDifferenceHash(rgbaImage []byte, width int,
+    height int) PerceptualHash {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(rgbaImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return NewRgbaView(rgbaImage, width, height).DifferenceHash()
+}
+
+// DifferenceHash computes a view's difference hash (dHash).
+// This is the view equivalent of RgbaDifferenceHash.
+func (v RgbaView) DifferenceHash() PerceptualHash {
+  v.check("View")
+  if v.Empty() {
+    return 0
+  }
+
+  thumbnail := v.grayThumbnail(9, 8)
+  var hash PerceptualHash
+  for y := 0; y < 8; y += 1 {
+    for x := 0; x < 8; x += 1 {
+      hash <<= 1
+      if thumbnail[y * 9 + x] > thumbnail[y * 9 + x + 1] {
+        hash |= 1
+      }
+    }
+  }
+  return hash
+}
+
+// RgbaDctHash computes an RGBA image's DCT-based hash (pHash).
+// The image is shrunk to 32x32 pixels, and transformed by a 2D DCT. Each bit
+// is set if one of the 8x8 lowest-frequency coefficients is above the median
+// of those coefficients, excluding the DC coefficient. This is the most
+// robust of the perceptual hashes, and the slowest.
+func RgbaDctHash(rgbaImage []byte, width int, height int) PerceptualHash {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code. Therefore, panicing is appropriate.
+  if len(rgbaImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return NewRgbaView(rgbaImage, width, height).DctHash()
+}
+
+// DctHash computes a view's DCT-based hash (pHash).
+// This is the view equivalent of RgbaDctHash.
+func (v RgbaView) DctHash() PerceptualHash {
+  v.check("View")
+  if v.Empty() {
+    return 0
+  }
+
+  thumbnail := v.grayThumbnail(dctSize, dctSize)
+
+  // The DCT is separable, so the rows are transformed first, then the
+  // columns. Only the 8 lowest frequencies are needed in each direction.
+  var rows [dctSize][8]float64
+  for y := 0; y < dctSize; y += 1 {
+    for u := 0; u < 8; u += 1 {
+      for x := 0; x < dctSize; x += 1 {
+        rows[y][u] += dctCosines[u][x] * thumbnail[y * dctSize + x]
+      }
+    }
+  }
+  var coefficients [64]float64
+  for w := 0; w < 8; w += 1 {
+    for u := 0; u < 8; u += 1 {
+      for y := 0; y < dctSize; y += 1 {
+        coefficients[w * 8 + u] += dctCosines[w][y] * rows[y][u]
+      }
+    }
+  }
+
+  // There are 63 coefficients besides the DC one, so the median is the
+  // middle one.
+  sorted := make([]float64, 63)
+  copy(sorted, coefficients[1:])
+  sort.Float64s(sorted)
+  median := sorted[31]
+
+  var hash PerceptualHash
+  for _, coefficient := range coefficients {
+    hash <<= 1
+    if coefficient > median {
+      hash |= 1
+    }
+  }
+  return hash
+}
+
+// dctSize is the width and height of the thumbnail used by the DCT hash.
+const dctSize = 32
+
+// dctCosines holds the DCT-II basis functions for the 8 lowest frequencies.
+var dctCosines = buildDctCosines()
+
+// buildDctCosines computes the DCT-II basis functions.
+func buildDctCosines() [8][dctSize]float64 {
+  var cosines [8][dctSize]float64
+  for u := range cosines {
+    for x := range cosines[u] {
+      cosines[u][x] = math.Cos(float64((2 * x + 1) * u) * math.Pi /
+          (2 * dctSize))
+    }
+  }
+  return cosines
+}
+
+// grayThumbnail shrinks a non-empty checked view into a grayscale thumbnail.
+// Each thumbnail pixel is the average gray value of an area in the view. The
+// pixels are returned in row-major order.
+func (v RgbaView) grayThumbnail(thumbWidth int,
+    thumbHeight int) []float64 {
+  sums := make([]uint64, thumbWidth * thumbHeight)
+  counts := make([]uint32, thumbWidth * thumbHeight)
+  rgbaGrayThumbnail(v, thumbWidth, thumbHeight, sums, counts)
+
+  thumbnail := make([]float64, thumbWidth * thumbHeight)
+  for i := range thumbnail {
+    thumbnail[i] = float64(sums[i]) / float64(counts[i])
+  }
+  return thumbnail
+}
+
+// HashIndex finds perceptual hashes that are close to a given hash.
+// The index is a BK-tree, which uses the triangle inequality of the Hamming
+// distance to skip most of the hashes during searches. The zero value is an
+// empty index that is ready to use. Indexes are not safe for concurrent use if
+// any goroutine adds hashes.
+type HashIndex struct {
+  // root is the tree's root node, or nil for an empty index.
+  root *hashIndexNode
+  // size is the number of hashes in the index.
+  size int
+}
+
+// HashIndexMatch is a hash found by HashIndex.Search.
+type HashIndexMatch struct {
+  // Hash is the indexed hash.
+  Hash PerceptualHash
+  // ID is the identifier that was passed to HashIndex.Add with the hash.
+  ID int
+  // Distance is the Hamming distance between the hash and the search hash.
+  Distance int
+}
+
+// hashIndexNode is a node in a HashIndex's BK-tree.
+type hashIndexNode struct {
+  hash PerceptualHash
+  id int
+  // order is the number of hashes added to the index before this one.
+  order int
+  // children holds the subtrees whose roots are at each distance from this
+  // node's hash. Duplicate hashes are chained at distance 0. Most nodes have
+  // few children, so the slice is much smaller than an array with a slot for
+  // each of the 65 distances.
+  children []hashIndexChild
+}
+
+// hashIndexChild is a subtree in a BK-tree node.
+type hashIndexChild struct {
+  // distance is the distance between the subtree's root and its parent.
+  distance int
+  node *hashIndexNode
+}
+
+// child returns the subtree at a distance from this node, or nil.
+func (node *hashIndexNode) child(distance int) *hashIndexNode {
+  for _, child := range node.children {
+    if child.distance == distance {
+      return child.node
+    }
+  }
+  return nil
+}
+
+// Add inserts a hash into the index.
+// The ID identifies the hashed image in search results. The same hash can be
+// added multiple times, with different IDs.
+func (index *HashIndex) Add(hash PerceptualHash, id int) {
+  node := &hashIndexNode{hash: hash, id: id, order: index.size}
+  index.size += 1
+  if index.root == nil {
+    index.root = node
+    return
+  }
+
+  parent := index.root
+  for {
+    distance := parent.hash.Distance(hash)
+    child := parent.child(distance)
+    if child == nil {
+      parent.children = append(parent.children, hashIndexChild{distance, node})
+      return
+    }
+    parent = child
+  }
+}
+
+// Len returns the number of hashes in the index.
+func (index *HashIndex) Len() int {
+  return index.size
+}
+
+// Search returns the indexed hashes within maxDistance of a hash.
+// The matches are sorted by their distances, and ties are sorted by the order
+// in which the hashes were added.
+func (index *HashIndex) Search(hash PerceptualHash,
+    maxDistance int) []HashIndexMatch {
+  if index.root == nil || maxDistance < 0 {
+    return nil
+  }
+
+  var matches []HashIndexMatch
+  var orders []int
+  nodes := []*hashIndexNode{index.root}
+  for len(nodes) > 0 {
+    node := nodes[len(nodes) - 1]
+    nodes = nodes[:len(nodes) - 1]
+
+    distance := node.hash.Distance(hash)
+    if distance <= maxDistance {
+      matches = append(matches, HashIndexMatch{Hash: node.hash, ID: node.id,
+          Distance: distance})
+      orders = append(orders, node.order)
+    }
+    // By the triangle inequality, matches can only be in the subtrees whose
+    // distances are within maxDistance of this node's distance.
+    for _, child := range node.children {
+      if child.distance >= distance - maxDistance &&
+          child.distance <= distance + maxDistance {
+        nodes = append(nodes, child.node)
+      }
+    }
+  }
+
+  sort.Sort(hashIndexMatches{matches, orders})
+  return matches
+}
+
+// hashIndexMatches sorts search results by distance, then by insertion order.
+type hashIndexMatches struct {
+  matches []HashIndexMatch
+  orders []int
+}
+
+func (m hashIndexMatches) Len() int {
+  return len(m.matches)
+}
+
+func (m hashIndexMatches) Less(i int, j int) bool {
+  if m.matches[i].Distance != m.matches[j].Distance {
+    return m.matches[i].Distance < m.matches[j].Distance
+  }
+  return m.orders[i] < m.orders[j]
+}
+
+func (m hashIndexMatches) Swap(i int, j int) {
+  m.matches[i], m.matches[j] = m.matches[j], m.matches[i]
+  m.orders[i], m.orders[j] = m.orders[j], m.orders[i]
+}
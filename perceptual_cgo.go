@@ -0,0 +1,21 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/perceptual.c"
+import "C"  // cgo
+
+import (
+  "unsafe"
+)
+
+// rgbaGrayThumbnail adds up the gray values in each cell of a thumbnail grid.
+// The view must be non-empty and checked, and the sums and counts slices must
+// have one element per cell.
+func rgbaGrayThumbnail(v RgbaView, thumbWidth int, thumbHeight int,
+    sums []uint64, counts []uint32) {
+  C.GoRgbaGrayThumbnail(unsafe.Pointer(&v.Pix[0]), C.int(v.Width),
+      C.int(v.Height), C.int(v.Stride / 4), C.int(thumbWidth),
+      C.int(thumbHeight), (*C.uint64_t)(unsafe.Pointer(&sums[0])),
+      (*C.uint32_t)(unsafe.Pointer(&counts[0])))
+}
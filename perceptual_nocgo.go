@@ -0,0 +1,35 @@
+//go:build !cgo
+
+package imageutil
+
+// The kernels below are the pure Go versions of the functions in
+// c/perceptual.c.
+
+// rgbaGrayThumbnail adds up the gray values in each cell of a thumbnail grid.
+// The view must be non-empty and checked, and the sums and counts slices must
+// have one element per cell.
+func rgbaGrayThumbnail(v RgbaView, thumbWidth int, thumbHeight int,
+    sums []uint64, counts []uint32) {
+  for cy := 0; cy < thumbHeight; cy += 1 {
+    minY, maxY := cy * v.Height / thumbHeight, (cy + 1) * v.Height / thumbHeight
+    if maxY <= minY {
+      maxY = minY + 1
+    }
+    for cx := 0; cx < thumbWidth; cx += 1 {
+      minX, maxX := cx * v.Width / thumbWidth, (cx + 1) * v.Width / thumbWidth
+      if maxX <= minX {
+        maxX = minX + 1
+      }
+
+      var sum uint64
+      for y := minY; y < maxY; y += 1 {
+        row := v.Pix[v.PixOffset(0, y):]
+        for x := minX; x < maxX; x += 1 {
+          sum += uint64(pixelGray(row[x * 4], row[x * 4 + 1], row[x * 4 + 2]))
+        }
+      }
+      sums[cy * thumbWidth + cx] = sum
+      counts[cy * thumbWidth + cx] = uint32((maxX - minX) * (maxY - minY))
+    }
+  }
+}
@@ -0,0 +1,128 @@
+package imageutil
+
+import (
+  "math/rand"
+  "reflect"
+  "sort"
+  "testing"
+)
+
+func TestPerceptualHashes(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  // A near-duplicate: slightly brighter, with a few scribbles.
+  nearCopy := make([]byte, len(image.Pix))
+  copy(nearCopy, image.Pix)
+  for i := 0; i < len(nearCopy); i += 4 {
+    for channel := i; channel < i + 3; channel += 1 {
+      if nearCopy[channel] < 250 {
+        nearCopy[channel] += 3
+      }
+    }
+  }
+  for x := 100; x < 140; x += 1 {
+    nearCopy[image.PixOffset(x, 300)] = 0xff
+  }
+
+  // A half-size copy.
+  var shrunk []byte
+  if err := AffineWarpRgba(image.Pix, width, height,
+      [6]float64{0.5, 0, 0, 0, 0.5, 0}, width / 2, height / 2, 0x000000ff,
+      &shrunk); err != nil {
+    t.Fatal(err)
+  }
+
+  // A different image.
+  var rotated []byte
+  RotateRgba90(image.Pix, width, height, &rotated)
+
+  hashFuncs := map[string]func([]byte, int, int) PerceptualHash{
+    "Average": RgbaAverageHash,
+    "Difference": RgbaDifferenceHash,
+    "Dct": RgbaDctHash,
+  }
+  for name, hashFunc := range hashFuncs {
+    hash := hashFunc(image.Pix, width, height)
+    if distance := hash.Distance(hashFunc(nearCopy, width,
+        height)); distance > 4 {
+      t.Errorf("%s hash distance %d for near-duplicate\n", name, distance)
+    }
+    if distance := hash.Distance(hashFunc(shrunk, width / 2,
+        height / 2)); distance > 6 {
+      t.Errorf("%s hash distance %d for shrunk copy\n", name, distance)
+    }
+    if distance := hash.Distance(hashFunc(rotated, height,
+        width)); distance < 16 {
+      t.Errorf("%s hash distance %d for different image\n", name, distance)
+    }
+
+    // Images smaller than the thumbnails still get hashed.
+    tinyHash := hashFunc(image.Pix[:3 * 2 * 4], 3, 2)
+    if tinyHash != hashFunc(image.Pix[:3 * 2 * 4], 3, 2) {
+      t.Errorf("%s hash of tiny image is not deterministic\n", name)
+    }
+    if emptyHash := hashFunc(nil, 0, 0); emptyHash != 0 {
+      t.Errorf("%s hash of empty image is %x\n", name, emptyHash)
+    }
+  }
+
+  // View hashes match the hashes of materialized views.
+  view, _ := ViewRgba(image.Pix, width, height, 30, 40, 200, 150)
+  var materialized []byte
+  view.Materialize(&materialized)
+  if view.DctHash() != RgbaDctHash(materialized, 200, 150) {
+    t.Error("View DCT hash does not match packed hash")
+  }
+}
+
+func TestHashIndex(t *testing.T) {
+  random := rand.New(rand.NewSource(3))
+  hashes := make([]PerceptualHash, 2000)
+  for i := range hashes {
+    if i > 0 && i % 10 == 0 {
+      // Near-duplicates and exact duplicates of earlier hashes.
+      hashes[i] = hashes[i - 1] ^ PerceptualHash(1) << uint(random.Intn(64))
+      if i % 20 == 0 {
+        hashes[i] = hashes[i - 3]
+      }
+      continue
+    }
+    hashes[i] = PerceptualHash(random.Uint64())
+  }
+
+  var index HashIndex
+  if matches := index.Search(hashes[0], 64); len(matches) != 0 {
+    t.Error("Empty index returned matches: ", matches)
+  }
+  for i, hash := range hashes {
+    index.Add(hash, i)
+  }
+  if index.Len() != len(hashes) {
+    t.Error("Incorrect index length: ", index.Len())
+  }
+
+  for _, maxDistance := range []int{0, 1, 3, 12, 64} {
+    for query := 0; query < len(hashes); query += 97 {
+      var goldMatches []HashIndexMatch
+      for i, hash := range hashes {
+        if distance := hash.Distance(hashes[query]);
+            distance <= maxDistance {
+          goldMatches = append(goldMatches, HashIndexMatch{hash, i, distance})
+        }
+      }
+      sort.SliceStable(goldMatches, func(i int, j int) bool {
+        return goldMatches[i].Distance < goldMatches[j].Distance
+      })
+
+      matches := index.Search(hashes[query], maxDistance)
+      if !reflect.DeepEqual(matches, goldMatches) {
+        t.Errorf("Search for hash %d within %d returned %d matches instead " +
+            "of %d\n", query, maxDistance, len(matches), len(goldMatches))
+      }
+    }
+  }
+}
@@ -0,0 +1,46 @@
+package imageutil
+
+import (
+  "encoding/binary"
+)
+
+// Packed pixels are RGBA pixels loaded from memory into an uint32, which is
+// how the C code sees them. The channels' positions inside a packed pixel
+// depend on the host's byte order. Colors and masks in the public API are
+// written as 0xRRGGBBAA, and must be converted with packRgba before they are
+// compared or combined with packed pixels.
+
+// packRgba converts a 0xRRGGBBAA color or mask into a packed pixel.
+func packRgba(rgba uint32) uint32 {
+  return packRgbaOrder(binary.NativeEndian, rgba)
+}
+
+// unpackRgba converts a packed pixel into a 0xRRGGBBAA color.
+func unpackRgba(pixel uint32) uint32 {
+  return unpackRgbaOrder(binary.NativeEndian, pixel)
+}
+
+// packRgbaOrder converts a 0xRRGGBBAA color into a packed pixel.
+// The byte order is a parameter so the packing logic can be tested for both
+// little-endian and big-endian hosts.
+func packRgbaOrder(order binary.ByteOrder, rgba uint32) uint32 {
+  var pixelBytes [4]byte
+  binary.BigEndian.PutUint32(pixelBytes[:], rgba)
+  return order.Uint32(pixelBytes[:])
+}
+
+// unpackRgbaOrder converts a packed pixel into a 0xRRGGBBAA color.
+// The byte order is a parameter so the packing logic can be tested for both
+// little-endian and big-endian hosts.
+func unpackRgbaOrder(order binary.ByteOrder, pixel uint32) uint32 {
+  var pixelBytes [4]byte
+  order.PutUint32(pixelBytes[:], pixel)
+  return binary.BigEndian.Uint32(pixelBytes[:])
+}
+
+// pixelGray returns a color's Rec. 601 luma.
+// The luma is computed with 8-bit fixed-point weights, like pixelGray in
+// c/pixel.h.
+func pixelGray(red byte, green byte, blue byte) byte {
+  return byte((77 * int(red) + 150 * int(green) + 29 * int(blue) + 128) >> 8)
+}
@@ -0,0 +1,28 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/pixel.h"
+import "C"  // cgo
+
+// cPixelPack calls the C packing helper.
+// This is only used to check that the C and Go helpers agree.
+func cPixelPack(rgba uint32) uint32 {
+  return uint32(C.pixelPack(C.uint8_t(rgba >> 24), C.uint8_t(rgba >> 16),
+      C.uint8_t(rgba >> 8), C.uint8_t(rgba)))
+}
+
+// cPixelUnpack calls the C channel extraction helpers.
+// This is only used to check that the C and Go helpers agree.
+func cPixelUnpack(pixel uint32) uint32 {
+  cpixel := C.uint32_t(pixel)
+  return (uint32(C.pixelRed(cpixel)) << 24) |
+      (uint32(C.pixelGreen(cpixel)) << 16) |
+      (uint32(C.pixelBlue(cpixel)) << 8) | uint32(C.pixelAlpha(cpixel))
+}
+
+// cPixelCanonical calls the C canonicalization helper.
+// This is only used to check that the C and Go helpers agree.
+func cPixelCanonical(pixel uint32) uint32 {
+  return uint32(C.pixelCanonical(C.uint32_t(pixel)))
+}
@@ -0,0 +1,27 @@
+//go:build cgo
+
+package imageutil
+
+import (
+  "encoding/binary"
+  "testing"
+)
+
+func TestCPixelHelpers(t *testing.T) {
+  for _, rgba := range []uint32{0x11223344, 0xff000000, 0x00ff0000,
+      0x0000ff00, 0x000000ff, 0xdeadbeef} {
+    pixel := packRgba(rgba)
+    if cpixel := cPixelPack(rgba); cpixel != pixel {
+      t.Errorf("C packed %X into %X, Go packed it into %X\n", rgba, cpixel,
+          pixel)
+    }
+    if crgba := cPixelUnpack(pixel); crgba != rgba {
+      t.Errorf("C unpacked %X into %X, expected %X\n", pixel, crgba, rgba)
+    }
+    canonical := packRgbaOrder(binary.LittleEndian, rgba)
+    if ccanonical := cPixelCanonical(pixel); ccanonical != canonical {
+      t.Errorf("C canonicalized %X into %X, expected %X\n", pixel,
+          ccanonical, canonical)
+    }
+  }
+}
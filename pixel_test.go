@@ -0,0 +1,50 @@
+package imageutil
+
+import (
+  "bytes"
+  "encoding/binary"
+  "testing"
+  "unsafe"
+)
+
+func TestPackRgbaOrder(t *testing.T) {
+  cases := []struct {
+    order binary.ByteOrder
+    rgba uint32
+    pixel uint32
+  }{
+    {binary.LittleEndian, 0x11223344, 0x44332211},
+    {binary.LittleEndian, 0xff000000, 0x000000ff},
+    {binary.LittleEndian, 0x000000ff, 0xff000000},
+    {binary.BigEndian, 0x11223344, 0x11223344},
+    {binary.BigEndian, 0xff000000, 0xff000000},
+    {binary.BigEndian, 0x000000ff, 0x000000ff},
+  }
+
+  for _, testCase := range cases {
+    if pixel := packRgbaOrder(testCase.order, testCase.rgba);
+        pixel != testCase.pixel {
+      t.Errorf("%v packed %X into %X, expected %X\n", testCase.order,
+          testCase.rgba, pixel, testCase.pixel)
+    }
+    if rgba := unpackRgbaOrder(testCase.order, testCase.pixel);
+        rgba != testCase.rgba {
+      t.Errorf("%v unpacked %X into %X, expected %X\n", testCase.order,
+          testCase.pixel, rgba, testCase.rgba)
+    }
+  }
+}
+
+func TestPackRgbaMemoryLayout(t *testing.T) {
+  pixel := packRgba(0x11223344)
+  pixelBytes := (*[4]byte)(unsafe.Pointer(&pixel))[:]
+  if !bytes.Equal(pixelBytes, []byte{0x11, 0x22, 0x33, 0x44}) {
+    t.Errorf("Packed pixel is laid out as %v in memory\n", pixelBytes)
+  }
+
+  memory := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+  if rgba := unpackRgba(*(*uint32)(unsafe.Pointer(&memory[0])));
+      rgba != 0xaabbccdd {
+    t.Errorf("Pixel loaded from memory unpacked into %X\n", rgba)
+  }
+}
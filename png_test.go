@@ -4,6 +4,11 @@ import (
   "bytes"
   "encoding/hex"
   "crypto/sha256"
+  "errors"
+  "image"
+  "image/color"
+  "image/png"
+  "os"
   "testing"
 )
 
@@ -48,3 +53,261 @@ func TestRgbaToPng(t *testing.T) {
     t.Error("Pixel data mismatch")
   }
 }
+
+// writeTestPng encodes an image into a PNG file in test_tmp.
+func writeTestPng(t *testing.T, pngImage image.Image, name string) string {
+  fileName := "test_tmp/" + name + ".png"
+  f, err := os.Create(fileName)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer f.Close()
+  if err := png.Encode(f, pngImage); err != nil {
+    t.Fatal(err)
+  }
+  return fileName
+}
+
+func TestReadRgbaPngColorModels(t *testing.T) {
+  bounds := image.Rect(0, 0, 3, 2)
+  palette := color.Palette{color.NRGBA{0x10, 0x20, 0x30, 0xff},
+      color.NRGBA{0x80, 0x40, 0x20, 0x80}, color.NRGBA{0xff, 0xff, 0xff, 0}}
+  paletted := image.NewPaletted(bounds, palette)
+  gray := image.NewGray(bounds)
+  gray16 := image.NewGray16(bounds)
+  nrgba := image.NewNRGBA(bounds)
+  nrgba64 := image.NewNRGBA64(bounds)
+  for y := 0; y < 2; y += 1 {
+    for x := 0; x < 3; x += 1 {
+      paletted.SetColorIndex(x, y, uint8((x + y) % 3))
+      gray.SetGray(x, y, color.Gray{uint8(x * 80 + y)})
+      gray16.SetGray16(x, y, color.Gray16{uint16(x * 20000 + y * 300)})
+      nrgba.SetNRGBA(x, y, color.NRGBA{uint8(x * 90), 0x40, uint8(y * 200),
+          uint8(x * 127)})
+      nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(x * 30000), 0x4040,
+          0x8080, uint16(y * 0xffff)})
+    }
+  }
+
+  testImages := map[string]image.Image{"paletted": paletted, "gray": gray,
+      "gray16": gray16, "nrgba": nrgba, "nrgba64": nrgba64}
+  for name, testImage := range testImages {
+    fileName := writeTestPng(t, testImage, "color_model_" + name)
+    rgbaImage, err := ReadRgbaPng(fileName)
+    if err != nil {
+      t.Errorf("ReadRgbaPng failed for %s PNG: %v\n", name, err)
+      continue
+    }
+    nrgbaImage, err := ReadNrgbaPng(fileName)
+    if err != nil {
+      t.Errorf("ReadNrgbaPng failed for %s PNG: %v\n", name, err)
+      continue
+    }
+    if rgbaImage.Rect != bounds || rgbaImage.Stride != 12 ||
+        nrgbaImage.Rect != bounds || nrgbaImage.Stride != 12 {
+      t.Errorf("Incorrect %s bounds or strides\n", name)
+      continue
+    }
+
+    for y := 0; y < 2; y += 1 {
+      for x := 0; x < 3; x += 1 {
+        goldRgba := color.RGBAModel.Convert(testImage.At(x, y))
+        if rgbaImage.At(x, y) != goldRgba {
+          t.Errorf("%s RGBA pixel (%d, %d) is %v instead of %v\n", name, x,
+              y, rgbaImage.At(x, y), goldRgba)
+        }
+        r, g, b, a := testImage.At(x, y).RGBA()
+        if nrgba, ok := testImage.At(x, y).(color.NRGBA); ok {
+          r, g, b, a = uint32(nrgba.R) << 8, uint32(nrgba.G) << 8,
+              uint32(nrgba.B) << 8, uint32(nrgba.A) << 8
+        } else if nrgba, ok := testImage.At(x, y).(color.NRGBA64); ok {
+          r, g, b, a = uint32(nrgba.R), uint32(nrgba.G), uint32(nrgba.B),
+              uint32(nrgba.A)
+        }
+        goldNrgba := color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8),
+            uint8(a >> 8)}
+        if nrgbaImage.NRGBAAt(x, y) != goldNrgba {
+          t.Errorf("%s NRGBA pixel (%d, %d) is %v instead of %v\n", name, x,
+              y, nrgbaImage.NRGBAAt(x, y), goldNrgba)
+        }
+      }
+    }
+  }
+
+  // Fully transparent pixels keep their colors in NRGBA.
+  nrgbaImage, err := ReadNrgbaPng("test_tmp/color_model_paletted.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if pixel := nrgbaImage.NRGBAAt(2, 0); pixel != palette[2] {
+    t.Error("Transparent paletted pixel lost its color: ", pixel)
+  }
+}
+
+// testPngPool is a png.EncoderBufferPool that counts its uses.
+type testPngPool struct {
+  buffer *png.EncoderBuffer
+  gets int
+}
+
+func (pool *testPngPool) Get() *png.EncoderBuffer {
+  pool.gets += 1
+  buffer := pool.buffer
+  pool.buffer = nil
+  return buffer
+}
+
+func (pool *testPngPool) Put(buffer *png.EncoderBuffer) {
+  pool.buffer = buffer
+}
+
+// failingWriter is an io.Writer that fails after a number of bytes.
+type failingWriter struct {
+  remaining int
+}
+
+func (w *failingWriter) Write(data []byte) (int, error) {
+  if len(data) > w.remaining {
+    written := w.remaining
+    w.remaining = 0
+    return written, errors.New("Writer full")
+  }
+  w.remaining -= len(data)
+  return len(data), nil
+}
+
+func TestEncodeRgbaPng(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  pool := &testPngPool{}
+  sizes := map[png.CompressionLevel]int{}
+  for _, level := range []png.CompressionLevel{png.DefaultCompression,
+      png.NoCompression, png.BestSpeed, png.BestCompression} {
+    var buffer bytes.Buffer
+    if err := EncodeRgbaPng(&buffer, fruits.Pix, width, height,
+        &PngOptions{CompressionLevel: level, BufferPool: pool}); err != nil {
+      t.Fatal(err)
+    }
+    sizes[level] = buffer.Len()
+
+    decoded, err := DecodeRgbaPng(&buffer)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if !bytes.Equal(decoded.Pix, fruits.Pix) {
+      t.Errorf("Pixel data mismatch at compression level %d\n", level)
+    }
+  }
+  if sizes[png.NoCompression] <= sizes[png.BestSpeed] ||
+      sizes[png.BestSpeed] < sizes[png.BestCompression] {
+    t.Error("Compression levels did not affect PNG sizes: ", sizes)
+  }
+  if pool.gets != 4 || pool.buffer == nil {
+    t.Error("Buffer pool was not used")
+  }
+
+  var defaultBuffer bytes.Buffer
+  if err := EncodeRgbaPng(&defaultBuffer, fruits.Pix, width, height,
+      nil); err != nil {
+    t.Fatal(err)
+  }
+  if defaultBuffer.Len() != sizes[png.DefaultCompression] {
+    t.Error("Nil options do not match the default options")
+  }
+  decoded, err := DecodeNrgbaPng(&defaultBuffer)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(decoded.Pix, fruits.Pix) {
+    t.Error("DecodeNrgbaPng pixel data mismatch")
+  }
+
+  if err := EncodeRgbaPng(&defaultBuffer, fruits.Pix, width, height + 1,
+      nil); !errors.Is(err, ErrBufferTooSmall) {
+    t.Error("Small buffer did not return ErrBufferTooSmall: ", err)
+  }
+  if err := EncodeRgbaPng(&failingWriter{1000}, fruits.Pix, width, height,
+      nil); err == nil {
+    t.Error("Writer error was not returned")
+  }
+  if _, err := DecodeRgbaPng(bytes.NewReader([]byte("\x89PNG"))); err == nil {
+    t.Error("Truncated PNG did not return an error")
+  }
+
+  if err := RgbaToPngWithOptions(fruits.Pix, width, height,
+      "test_tmp/fruits_RgbaToPngWithOptions.png",
+      &PngOptions{CompressionLevel: png.BestSpeed}); err != nil {
+    t.Fatal(err)
+  }
+  recoded, err := ReadRgbaPng("test_tmp/fruits_RgbaToPngWithOptions.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(recoded.Pix, fruits.Pix) {
+    t.Error("RgbaToPngWithOptions pixel data mismatch")
+  }
+  // Invalid images don't leave empty files behind.
+  if err := RgbaToPngWithOptions(fruits.Pix, width, height + 1,
+      "test_tmp/fruits_invalid.png", nil); !errors.Is(err,
+      ErrBufferTooSmall) {
+    t.Error("Small buffer did not return ErrBufferTooSmall: ", err)
+  }
+  if _, err := os.Stat("test_tmp/fruits_invalid.png"); !os.IsNotExist(err) {
+    t.Error("Invalid image created a file: ", err)
+  }
+}
+
+func TestSetPngDefaults(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  var defaultBuffer, fastBuffer bytes.Buffer
+  if err := EncodeRgbaPng(&defaultBuffer, fruits.Pix, width, height,
+      nil); err != nil {
+    t.Fatal(err)
+  }
+  pool := &PngBufferPool{}
+  previous := SetPngDefaults(&PngOptions{CompressionLevel: png.NoCompression,
+      BufferPool: pool})
+  defer SetPngDefaults(&previous)
+  if previous != (PngOptions{}) {
+    t.Error("Incorrect initial defaults: ", previous)
+  }
+  if defaults := PngDefaults(); defaults.CompressionLevel !=
+      png.NoCompression || defaults.BufferPool != pool {
+    t.Error("Defaults were not set: ", defaults)
+  }
+
+  if err := EncodeRgbaPng(&fastBuffer, fruits.Pix, width, height,
+      nil); err != nil {
+    t.Fatal(err)
+  }
+  if fastBuffer.Len() <= defaultBuffer.Len() {
+    t.Errorf("Uncompressed PNG has %d bytes, default PNG has %d bytes\n",
+        fastBuffer.Len(), defaultBuffer.Len())
+  }
+
+  if err := RgbaToPng(fruits.Pix, width, height,
+      "test_tmp/fruits_defaults.png"); err != nil {
+    t.Fatal(err)
+  }
+  recoded, err := ReadRgbaPng("test_tmp/fruits_defaults.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(recoded.Pix, fruits.Pix) {
+    t.Error("Pixel data mismatch with custom defaults")
+  }
+
+  SetPngDefaults(nil)
+  if defaults := PngDefaults(); defaults != (PngOptions{}) {
+    t.Error("Nil options did not restore the zero defaults: ", defaults)
+  }
+}
@@ -0,0 +1,110 @@
+package imageutil
+
+import (
+  "bufio"
+  "bytes"
+  "encoding/binary"
+  "fmt"
+  "image"
+  "io"
+  "os"
+)
+
+// The raw image format stores a raw RGBA buffer after a fixed-size header,
+// so it can be read by other tools without a parser. The 16-byte header holds
+// the rawMagic bytes, then the image's width and height as little-endian
+// 32-bit unsigned integers. The header is followed by width * height * 4 bytes
+// of pixel data, in the same layout as the buffers used by this package.
+
+// rawMagic identifies raw image files.
+// The last byte is the format's version.
+var rawMagic = []byte("RGBARAW\x01")
+
+// rawHeaderSize is the number of bytes before the raw pixel data.
+const rawHeaderSize = 16
+
+// RgbaToRaw encodes a raw RGBA-encoded image into a raw image file.
+// It returns any error encountered.
+func RgbaToRaw(rawImage []byte, width int, height int,
+    fileName string) error {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return err
+  }
+  return writeImageFile(fileName, func(w io.Writer) error {
+    return EncodeRgbaRaw(w, rawImage, width, height)
+  })
+}
+
+// EncodeRgbaRaw encodes a raw RGBA-encoded image in the raw image format into
+// a writer.
+// The pixel data is a verbatim copy of the image's buffer. It returns any
+// error encountered, including an error wrapping ErrInvalidDimensions or
+// ErrBufferTooSmall when the image's dimensions don't match its buffer.
+func EncodeRgbaRaw(w io.Writer, rawImage []byte, width int,
+    height int) error {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return err
+  }
+  if uint64(width) > 0xffffffff || uint64(height) > 0xffffffff {
+    return fmt.Errorf("Image is %d x %d: %w", width, height,
+        ErrInvalidDimensions)
+  }
+
+  header := make([]byte, rawHeaderSize)
+  copy(header, rawMagic)
+  binary.LittleEndian.PutUint32(header[8:], uint32(width))
+  binary.LittleEndian.PutUint32(header[12:], uint32(height))
+  if _, err := w.Write(header); err != nil {
+    return err
+  }
+  _, err := w.Write(rawImage[:width * height * 4])
+  return err
+}
+
+// ReadRgbaRaw decodes a raw image file into a raw RGBA buffer.
+// It returns the decoded image and any error encountered.
+func ReadRgbaRaw(fileName string) (*image.RGBA, error) {
+  f, err := os.Open(fileName)
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close()
+
+  return DecodeRgbaRaw(bufio.NewReader(f))
+}
+
+// DecodeRgbaRaw decodes an image in the raw image format from a reader into a
+// raw RGBA buffer.
+// The pixel data is copied verbatim. It returns an error wrapping
+// ErrInvalidHeader if the data does not start with a raw image header, an
+// error wrapping ErrInvalidDimensions if the header's dimensions are too
+// large, and io.ErrUnexpectedEOF if the pixel data is truncated.
+func DecodeRgbaRaw(r io.Reader) (*image.RGBA, error) {
+  header := make([]byte, rawHeaderSize)
+  if _, err := io.ReadFull(r, header); err != nil {
+    return nil, err
+  }
+  if !bytes.Equal(header[:len(rawMagic)], rawMagic) {
+    return nil, fmt.Errorf("Unknown raw image magic %q: %w",
+        header[:len(rawMagic)], ErrInvalidHeader)
+  }
+
+  width := int64(binary.LittleEndian.Uint32(header[8:]))
+  height := int64(binary.LittleEndian.Uint32(header[12:]))
+  if int64(int(width)) != width || int64(int(height)) != height {
+    return nil, fmt.Errorf("Image is %d x %d: %w", width, height,
+        ErrInvalidDimensions)
+  }
+  size, err := rgbaSize("Image", int(width), int(height))
+  if err != nil {
+    return nil, err
+  }
+
+  pixels, err := readImageData(r, size)
+  if err != nil {
+    return nil, err
+  }
+  rgbaImage := &image.RGBA{Pix: pixels, Stride: int(width) * 4,
+    Rect: image.Rect(0, 0, int(width), int(height))}
+  return rgbaImage, nil
+}
@@ -0,0 +1,73 @@
+package imageutil
+
+import (
+  "bytes"
+  "errors"
+  "io"
+  "testing"
+)
+
+func TestRgbaToRaw(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  if err := RgbaToRaw(fruits.Pix, width, height,
+      "test_tmp/fruits.raw"); err != nil {
+    t.Fatal(err)
+  }
+  decoded, err := ReadRgbaRaw("test_tmp/fruits.raw")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if decoded.Rect != fruits.Rect || !bytes.Equal(decoded.Pix, fruits.Pix) {
+    t.Error("Raw image round trip mismatch")
+  }
+
+  // The header layout is fixed, so other tools can hard-code it.
+  var buffer bytes.Buffer
+  rawImage := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+  if err := EncodeRgbaRaw(&buffer, rawImage, 3, 1); err != nil {
+    t.Fatal(err)
+  }
+  goldBytes := append([]byte("RGBARAW\x01\x03\x00\x00\x00\x01\x00\x00\x00"),
+      rawImage...)
+  if !bytes.Equal(buffer.Bytes(), goldBytes) {
+    t.Errorf("Incorrect raw encoding: %q\n", buffer.Bytes())
+  }
+
+  if err := EncodeRgbaRaw(&buffer, rawImage, 2, 2); !errors.Is(err,
+      ErrBufferTooSmall) {
+    t.Error("Small buffer did not return ErrBufferTooSmall: ", err)
+  }
+  if _, err := DecodeRgbaRaw(bytes.NewReader(goldBytes[:20])); err == nil {
+    t.Error("Truncated pixel data did not return an error")
+  }
+  badMagic := append([]byte("RGBARAW\x02"), goldBytes[8:]...)
+  if _, err := DecodeRgbaRaw(bytes.NewReader(badMagic)); !errors.Is(err,
+      ErrInvalidHeader) {
+    t.Error("Unknown magic did not return ErrInvalidHeader: ", err)
+  }
+
+  // Images whose byte size does not fit in an int are rejected.
+  huge := []byte("RGBARAW\x01\xff\xff\xff\xff\xff\xff\xff\xff")
+  if _, err := DecodeRgbaRaw(bytes.NewReader(huge)); !errors.Is(err,
+      ErrInvalidDimensions) {
+    t.Error("Huge image did not return ErrInvalidDimensions: ", err)
+  }
+  // Headers with sizes that are valid but don't match the data are not
+  // trusted with allocations: 2^31 x (2^30 - 1) and 60000 x 60000.
+  for _, header := range []string{
+    "RGBARAW\x01\x00\x00\x00\x80\xff\xff\xff\x3f",
+    "RGBARAW\x01\x60\xea\x00\x00\x60\xea\x00\x00",
+  } {
+    data := append([]byte(header), rawImage...)
+    if _, err := DecodeRgbaRaw(bytes.NewReader(data)); err !=
+        io.ErrUnexpectedEOF {
+      t.Errorf("Header %q returned %v instead of io.ErrUnexpectedEOF\n",
+          header, err)
+    }
+  }
+}
@@ -0,0 +1,33 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/simd.h"
+import "C"  // cgo
+
+// The instruction sets that the C kernels can use. These values must match
+// the ones in c/simd.h.
+const (
+  simdScalar = C.kSimdScalar
+  simdSse2 = C.kSimdSse2
+  simdAvx2 = C.kSimdAvx2
+)
+
+// maxSimdLevel is the best instruction set supported by the CPU.
+var maxSimdLevel = int(C.simdDetectLevel())
+
+// simdLevel is the instruction set used by the C kernels.
+// Tests lower it to cross-check the SIMD kernels against the scalar ones.
+var simdLevel = maxSimdLevel
+
+// setSimdLevel changes the instruction set used by the C kernels.
+// The level is capped to the best level supported by the CPU. It returns the
+// previous level.
+func setSimdLevel(level int) int {
+  previous := simdLevel
+  if level > maxSimdLevel {
+    level = maxSimdLevel
+  }
+  simdLevel = level
+  return previous
+}
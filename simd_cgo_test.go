@@ -0,0 +1,159 @@
+//go:build cgo
+
+package imageutil
+
+import (
+  "bytes"
+  "math/rand"
+  "testing"
+)
+
+// forEachSimdLevel calls a function with every SIMD level that the CPU
+// supports, then restores the original level.
+func forEachSimdLevel(levelFunc func(level int)) {
+  previous := setSimdLevel(simdScalar)
+  defer setSimdLevel(previous)
+  for level := simdScalar; level <= maxSimdLevel; level += 1 {
+    setSimdLevel(level)
+    levelFunc(level)
+  }
+}
+
+// randomRgba returns random pixels that are likely to hit threshold bounds.
+func randomRgba(random *rand.Rand, pixelCount int) []byte {
+  rgba := make([]byte, pixelCount * 4)
+  random.Read(rgba)
+  for i := 0; i < len(rgba); i += 1 {
+    // Channels that are close to each other exercise the HSL edge cases.
+    if random.Intn(4) == 0 {
+      rgba[i] = rgba[i & ^3]
+    }
+  }
+  return rgba
+}
+
+func TestSimdRgbaToHsla(t *testing.T) {
+  // Cover every RGB value, one red value at a time. 65537 pixels are not a
+  // whole number of vectors, so the scalar tail code is also covered.
+  rgba := make([]byte, (65536 + 1) * 4)
+  golden := make([]byte, len(rgba))
+  hsla := make([]byte, len(rgba))
+  for r := 0; r < 256; r += 1 {
+    for i := 0; i < len(rgba); i += 4 {
+      pixel := i / 4
+      rgba[i], rgba[i + 1], rgba[i + 2] = byte(r), byte(pixel >> 8),
+          byte(pixel)
+      rgba[i + 3] = byte(pixel * 7)
+    }
+
+    setSimdLevel(simdScalar)
+    RgbaToHsla(rgba, golden)
+    forEachSimdLevel(func(level int) {
+      RgbaToHsla(rgba, hsla)
+      if !bytes.Equal(hsla, golden) {
+        t.Fatalf("SIMD level %d HSLA mismatch for red %d\n", level, r)
+      }
+    })
+  }
+}
+
+func TestSimdRgbaThreshold(t *testing.T) {
+  random := rand.New(rand.NewSource(42))
+  rgba := randomRgba(random, 1031)
+  golden := make([]byte, len(rgba))
+  output := make([]byte, len(rgba))
+
+  for i := 0; i < 100; i += 1 {
+    var bounds [6]int
+    for j := range bounds {
+      bounds[j] = random.Intn(256)
+    }
+
+    setSimdLevel(simdScalar)
+    copy(golden, rgba)
+    RgbaThreshold(golden, bounds[0], bounds[1], bounds[2], bounds[3],
+        bounds[4], bounds[5])
+    forEachSimdLevel(func(level int) {
+      copy(output, rgba)
+      RgbaThreshold(output, bounds[0], bounds[1], bounds[2], bounds[3],
+          bounds[4], bounds[5])
+      if !bytes.Equal(output, golden) {
+        t.Fatalf("SIMD level %d threshold mismatch for bounds %v\n", level,
+            bounds)
+      }
+    })
+  }
+}
+
+func TestSimdMatchers(t *testing.T) {
+  random := rand.New(rand.NewSource(42))
+  hayWidth, hayHeight := 41, 13
+  haystack := randomRgba(random, hayWidth * hayHeight)
+
+  // Needle widths between 1 and 19 cover all the possible scalar tail sizes.
+  for needleWidth := 1; needleWidth < 20; needleWidth += 1 {
+    left, top := random.Intn(hayWidth - needleWidth), random.Intn(4)
+    mask := random.Uint32() | 0x01010101
+    var needle []byte
+    CropRgba(haystack, hayWidth, hayHeight, left, top, needleWidth, 9,
+        &needle)
+    MaskRgba(needle, BuildRgbaMask(mask))
+
+    var bounds [6]int
+    for j := range bounds {
+      bounds[j] = random.Intn(256)
+    }
+    var thresholded []byte
+    CropRgba(haystack, hayWidth, hayHeight, left + 1, top, needleWidth, 9,
+        &thresholded)
+    RgbaThreshold(thresholded, bounds[0], bounds[1], bounds[2], bounds[3],
+        bounds[4], bounds[5])
+
+    // Corrupt one byte at a time to make sure that every position is
+    // checked.
+    for corrupt := -1; corrupt < len(needle); corrupt += 5 {
+      if corrupt >= 0 {
+        needle[corrupt] ^= 0x01
+      }
+
+      setSimdLevel(simdScalar)
+      goldCheck := RgbaCheckMaskedCrop(haystack, hayWidth, hayHeight, needle,
+          needleWidth, 9, left, top, mask)
+      goldDiff := RgbaDiffMaskedCrop(haystack, hayWidth, hayHeight, needle,
+          needleWidth, 9, left, top, mask)
+      goldThreshold := RgbaDiffThresholdCrop(haystack, hayWidth, hayHeight,
+          thresholded, needleWidth, 9, left, top, bounds[0], bounds[1],
+          bounds[2], bounds[3], bounds[4], bounds[5])
+      if goldCheck != (corrupt < 0) {
+        t.Fatalf("Scalar check returned %v with corrupted byte %d\n",
+            goldCheck, corrupt)
+      }
+
+      forEachSimdLevel(func(level int) {
+        check := RgbaCheckMaskedCrop(haystack, hayWidth, hayHeight, needle,
+            needleWidth, 9, left, top, mask)
+        if check != goldCheck {
+          t.Errorf("SIMD level %d check mismatch for width %d, corrupted "+
+              "byte %d\n", level, needleWidth, corrupt)
+        }
+        diff := RgbaDiffMaskedCrop(haystack, hayWidth, hayHeight, needle,
+            needleWidth, 9, left, top, mask)
+        if diff != goldDiff {
+          t.Errorf("SIMD level %d diff %d, expected %d\n", level, diff,
+              goldDiff)
+        }
+        threshold := RgbaDiffThresholdCrop(haystack, hayWidth, hayHeight,
+            thresholded, needleWidth, 9, left, top, bounds[0], bounds[1],
+            bounds[2], bounds[3], bounds[4], bounds[5])
+        if threshold != goldThreshold {
+          t.Errorf("SIMD level %d threshold diff %d, expected %d\n", level,
+              threshold, goldThreshold)
+        }
+      })
+
+      if corrupt >= 0 {
+        needle[corrupt] ^= 0x01
+      }
+    }
+  }
+}
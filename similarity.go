@@ -0,0 +1,296 @@
+package imageutil
+
+import (
+  "math"
+)
+
+// The structural similarity index (SSIM) compares images the way people do,
+// by looking at local patterns of brightness and contrast, instead of adding
+// up pixel differences. It is computed over the images' gray values, in
+// ssimWindow x ssimWindow windows placed at every position where they fit in
+// the images. The SSIM of identical images is 1, and lower values mean less
+// similar images.
+
+// ssimWindow is the width and height of the windows used by SSIM.
+// This is the uniform window size used by scikit-image.
+const ssimWindow = 7
+
+// The constants that stabilize the SSIM divisions for 8-bit gray values.
+const (
+  ssimC1 = (0.01 * 255) * (0.01 * 255)
+  ssimC2 = (0.03 * 255) * (0.03 * 255)
+)
+
+// msSsimWeights are the exponents of the MS-SSIM scales, from the original
+// resolution down. They come from Wang et al.'s MS-SSIM paper.
+var msSsimWeights = [5]float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+// RgbaSsim computes the structural similarity of two equal-sized RGBA images.
+// If ssimMap is not nil, the SSIM of the window around each pixel is written
+// to it, as an opaque grayscale RGBA image where black is 0 (or less) and
+// white is 1. The map can be saved by RgbaToPng. The map slice's length is set
+// to the needed image length. If the slice's capacity is too small, the slice
+// is re-created.
+func RgbaSsim(image1 []byte, image2 []byte, width int, height int,
+    ssimMap *[]byte) float64 {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(image1) < width * height * 4 || len(image2) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return NewRgbaView(image1, width, height).Ssim(
+      NewRgbaView(image2, width, height), ssimMap)
+}
+
+// Ssim computes the structural similarity of two equal-sized views.
+// This is the view equivalent of RgbaSsim.
+func (v RgbaView) Ssim(other RgbaView, ssimMap *[]byte) float64 {
+  if v.Width != other.Width || v.Height != other.Height {
+    panic("Views have different sizes")
+  }
+  return v.SsimCrop(other, 0, 0, ssimMap)
+}
+
+// RgbaSsimCrop computes the structural similarity of a needle image and a
+// crop of a haystack image.
+// The needle is compared with the crop at (needleLeft, needleTop). The SSIM map
+// is needle-sized, and works like in RgbaSsim. The function returns 0 and
+// leaves the map unchanged if the needle does not fit in the haystack at the
+// given position.
+func RgbaSsimCrop(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleLeft int,
+    needleTop int, ssimMap *[]byte) float64 {
+
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).SsimCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop,
+      ssimMap)
+}
+
+// SsimCrop computes the structural similarity of a needle view and a crop of
+// this view.
+// This is the view equivalent of RgbaSsimCrop.
+func (v RgbaView) SsimCrop(needle RgbaView, needleLeft int, needleTop int,
+    ssimMap *[]byte) float64 {
+  crop, ok := v.ssimCrop(needle, needleLeft, needleTop)
+  if !ok {
+    return 0
+  }
+  if ssimMap != nil {
+    resizeBuffer(ssimMap, needle.Width * needle.Height * 4)
+  }
+  if needle.Empty() {
+    return 1
+  }
+
+  width, height := needle.Width, needle.Height
+  window := ssimWindowSize(width, height)
+  var windowMap []float64
+  if ssimMap != nil {
+    windowMap = make([]float64,
+        (width - window + 1) * (height - window + 1))
+  }
+  ssim, _ := graySsim(crop.gray(), needle.gray(), width, height, window,
+      windowMap)
+
+  if ssimMap != nil {
+    // Each pixel gets the SSIM of the window centered on it. Pixels near the
+    // edges get the SSIM of the closest window.
+    mapWidth, mapHeight := width - window + 1, height - window + 1
+    for y := 0; y < height; y += 1 {
+      windowY := clamp(y - window / 2, 0, mapHeight - 1)
+      for x := 0; x < width; x += 1 {
+        windowX := clamp(x - window / 2, 0, mapWidth - 1)
+        value := math.Max(0, math.Min(1, windowMap[windowY * mapWidth +
+            windowX]))
+        gray := byte(math.Round(value * 255))
+        offset := (y * width + x) * 4
+        (*ssimMap)[offset], (*ssimMap)[offset + 1] = gray, gray
+        (*ssimMap)[offset + 2], (*ssimMap)[offset + 3] = gray, 0xff
+      }
+    }
+  }
+  return ssim
+}
+
+// RgbaMsSsim computes the multi-scale structural similarity of two
+// equal-sized RGBA images.
+// MS-SSIM compares the images at 5 scales, halving their resolution at each
+// step, so it accounts for both fine details and coarse structure. Images
+// that are too small for all the scales are compared at as many scales as
+// they fit.
+func RgbaMsSsim(image1 []byte, image2 []byte, width int,
+    height int) float64 {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(image1) < width * height * 4 || len(image2) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return NewRgbaView(image1, width, height).MsSsim(
+      NewRgbaView(image2, width, height))
+}
+
+// MsSsim computes the multi-scale structural similarity of two equal-sized
+// views.
+// This is the view equivalent of RgbaMsSsim.
+func (v RgbaView) MsSsim(other RgbaView) float64 {
+  if v.Width != other.Width || v.Height != other.Height {
+    panic("Views have different sizes")
+  }
+  return v.MsSsimCrop(other, 0, 0)
+}
+
+// RgbaMsSsimCrop computes the multi-scale structural similarity of a needle
+// image and a crop of a haystack image.
+// The needle is compared with the crop at (needleLeft, needleTop), like in
+// RgbaSsimCrop. The function returns 0 if the needle does not fit in the
+// haystack at the given position.
+func RgbaMsSsimCrop(haystack []byte, hayWidth int, hayHeight int,
+    needle []byte, needleWidth int, needleHeight int, needleLeft int,
+    needleTop int) float64 {
+
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if len(haystack) < hayWidth * hayHeight * 4 {
+    panic("Haystack width and height do not match buffer size")
+  }
+  if len(needle) < needleWidth * needleHeight * 4 {
+    panic("Needle width and height do not match buffer size")
+  }
+
+  return NewRgbaView(haystack, hayWidth, hayHeight).MsSsimCrop(
+      NewRgbaView(needle, needleWidth, needleHeight), needleLeft, needleTop)
+}
+
+// MsSsimCrop computes the multi-scale structural similarity of a needle view
+// and a crop of this view.
+// This is the view equivalent of RgbaMsSsimCrop.
+func (v RgbaView) MsSsimCrop(needle RgbaView, needleLeft int,
+    needleTop int) float64 {
+  crop, ok := v.ssimCrop(needle, needleLeft, needleTop)
+  if !ok {
+    return 0
+  }
+  if needle.Empty() {
+    return 1
+  }
+
+  width, height := needle.Width, needle.Height
+  gray1, gray2 := crop.gray(), needle.gray()
+  product, totalWeight := 1.0, 0.0
+  for scale, weight := range msSsimWeights {
+    window := ssimWindowSize(width, height)
+    ssim, contrast := graySsim(gray1, gray2, width, height, window, nil)
+
+    // The last scale contributes the full SSIM, while the others only
+    // contribute the contrast and structure terms. Negative terms are clamped
+    // so that the fractional powers are defined.
+    last := scale == len(msSsimWeights) - 1 ||
+        width / 2 < ssimWindow || height / 2 < ssimWindow
+    term := contrast
+    if last {
+      term = ssim
+    }
+    product *= math.Pow(math.Max(term, 0), weight)
+    totalWeight += weight
+    if last {
+      break
+    }
+
+    gray1 = grayHalve(gray1, width, height)
+    gray2 = grayHalve(gray2, width, height)
+    width, height = width / 2, height / 2
+  }
+
+  // The weights of the scales that were used are normalized to add up to 1.
+  return math.Pow(product, 1 / totalWeight)
+}
+
+// ssimCrop returns the part of this view that is compared with a needle.
+// It returns false if the needle does not fit in the view at the given
+// position.
+func (v RgbaView) ssimCrop(needle RgbaView, needleLeft int,
+    needleTop int) (RgbaView, bool) {
+  v.check("Haystack view")
+  needle.check("Needle view")
+
+  // NOTE: These checks are also intended to prevent segmentation faults, but
+  //       we don't have to panic here.
+  if needleLeft < 0 || needleLeft + needle.Width > v.Width {
+    return RgbaView{}, false
+  }
+  if needleTop < 0 || needleTop + needle.Height > v.Height {
+    return RgbaView{}, false
+  }
+  crop, _ := v.Crop(needleLeft, needleTop, needle.Width, needle.Height)
+  return crop, true
+}
+
+// ssimWindowSize returns the SSIM window size used for an image.
+// Images smaller than the standard window are covered by a single window.
+func ssimWindowSize(width int, height int) int {
+  return clamp(ssimWindow, 1, clamp(width, 1, height))
+}
+
+// graySsim computes the SSIM of two non-empty packed grayscale images.
+// It returns the mean SSIM of all the windows, and the mean of the contrast
+// and structure terms, which are used by MS-SSIM. If windowMap is not nil, it
+// receives each window's SSIM, in row-major order.
+func graySsim(gray1 []byte, gray2 []byte, width int, height int, window int,
+    windowMap []float64) (float64, float64) {
+  windowCount := (width - window + 1) * (height - window + 1)
+  stats := make([]uint32, windowCount * 5)
+  columns := make([]uint32, width * 5)
+  graySsimStats(gray1, gray2, width, height, window, stats, columns)
+
+  // The variances and covariance use the sample estimators, like
+  // scikit-image.
+  count := float64(window * window)
+  correction := 1.0
+  if window > 1 {
+    correction = count / (count - 1)
+  }
+  ssimSum, contrastSum := 0.0, 0.0
+  for i := 0; i < windowCount; i += 1 {
+    sums := stats[i * 5:i * 5 + 5]
+    mean1, mean2 := float64(sums[0]) / count, float64(sums[1]) / count
+    variance1 := (float64(sums[2]) / count - mean1 * mean1) * correction
+    variance2 := (float64(sums[3]) / count - mean2 * mean2) * correction
+    covariance := (float64(sums[4]) / count - mean1 * mean2) * correction
+
+    luminance := (2 * mean1 * mean2 + ssimC1) /
+        (mean1 * mean1 + mean2 * mean2 + ssimC1)
+    contrast := (2 * covariance + ssimC2) / (variance1 + variance2 + ssimC2)
+    ssimSum += luminance * contrast
+    contrastSum += contrast
+    if windowMap != nil {
+      windowMap[i] = luminance * contrast
+    }
+  }
+  return ssimSum / float64(windowCount), contrastSum / float64(windowCount)
+}
+
+// grayHalve halves a packed grayscale image's resolution.
+// Each output pixel is the rounded average of a 2x2 block. An odd last row or
+// column is dropped.
+func grayHalve(gray []byte, width int, height int) []byte {
+  halfWidth, halfHeight := width / 2, height / 2
+  half := make([]byte, halfWidth * halfHeight)
+  for y := 0; y < halfHeight; y += 1 {
+    row1, row2 := gray[2 * y * width:], gray[(2 * y + 1) * width:]
+    for x := 0; x < halfWidth; x += 1 {
+      sum := int(row1[2 * x]) + int(row1[2 * x + 1]) + int(row2[2 * x]) +
+          int(row2[2 * x + 1])
+      half[y * halfWidth + x] = byte((sum + 2) / 4)
+    }
+  }
+  return half
+}
@@ -0,0 +1,22 @@
+//go:build cgo
+
+package imageutil
+
+// #include "c/similarity.c"
+import "C"  // cgo
+
+import (
+  "unsafe"
+)
+
+// graySsimStats computes the SSIM window sums of two packed grayscale images.
+// The images must be at least window pixels wide and tall. The stats slice
+// needs 5 values for each window position, and the columns slice needs
+// 5 * width values.
+func graySsimStats(gray1 []byte, gray2 []byte, width int, height int,
+    window int, stats []uint32, columns []uint32) {
+  C.GoGraySsimStats(unsafe.Pointer(&gray1[0]), unsafe.Pointer(&gray2[0]),
+      C.int(width), C.int(height), C.int(window),
+      (*C.uint32_t)(unsafe.Pointer(&stats[0])),
+      (*C.uint32_t)(unsafe.Pointer(&columns[0])))
+}
@@ -0,0 +1,63 @@
+//go:build !cgo
+
+package imageutil
+
+// The kernels below are the pure Go versions of the functions in
+// c/similarity.c.
+
+// graySsimStats computes the SSIM window sums of two packed grayscale images.
+// The images must be at least window pixels wide and tall. The stats slice
+// needs 5 values for each window position, and the columns slice needs
+// 5 * width values.
+func graySsimStats(gray1 []byte, gray2 []byte, width int, height int,
+    window int, stats []uint32, columns []uint32) {
+  for i := range columns[:5 * width] {
+    columns[i] = 0
+  }
+  for y := 0; y < height; y += 1 {
+    // The columns hold the sums over the window rows ending at row y.
+    row1, row2 := gray1[y * width:], gray2[y * width:]
+    for x := 0; x < width; x += 1 {
+      value1, value2 := uint32(row1[x]), uint32(row2[x])
+      column := columns[5 * x:]
+      column[0] += value1
+      column[1] += value2
+      column[2] += value1 * value1
+      column[3] += value2 * value2
+      column[4] += value1 * value2
+    }
+    if y >= window {
+      oldRow1, oldRow2 := gray1[(y - window) * width:],
+          gray2[(y - window) * width:]
+      for x := 0; x < width; x += 1 {
+        value1, value2 := uint32(oldRow1[x]), uint32(oldRow2[x])
+        column := columns[5 * x:]
+        column[0] -= value1
+        column[1] -= value2
+        column[2] -= value1 * value1
+        column[3] -= value2 * value2
+        column[4] -= value1 * value2
+      }
+    }
+    if y < window - 1 {
+      continue
+    }
+
+    var sums [5]uint32
+    for x := 0; x < width; x += 1 {
+      for i := range sums {
+        sums[i] += columns[5 * x + i]
+      }
+      if x >= window {
+        for i := range sums {
+          sums[i] -= columns[5 * (x - window) + i]
+        }
+      }
+      if x < window - 1 {
+        continue
+      }
+      copy(stats, sums[:])
+      stats = stats[5:]
+    }
+  }
+}
@@ -0,0 +1,175 @@
+package imageutil
+
+import (
+  "math"
+  "math/rand"
+  "testing"
+)
+
+// referenceSsim computes SSIM directly from its definition.
+func referenceSsim(gray1 []byte, gray2 []byte, width int, height int,
+    window int) float64 {
+  total, count := 0.0, 0
+  for top := 0; top + window <= height; top += 1 {
+    for left := 0; left + window <= width; left += 1 {
+      n := float64(window * window)
+      mean1, mean2 := 0.0, 0.0
+      for y := top; y < top + window; y += 1 {
+        for x := left; x < left + window; x += 1 {
+          mean1 += float64(gray1[y * width + x]) / n
+          mean2 += float64(gray2[y * width + x]) / n
+        }
+      }
+      variance1, variance2, covariance := 0.0, 0.0, 0.0
+      for y := top; y < top + window; y += 1 {
+        for x := left; x < left + window; x += 1 {
+          d1 := float64(gray1[y * width + x]) - mean1
+          d2 := float64(gray2[y * width + x]) - mean2
+          variance1 += d1 * d1 / (n - 1)
+          variance2 += d2 * d2 / (n - 1)
+          covariance += d1 * d2 / (n - 1)
+        }
+      }
+      total += (2 * mean1 * mean2 + ssimC1) * (2 * covariance + ssimC2) /
+          ((mean1 * mean1 + mean2 * mean2 + ssimC1) *
+          (variance1 + variance2 + ssimC2))
+      count += 1
+    }
+  }
+  return total / float64(count)
+}
+
+func TestRgbaSsim(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  random := rand.New(rand.NewSource(5))
+  noisy := make([]byte, len(fruits.Pix))
+  brighter := make([]byte, len(fruits.Pix))
+  for i, value := range fruits.Pix {
+    noisy[i], brighter[i] = value, value
+    if i % 4 == 3 {
+      continue
+    }
+    noisy[i] = byte(clamp(int(value) + random.Intn(61) - 30, 0, 255))
+    brighter[i] = byte(clamp(int(value) + 10, 0, 255))
+  }
+
+  var ssimMap []byte
+  if ssim := RgbaSsim(fruits.Pix, fruits.Pix, width, height,
+      &ssimMap); ssim != 1 {
+    t.Error("SSIM of identical images is ", ssim)
+  }
+  if len(ssimMap) != width * height * 4 || ssimMap[1000] != 0xff {
+    t.Error("Incorrect SSIM map for identical images")
+  }
+  noisySsim := RgbaSsim(fruits.Pix, noisy, width, height, &ssimMap)
+  brighterSsim := RgbaSsim(fruits.Pix, brighter, width, height, nil)
+  if noisySsim > 0.8 || brighterSsim < 0.95 {
+    t.Errorf("SSIM is %f for noise and %f for brightness\n", noisySsim,
+        brighterSsim)
+  }
+
+  crop, _ := ViewRgba(fruits.Pix, width, height, 100, 120, 60, 40)
+  needle, _ := ViewRgba(noisy, width, height, 100, 120, 60, 40)
+  gray1, gray2 := crop.gray(), needle.gray()
+  reference := referenceSsim(gray1, gray2, 60, 40, ssimWindow)
+  var cropMap []byte
+  ssim := RgbaSsimCrop(fruits.Pix, width, height, noisy[:0], 0, 0, 0, 0, nil)
+  if ssim != 1 {
+    t.Error("SSIM of empty needle is ", ssim)
+  }
+  var needleImage []byte
+  needle.Materialize(&needleImage)
+  ssim = RgbaSsimCrop(fruits.Pix, width, height, needleImage, 60, 40, 100,
+      120, &cropMap)
+  if math.Abs(ssim - reference) > 1e-9 {
+    t.Errorf("SSIM of crop is %f instead of %f\n", ssim, reference)
+  }
+  if len(cropMap) != 60 * 40 * 4 {
+    t.Fatal("Incorrect SSIM map size: ", len(cropMap))
+  }
+  // Away from the crop's edges, the windows are the same as in the image.
+  for y := 3; y < 37; y += 1 {
+    for x := 3; x < 57; x += 1 {
+      offset := (y * 60 + x) * 4
+      if cropMap[offset + 3] != 0xff || cropMap[offset] !=
+          ssimMap[((120 + y) * width + 100 + x) * 4] {
+        t.Fatalf("Crop SSIM map does not match image SSIM map at (%d, %d)\n",
+            x, y)
+      }
+    }
+  }
+  if ssim := RgbaSsimCrop(fruits.Pix, width, height, needleImage, 60, 40,
+      500, 120, &cropMap); ssim != 0 {
+    t.Error("Out of bounds SSIM crop returned ", ssim)
+  }
+
+  // Images smaller than a window are covered by one window.
+  gray1, gray2 = gray1[:4], gray2[:4]
+  small1, small2 := make([]byte, 16), make([]byte, 16)
+  for i := range gray1 {
+    small1[i * 4], small1[i * 4 + 1], small1[i * 4 + 2] = gray1[i], gray1[i],
+        gray1[i]
+    small2[i * 4], small2[i * 4 + 1], small2[i * 4 + 2] = gray2[i], gray2[i],
+        gray2[i]
+  }
+  if ssim, reference := RgbaSsim(small1, small2, 2, 2, nil),
+      referenceSsim(gray1, gray2, 2, 2, 2); math.Abs(ssim - reference) > 1e-9 {
+    t.Errorf("SSIM of small images is %f instead of %f\n", ssim, reference)
+  }
+}
+
+func TestRgbaMsSsim(t *testing.T) {
+  fruits, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := fruits.Bounds().Dx(), fruits.Bounds().Dy()
+
+  random := rand.New(rand.NewSource(5))
+  noisy := make([]byte, len(fruits.Pix))
+  for i, value := range fruits.Pix {
+    noisy[i] = value
+    if i % 4 != 3 {
+      noisy[i] = byte(clamp(int(value) + random.Intn(61) - 30, 0, 255))
+    }
+  }
+  var shifted []byte
+  CropRgba(fruits.Pix, width, height, 2, 2, width - 2, height - 2, &shifted)
+
+  if msSsim := RgbaMsSsim(fruits.Pix, fruits.Pix, width, height);
+      math.Abs(msSsim - 1) > 1e-12 {
+    t.Error("MS-SSIM of identical images is ", msSsim)
+  }
+  // Noise mostly affects the fine scales, so MS-SSIM rates it higher than
+  // SSIM does.
+  msSsim := RgbaMsSsim(fruits.Pix, noisy, width, height)
+  ssim := RgbaSsim(fruits.Pix, noisy, width, height, nil)
+  if msSsim <= ssim || msSsim >= 1 {
+    t.Errorf("MS-SSIM is %f for noise, and SSIM is %f\n", msSsim, ssim)
+  }
+
+  shiftedSsim := RgbaMsSsimCrop(fruits.Pix, width, height, shifted,
+      width - 2, height - 2, 0, 0)
+  alignedSsim := RgbaMsSsimCrop(fruits.Pix, width, height, shifted,
+      width - 2, height - 2, 2, 2)
+  if shiftedSsim >= 0.9 || math.Abs(alignedSsim - 1) > 1e-12 {
+    t.Errorf("MS-SSIM is %f for shifted crop and %f for aligned crop\n",
+        shiftedSsim, alignedSsim)
+  }
+  if msSsim := RgbaMsSsimCrop(fruits.Pix, width, height, shifted,
+      width - 2, height - 2, 3, 0); msSsim != 0 {
+    t.Error("Out of bounds MS-SSIM crop returned ", msSsim)
+  }
+
+  // Small images use fewer scales.
+  small := RgbaMsSsimCrop(fruits.Pix, width, height, noisy[:20 * 4], 5, 4,
+      0, 0)
+  if small <= 0 || small >= 1 {
+    t.Error("MS-SSIM of small images is ", small)
+  }
+}
@@ -0,0 +1,128 @@
+package imageutil
+
+import (
+  "errors"
+)
+
+// rgbaOrient copies an image into a target slice, re-arranging its pixels.
+// Source pixel (x, y) ends up at target pixel base + x * xStep + y * yStep.
+// The pixels are not re-arranged in place, so the target slice must not share
+// memory with the image.
+func rgbaOrient(rawImage []byte, width int, height int, base int, xStep int,
+    yStep int, target *[]byte) {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if width < 0 || height < 0 {
+    panic("Image width and height must not be negative")
+  }
+  if len(rawImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+
+  resizeBuffer(target, width * height * 4)
+  if width == 0 || height == 0 {
+    return
+  }
+  orientRgba(rawImage, *target, width, height, base, xStep, yStep)
+}
+
+// RotateRgba90 rotates an RGBA image by 90 degrees clockwise.
+// The rotated image is height pixels wide and width pixels tall. The target
+// slice's length is set to the needed image length. If the slice's capacity is
+// too small, the slice is re-created. The target must not share memory with
+// the image, or the result is corrupted.
+func RotateRgba90(rawImage []byte, width int, height int, target *[]byte) {
+  rgbaOrient(rawImage, width, height, height - 1, height, -1, target)
+}
+
+// RotateRgba180 rotates an RGBA image by 180 degrees.
+// The target slice's length is set to the needed image length. If the slice's
+// capacity is too small, the slice is re-created. The target must not share
+// memory with the image, or the result is corrupted.
+func RotateRgba180(rawImage []byte, width int, height int, target *[]byte) {
+  rgbaOrient(rawImage, width, height, width * height - 1, -1, -width, target)
+}
+
+// RotateRgba270 rotates an RGBA image by 270 degrees clockwise.
+// The rotated image is height pixels wide and width pixels tall. The target
+// slice's length is set to the needed image length. If the slice's capacity is
+// too small, the slice is re-created. The target must not share memory with
+// the image, or the result is corrupted.
+func RotateRgba270(rawImage []byte, width int, height int, target *[]byte) {
+  rgbaOrient(rawImage, width, height, (width - 1) * height, -height, 1,
+      target)
+}
+
+// FlipRgbaHorizontal mirrors an RGBA image around its vertical axis.
+// The target slice's length is set to the needed image length. If the slice's
+// capacity is too small, the slice is re-created. The target must not share
+// memory with the image, or the result is corrupted.
+func FlipRgbaHorizontal(rawImage []byte, width int, height int,
+    target *[]byte) {
+  rgbaOrient(rawImage, width, height, width - 1, -1, width, target)
+}
+
+// FlipRgbaVertical mirrors an RGBA image around its horizontal axis.
+// The target slice's length is set to the needed image length. If the slice's
+// capacity is too small, the slice is re-created. The target must not share
+// memory with the image, or the result is corrupted.
+func FlipRgbaVertical(rawImage []byte, width int, height int, target *[]byte) {
+  rgbaOrient(rawImage, width, height, (height - 1) * width, 1, -width, target)
+}
+
+// TransposeRgba mirrors an RGBA image around its main diagonal.
+// The transposed image is height pixels wide and width pixels tall. The target
+// slice's length is set to the needed image length. If the slice's capacity is
+// too small, the slice is re-created. The target must not share memory with
+// the image, or the result is corrupted.
+func TransposeRgba(rawImage []byte, width int, height int, target *[]byte) {
+  rgbaOrient(rawImage, width, height, 0, height, 1, target)
+}
+
+// AffineWarpRgba applies an affine transformation to an RGBA image.
+// The matrix {a, b, c, d, e, f} maps source coordinates (x, y) to target
+// coordinates (a * x + b * y + c, d * x + e * y + f). Target pixels are sampled
+// bilinearly from the source, and areas that are not covered by the source
+// image are filled with the given background color. The target slice's length
+// is set to the needed image length. If the slice's capacity is too small, the
+// slice is re-created. The target must not share memory with the image, or the
+// result is corrupted.
+// It returns an error if the matrix is not invertible.
+func AffineWarpRgba(rawImage []byte, width int, height int, matrix [6]float64,
+    targetWidth int, targetHeight int, backgroundRgba uint32,
+    target *[]byte) error {
+  // NOTE: These checks are mainly here to prevent segmentation faults in the
+  //       C code. Therefore, panicing is appropriate.
+  if width < 0 || height < 0 {
+    panic("Image width and height must not be negative")
+  }
+  if targetWidth < 0 || targetHeight < 0 {
+    panic("Target width and height must not be negative")
+  }
+  if len(rawImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+
+  // The kernels map target pixels back to the source, so it needs the inverse
+  // transformation.
+  a, b, c, d, e, f := matrix[0], matrix[1], matrix[2], matrix[3], matrix[4],
+      matrix[5]
+  det := a * e - b * d
+  if det == 0 {
+    return errors.New("Affine transformation matrix is not invertible")
+  }
+  ia, ib := e / det, -b / det
+  id, ie := -d / det, a / det
+  ic, ifx := -(ia * c + ib * f), -(id * c + ie * f)
+
+  resizeBuffer(target, targetWidth * targetHeight * 4)
+  if targetWidth == 0 || targetHeight == 0 {
+    return nil
+  }
+  background := [4]byte{byte(backgroundRgba >> 24),
+      byte(backgroundRgba >> 16), byte(backgroundRgba >> 8),
+      byte(backgroundRgba)}
+  affineWarpRgba(rawImage, *target, width, height, targetWidth, targetHeight,
+      [6]float64{ia, ib, ic, id, ie, ifx}, background)
+  return nil
+}
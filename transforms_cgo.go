@@ -0,0 +1,38 @@
+//go:build cgo
+
+package imageutil
+
+// #cgo LDFLAGS: -lm
+// #include "c/transforms.c"
+import "C"  // cgo
+
+import (
+  "unsafe"
+)
+
+// orientRgba copies a non-empty image into a target, re-arranging its pixels.
+// Source pixel (x, y) ends up at target pixel base + x * xStep + y * yStep.
+func orientRgba(rawImage []byte, target []byte, width int, height int,
+    base int, xStep int, yStep int) {
+  C.GoRgbaOrient(unsafe.Pointer(&rawImage[0]), unsafe.Pointer(&target[0]),
+      C.int(width), C.int(height), C.int(base), C.int(xStep), C.int(yStep))
+}
+
+// affineWarpRgba fills a non-empty target by sampling a source image.
+// The inverse matrix maps target pixel centers to source coordinates.
+func affineWarpRgba(rawImage []byte, target []byte, width int, height int,
+    targetWidth int, targetHeight int, inverse [6]float64,
+    background [4]byte) {
+  // NOTE: An empty source image is handled by pointing the C code at the
+  //       target buffer, which it never reads when width or height is zero.
+  source := unsafe.Pointer(&target[0])
+  if len(rawImage) > 0 {
+    source = unsafe.Pointer(&rawImage[0])
+  }
+  C.GoRgbaAffineWarp(source, unsafe.Pointer(&target[0]), C.int(width),
+      C.int(height), C.int(targetWidth), C.int(targetHeight),
+      C.double(inverse[0]), C.double(inverse[1]), C.double(inverse[2]),
+      C.double(inverse[3]), C.double(inverse[4]), C.double(inverse[5]),
+      C.uint8_t(background[0]), C.uint8_t(background[1]),
+      C.uint8_t(background[2]), C.uint8_t(background[3]))
+}
@@ -0,0 +1,96 @@
+//go:build !cgo
+
+package imageutil
+
+import (
+  "math"
+)
+
+// The functions below are the pure Go versions of the functions in
+// c/transforms.c.
+
+// orientTileSize is the side of the tiles used by orientRgba.
+const orientTileSize = 32
+
+// orientRgba copies a non-empty image into a target, re-arranging its pixels.
+// Source pixel (x, y) ends up at target pixel base + x * xStep + y * yStep.
+func orientRgba(rawImage []byte, target []byte, width int, height int,
+    base int, xStep int, yStep int) {
+  for tileY := 0; tileY < height; tileY += orientTileSize {
+    maxY := min(tileY + orientTileSize, height)
+    for tileX := 0; tileX < width; tileX += orientTileSize {
+      maxX := min(tileX + orientTileSize, width)
+      for y := tileY; y < maxY; y += 1 {
+        source := (y * width + tileX) * 4
+        destination := (base + y * yStep + tileX * xStep) * 4
+        for x := tileX; x < maxX; x += 1 {
+          copy(target[destination:destination + 4],
+              rawImage[source:source + 4])
+          source += 4
+          destination += xStep * 4
+        }
+      }
+    }
+  }
+}
+
+// affineWarpRgba fills a non-empty target by sampling a source image.
+// The inverse matrix maps target pixel centers to source coordinates.
+//
+// NOTE: The explicit float64 conversions stop the compiler from fusing
+//       multiply-adds, which would change rounding and break bit-exactness
+//       with the C kernel.
+func affineWarpRgba(rawImage []byte, target []byte, width int, height int,
+    targetWidth int, targetHeight int, inverse [6]float64,
+    background [4]byte) {
+  m := inverse
+  offset := 0
+  for y := 0; y < targetHeight; y += 1 {
+    centerY := float64(y) + 0.5
+    for x := 0; x < targetWidth; x, offset = x + 1, offset + 4 {
+      centerX := float64(x) + 0.5
+      sourceX := float64(m[0] * centerX) + float64(m[1] * centerY) + m[2] - 0.5
+      sourceY := float64(m[3] * centerX) + float64(m[4] * centerY) + m[5] - 0.5
+
+      floorX, floorY := math.Floor(sourceX), math.Floor(sourceY)
+      if floorX < -1 || floorX >= float64(width) || floorY < -1 ||
+          floorY >= float64(height) {
+        copy(target[offset:offset + 4], background[:])
+        continue
+      }
+
+      x0, y0 := int(floorX), int(floorY)
+      fracX, fracY := sourceX - floorX, sourceY - floorY
+
+      // The four neighbors, in the order (x0, y0), (x0 + 1, y0), (x0, y0 + 1),
+      // (x0 + 1, y0 + 1).
+      var samples [4][]byte
+      for i := range samples {
+        sampleX, sampleY := x0 + (i & 1), y0 + (i >> 1)
+        if sampleX < 0 || sampleX >= width || sampleY < 0 ||
+            sampleY >= height {
+          samples[i] = background[:]
+        } else {
+          start := 4 * (sampleY * width + sampleX)
+          samples[i] = rawImage[start:start + 4]
+        }
+      }
+      weights := [4]float64{
+        float64((1 - fracX) * (1 - fracY)), float64(fracX * (1 - fracY)),
+        float64((1 - fracX) * fracY), float64(fracX * fracY),
+      }
+
+      for channel := 0; channel < 4; channel += 1 {
+        value := float64(weights[0] * float64(samples[0][channel])) +
+            float64(weights[1] * float64(samples[1][channel])) +
+            float64(weights[2] * float64(samples[2][channel])) +
+            float64(weights[3] * float64(samples[3][channel]))
+        rounded := int(value + 0.5)
+        if rounded > 255 {
+          rounded = 255
+        }
+        target[offset + channel] = byte(rounded)
+      }
+    }
+  }
+}
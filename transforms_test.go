@@ -0,0 +1,230 @@
+package imageutil
+
+import (
+  "bytes"
+  "math"
+  "testing"
+)
+
+func TestRotateRgba90(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  // NOTE: We crop the initial image because we want different width / height,
+  //       to catch mixed up dimensions.
+  var imageBytes []byte
+  width, height := 96, 64
+  CropRgba(image.Pix, image.Bounds().Dx(), image.Bounds().Dy(), 200, 300,
+      width, height, &imageBytes)
+
+  var rotated []byte
+  RotateRgba90(imageBytes, width, height, &rotated)
+  // Save the rotation result for debugging.
+  RgbaToPng(rotated, height, width, "test_tmp/fruits_RotateRgba90.png")
+  if len(rotated) != len(imageBytes) {
+    t.Fatal("Incorrect rotated data size: ", len(rotated))
+  }
+
+  for y := 0; y < height; y += 1 {
+    for x := 0; x < width; x += 1 {
+      source := imageBytes[4 * (y * width + x):4 * (y * width + x + 1)]
+      targetX, targetY := height - 1 - y, x
+      target := rotated[4 * (targetY * height + targetX):
+          4 * (targetY * height + targetX + 1)]
+      if !bytes.Equal(source, target) {
+        t.Fatalf("Pixel mismatch at %d, %d\n", x, y)
+      }
+    }
+  }
+
+  // Four quarter-turns must restore the original image.
+  var scratch []byte
+  RotateRgba90(rotated, height, width, &scratch)
+  RotateRgba90(scratch, width, height, &rotated)
+  RotateRgba90(rotated, height, width, &scratch)
+  if !bytes.Equal(scratch, imageBytes) {
+    t.Error("Four 90 degree rotations did not restore the image")
+  }
+}
+
+func TestRotateRgba180And270(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var imageBytes []byte
+  width, height := 96, 64
+  CropRgba(image.Pix, image.Bounds().Dx(), image.Bounds().Dy(), 200, 300,
+      width, height, &imageBytes)
+
+  var rotated90, rotated180, rotated270, golden []byte
+  RotateRgba90(imageBytes, width, height, &rotated90)
+  RotateRgba90(rotated90, height, width, &golden)
+  RotateRgba180(imageBytes, width, height, &rotated180)
+  if !bytes.Equal(rotated180, golden) {
+    t.Error("RotateRgba180 does not match two 90 degree rotations")
+  }
+
+  RotateRgba90(golden, width, height, &rotated90)
+  RotateRgba270(imageBytes, width, height, &rotated270)
+  if !bytes.Equal(rotated270, rotated90) {
+    t.Error("RotateRgba270 does not match three 90 degree rotations")
+  }
+
+  RotateRgba90(rotated270, height, width, &golden)
+  if !bytes.Equal(golden, imageBytes) {
+    t.Error("RotateRgba270 does not undo RotateRgba90")
+  }
+}
+
+func TestFlipRgba(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var imageBytes []byte
+  width, height := 96, 64
+  CropRgba(image.Pix, image.Bounds().Dx(), image.Bounds().Dy(), 200, 300,
+      width, height, &imageBytes)
+
+  var flipped, flipped2, rotated []byte
+  FlipRgbaHorizontal(imageBytes, width, height, &flipped)
+  for y := 0; y < height; y += 1 {
+    for x := 0; x < width; x += 1 {
+      source := imageBytes[4 * (y * width + x):4 * (y * width + x + 1)]
+      target := flipped[4 * (y * width + width - 1 - x):
+          4 * (y * width + width - x)]
+      if !bytes.Equal(source, target) {
+        t.Fatalf("Horizontal flip pixel mismatch at %d, %d\n", x, y)
+      }
+    }
+  }
+
+  FlipRgbaVertical(flipped, width, height, &flipped2)
+  RotateRgba180(imageBytes, width, height, &rotated)
+  if !bytes.Equal(flipped2, rotated) {
+    t.Error("Horizontal and vertical flips do not match a 180 degree rotation")
+  }
+
+  FlipRgbaVertical(imageBytes, width, height, &flipped)
+  FlipRgbaVertical(flipped, width, height, &flipped2)
+  if !bytes.Equal(flipped2, imageBytes) {
+    t.Error("Two vertical flips did not restore the image")
+  }
+}
+
+func TestTransposeRgba(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var imageBytes []byte
+  width, height := 96, 64
+  CropRgba(image.Pix, image.Bounds().Dx(), image.Bounds().Dy(), 200, 300,
+      width, height, &imageBytes)
+
+  var transposed, golden, rotated []byte
+  TransposeRgba(imageBytes, width, height, &transposed)
+  // Save the transposition result for debugging.
+  RgbaToPng(transposed, height, width, "test_tmp/fruits_TransposeRgba.png")
+
+  RotateRgba90(imageBytes, width, height, &rotated)
+  FlipRgbaHorizontal(rotated, height, width, &golden)
+  if !bytes.Equal(transposed, golden) {
+    t.Error("Transposition does not match rotation and flip")
+  }
+}
+
+func TestAffineWarpRgba(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  var imageBytes []byte
+  width, height := 96, 64
+  CropRgba(image.Pix, image.Bounds().Dx(), image.Bounds().Dy(), 200, 300,
+      width, height, &imageBytes)
+
+  var warped, golden []byte
+  identity := [6]float64{1, 0, 0, 0, 1, 0}
+  if err := AffineWarpRgba(imageBytes, width, height, identity, width, height,
+      0, &warped); err != nil {
+    t.Fatal(err)
+  }
+  if !bytes.Equal(warped, imageBytes) {
+    t.Error("Identity warp changed the image")
+  }
+
+  // A quarter-turn around the origin, followed by a shift that brings the
+  // image back into view, matches RotateRgba90.
+  quarterTurn := [6]float64{0, -1, float64(height), 1, 0, 0}
+  if err := AffineWarpRgba(imageBytes, width, height, quarterTurn, height,
+      width, 0, &warped); err != nil {
+    t.Fatal(err)
+  }
+  RotateRgba90(imageBytes, width, height, &golden)
+  if !bytes.Equal(warped, golden) {
+    t.Error("Quarter-turn warp does not match RotateRgba90")
+  }
+
+  // An integer translation is a crop with background fill.
+  shift := [6]float64{1, 0, -10, 0, 1, -20}
+  if err := AffineWarpRgba(imageBytes, width, height, shift, width, height,
+      0x11223344, &warped); err != nil {
+    t.Fatal(err)
+  }
+  for y := 0; y < height; y += 1 {
+    for x := 0; x < width; x += 1 {
+      target := warped[4 * (y * width + x):4 * (y * width + x + 1)]
+      var source []byte
+      if x + 10 < width && y + 20 < height {
+        offset := 4 * ((y + 20) * width + x + 10)
+        source = imageBytes[offset:offset + 4]
+      } else {
+        source = []byte{0x11, 0x22, 0x33, 0x44}
+      }
+      if !bytes.Equal(source, target) {
+        t.Fatalf("Shift warp pixel mismatch at %d, %d: %v vs %v\n", x, y,
+            target, source)
+      }
+    }
+  }
+
+  // Scaling up by 2 interpolates between neighboring pixels.
+  gradient := []byte{0, 0, 0, 255, 200, 100, 50, 255,
+      0, 0, 0, 255, 200, 100, 50, 255}
+  scale := [6]float64{2, 0, 0, 0, 2, 0}
+  if err := AffineWarpRgba(gradient, 2, 2, scale, 4, 4, 0,
+      &warped); err != nil {
+    t.Fatal(err)
+  }
+  // Target pixel (1, 1) maps to source (0.25, 0.25), so it is 3/4 of the first
+  // column and 1/4 of the second one.
+  if !bytes.Equal(warped[20:24], []byte{50, 25, 13, 255}) {
+    t.Error("Incorrect interpolated pixel: ", warped[20:24])
+  }
+
+  rotation := [6]float64{math.Cos(0.3), -math.Sin(0.3), 10, math.Sin(0.3),
+      math.Cos(0.3), -5}
+  if err := AffineWarpRgba(imageBytes, width, height, rotation, width * 2,
+      height * 2, 0x000000ff, &warped); err != nil {
+    t.Fatal(err)
+  }
+  // Save the warp result for debugging.
+  RgbaToPng(warped, width * 2, height * 2, "test_tmp/fruits_AffineWarp.png")
+  if len(warped) != width * height * 16 {
+    t.Error("Incorrect warped data size: ", len(warped))
+  }
+
+  singular := [6]float64{1, 2, 0, 2, 4, 0}
+  if err := AffineWarpRgba(imageBytes, width, height, singular, width, height,
+      0, &warped); err == nil {
+    t.Error("Singular matrix did not cause an error")
+  }
+}
@@ -0,0 +1,159 @@
+package imageutil
+
+import (
+  "fmt"
+  "image"
+)
+
+// RgbaView is a rectangular area inside a raw RGBA image buffer.
+// Views reference their parent image's pixels, so creating a view does not
+// copy any pixel data, and changes made through a view are visible in the
+// parent image. The view's rows are Stride bytes apart, so the view's pixels
+// are not contiguous, unless the view spans the parent image's entire width.
+type RgbaView struct {
+  // Pix holds the view's pixels, starting with its top-left pixel.
+  Pix []byte
+  // Width is the view's width, in pixels.
+  Width int
+  // Height is the view's height, in pixels.
+  Height int
+  // Stride is the distance between vertically adjacent pixels, in bytes.
+  Stride int
+}
+
+// NewRgbaView returns a view that covers an entire raw RGBA image.
+func NewRgbaView(rawImage []byte, width int, height int) RgbaView {
+  // NOTE: This check is mainly here to prevent segmentation faults in the C
+  //       code that the view might be passed to. Therefore, panicing is
+  //       appropriate.
+  if len(rawImage) < width * height * 4 {
+    panic("Image width and height do not match buffer size")
+  }
+  return RgbaView{Pix: rawImage[:width * height * 4], Width: width,
+      Height: height, Stride: width * 4}
+}
+
+// ViewRgba returns a view of a rectangle inside a raw RGBA image.
+// This is the zero-copy equivalent of CropRgba. It returns ErrCropOutOfBounds
+// if the crop rectangle is not entirely inside the image, and an error
+// wrapping ErrInvalidDimensions or ErrBufferTooSmall if the image's dimensions
+// don't match its buffer.
+func ViewRgba(rawImage []byte, width int, height int, xOffset int,
+    yOffset int, xSize int, ySize int) (RgbaView, error) {
+  if err := validateRgba("Image", rawImage, width, height); err != nil {
+    return RgbaView{}, err
+  }
+  return NewRgbaView(rawImage, width, height).Crop(xOffset, yOffset, xSize,
+      ySize)
+}
+
+// Crop returns a view of a rectangle inside this view.
+// The new view shares pixels with this view. It returns ErrCropOutOfBounds if
+// the crop rectangle is not entirely inside this view.
+func (v RgbaView) Crop(xOffset int, yOffset int, xSize int,
+    ySize int) (RgbaView, error) {
+  if xOffset < 0 || yOffset < 0 || xSize < 0 || ySize < 0 ||
+      xOffset + xSize > v.Width || yOffset + ySize > v.Height {
+    return RgbaView{}, ErrCropOutOfBounds
+  }
+  if xSize == 0 || ySize == 0 {
+    return RgbaView{Width: xSize, Height: ySize, Stride: v.Stride}, nil
+  }
+
+  start := v.PixOffset(xOffset, yOffset)
+  end := start + (ySize - 1) * v.Stride + xSize * 4
+  return RgbaView{Pix: v.Pix[start:end:end], Width: xSize, Height: ySize,
+      Stride: v.Stride}, nil
+}
+
+// PixOffset returns the index of the first byte of a pixel in the view's Pix.
+func (v RgbaView) PixOffset(x int, y int) int {
+  return y * v.Stride + x * 4
+}
+
+// Packed returns true if the view's pixels are contiguous.
+// Packed views' Pix can be passed to any function that takes a raw RGBA image.
+func (v RgbaView) Packed() bool {
+  return v.Stride == v.Width * 4
+}
+
+// Empty returns true if the view does not contain any pixels.
+func (v RgbaView) Empty() bool {
+  return v.Width <= 0 || v.Height <= 0
+}
+
+// Materialize copies a view's pixels into a target slice.
+// The target slice's length is set to the needed image length. If the slice's
+// capacity is too small, the slice is re-created.
+func (v RgbaView) Materialize(target *[]byte) {
+  v.check("View")
+
+  rowSize := v.Width * 4
+  resizeBuffer(target, rowSize * v.Height)
+  if v.Packed() {
+    copy(*target, v.Pix)
+    return
+  }
+  for y := 0; y < v.Height; y += 1 {
+    start := y * v.Stride
+    copy((*target)[y * rowSize:(y + 1) * rowSize],
+        v.Pix[start:start + rowSize])
+  }
+}
+
+// searchRegion returns the part of a view inside a search rectangle.
+// The search rectangle is clipped to the view's bounds. The returned point is
+// the region's top-left corner, which turns region coordinates into view
+// coordinates.
+func (v RgbaView) searchRegion(searchRect image.Rectangle) (RgbaView,
+    image.Point) {
+  clipRect := searchRect.Intersect(image.Rect(0, 0, v.Width, v.Height))
+  if clipRect.Empty() {
+    return RgbaView{Stride: v.Stride}, image.Point{}
+  }
+  region, _ := v.Crop(clipRect.Min.X, clipRect.Min.Y, clipRect.Dx(),
+      clipRect.Dy())
+  return region, clipRect.Min
+}
+
+// check panics if a view's fields are inconsistent.
+// The C code would cause segmentation faults if handed an inconsistent view.
+// The name identifies the view in the panic message.
+func (v RgbaView) check(name string) {
+  if err := v.validate(name); err != nil {
+    panic(err.Error())
+  }
+}
+
+// validate returns an error if a view's fields are inconsistent.
+// The name identifies the view in the error message.
+func (v RgbaView) validate(name string) error {
+  if v.Width < 0 || v.Height < 0 || v.Stride < v.Width * 4 ||
+      v.Stride % 4 != 0 {
+    return fmt.Errorf("%s has invalid dimensions or stride: %w", name,
+        ErrInvalidDimensions)
+  }
+  if !v.Empty() && len(v.Pix) < (v.Height - 1) * v.Stride + v.Width * 4 {
+    return fmt.Errorf("%s dimensions do not match buffer size: %w", name,
+        ErrBufferTooSmall)
+  }
+  return nil
+}
+
+// rows calls a function with the pixel data in each of the view's rows.
+// Packed views are presented as a single row, so the function gets called
+// fewer times.
+func (v RgbaView) rows(rowFunc func(row []byte)) {
+  if v.Empty() {
+    return
+  }
+  if v.Packed() {
+    rowFunc(v.Pix[:v.Width * v.Height * 4])
+    return
+  }
+  rowSize := v.Width * 4
+  for y := 0; y < v.Height; y += 1 {
+    start := y * v.Stride
+    rowFunc(v.Pix[start:start + rowSize])
+  }
+}
@@ -0,0 +1,214 @@
+package imageutil
+
+import (
+  "bytes"
+  "reflect"
+  "testing"
+)
+
+func TestViewRgba(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  view, err := ViewRgba(image.Pix, width, height, 200, 400, 128, 16)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if view.Width != 128 || view.Height != 16 || view.Stride != width * 4 {
+    t.Errorf("Incorrect view geometry: %d x %d, stride %d\n", view.Width,
+        view.Height, view.Stride)
+  }
+  if view.Packed() {
+    t.Error("View narrower than its parent reported as packed")
+  }
+
+  var golden, materialized []byte
+  CropRgba(image.Pix, width, height, 200, 400, 128, 16, &golden)
+  view.Materialize(&materialized)
+  if !bytes.Equal(materialized, golden) {
+    t.Error("Materialized view pixel data mismatch")
+  }
+
+  subView, err := view.Crop(28, 4, 100, 12)
+  if err != nil {
+    t.Fatal(err)
+  }
+  CropRgba(image.Pix, width, height, 228, 404, 100, 12, &golden)
+  subView.Materialize(&materialized)
+  if !bytes.Equal(materialized, golden) {
+    t.Error("Materialized sub-view pixel data mismatch")
+  }
+
+  // Views share pixels with their parents.
+  subView.Pix[0] ^= 0xff
+  if image.Pix[image.PixOffset(228, 404)] != golden[0] ^ 0xff {
+    t.Error("Changes made through the view are not visible in the parent")
+  }
+  subView.Pix[0] ^= 0xff
+
+  if _, err := view.Crop(100, 0, 29, 16); err != ErrCropOutOfBounds {
+    t.Error("Out of bounds view crop did not return ErrCropOutOfBounds: ",
+        err)
+  }
+  if _, err := ViewRgba(image.Pix, width, height, -1, 0, 16,
+      16); err != ErrCropOutOfBounds {
+    t.Error("Out of bounds view did not return ErrCropOutOfBounds: ", err)
+  }
+
+  fullView := NewRgbaView(image.Pix, width, height)
+  if !fullView.Packed() {
+    t.Error("Full-image view not reported as packed")
+  }
+}
+
+func TestRgbaViewMatchers(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+  fullView := NewRgbaView(image.Pix, width, height)
+
+  // The haystack is a view with a different width than its parent, to catch
+  // any code that mixes up widths and strides.
+  haystack, _ := fullView.Crop(10, 100, 300, 200)
+  needle, _ := fullView.Crop(210, 140, 12, 8)
+
+  if !haystack.CheckCrop(needle, 200, 40) {
+    t.Error("CheckCrop did not detect correctly aligned crop")
+  }
+  if haystack.CheckCrop(needle, 199, 40) {
+    t.Error("CheckCrop did not bounce crop misaligned by (-1, 0)")
+  }
+  if !haystack.CheckMaskedCrop(needle, 200, 40, 0xffffffff) {
+    t.Error("CheckMaskedCrop did not detect correctly aligned crop")
+  }
+  if diff := haystack.DiffMaskedCrop(needle, 200, 40, 0xffffffff); diff != 0 {
+    t.Error("Non-zero DiffMaskedCrop for identical views: ", diff)
+  }
+  if diff := haystack.DiffThresholdCrop(needle, 200, 40, 0, 255, 0, 255, 0,
+      255); diff != 0 {
+    t.Error("Non-zero DiffThresholdCrop for identical views: ", diff)
+  }
+
+  var needleBytes []byte
+  needle.Materialize(&needleBytes)
+  if hash := HashForRgbaFindCrop(needleBytes, 12, 8);
+      hash != needle.HashForFindCrop() {
+    t.Error("View hash does not match packed hash")
+  }
+
+  scratch := make([]byte, haystack.Width * 4)
+  count, matchX, matchY := haystack.FindCrop(needle, needle.HashForFindCrop(),
+      scratch)
+  if count != 1 || matchX != 200 || matchY != 40 {
+    t.Errorf("FindCrop returned count %d, matchX %d, matchY %d", count, matchX,
+        matchY)
+  }
+
+  var maskedBytes []byte
+  needle.Materialize(&maskedBytes)
+  MaskRgba(maskedBytes, BuildRgbaMask(0xc0f0e0ff))
+  maskedNeedle := NewRgbaView(maskedBytes, 12, 8)
+  count, matchX, matchY = haystack.FindMaskedCrop(maskedNeedle, 0xc0f0e0ff,
+      maskedNeedle.HashForFindCrop(), scratch)
+  if count != 1 || matchX != 200 || matchY != 40 {
+    t.Errorf("FindMaskedCrop returned count %d, matchX %d, matchY %d", count,
+        matchX, matchY)
+  }
+}
+
+func TestRgbaViewFilters(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  original := make([]byte, len(image.Pix))
+  copy(original, image.Pix)
+
+  view, _ := ViewRgba(image.Pix, width, height, 100, 100, 64, 32)
+  var golden, materialized []byte
+  view.Materialize(&golden)
+  RgbaThreshold(golden, 230, 255, 150, 220, 0, 120)
+  view.Threshold(230, 255, 150, 220, 0, 120)
+  view.Materialize(&materialized)
+  if !bytes.Equal(materialized, golden) {
+    t.Error("Thresholded view pixel data mismatch")
+  }
+
+  view.ResetPuddles()
+  view.Mask(BuildRgbaMask(0xf0e0c0ff))
+  copy(golden, materialized)
+  view.Materialize(&materialized)
+  for i := 0; i < len(golden); i += 4 {
+    golden[i] &= 0xf0
+    golden[i + 1] &= 0xe0
+    golden[i + 2] &= 0xc0
+    golden[i + 3] = 0xff
+  }
+  if !bytes.Equal(materialized, golden) {
+    t.Error("Masked view pixel data mismatch")
+  }
+
+  // Pixels outside the view must not be touched.
+  outside, _ := ViewRgba(image.Pix, width, height, 0, 0, width, 100)
+  var outsideGolden []byte
+  CropRgba(original, width, height, 0, 0, width, 100, &outsideGolden)
+  outside.Materialize(&materialized)
+  if !bytes.Equal(materialized, outsideGolden) {
+    t.Error("View filters changed pixels outside the view")
+  }
+
+  copy(image.Pix, original)
+  view, _ = ViewRgba(image.Pix, width, height, 100, 100, 64, 32)
+  hslaBytes := make([]byte, 64 * 32 * 4)
+  view.ToHsla(NewRgbaView(hslaBytes, 64, 32))
+  view.Materialize(&golden)
+  RgbaToHsla(golden, golden)
+  if !bytes.Equal(hslaBytes, golden) {
+    t.Error("HSLA view pixel data mismatch")
+  }
+}
+
+func TestRgbaViewObjects(t *testing.T) {
+  image, err := ReadRgbaPng("test_data/fruits.png")
+  if err != nil {
+    t.Fatal(err)
+  }
+  width, height := image.Bounds().Dx(), image.Bounds().Dy()
+
+  view, _ := ViewRgba(image.Pix, width, height, 400, 50, 100, 100)
+  var packed []byte
+  view.Materialize(&packed)
+
+  goldPillars := make([][4]int32, 4)
+  RgbaFindPillars(packed, 100, 100, 230, 255, 150, 220, 0, 120, goldPillars)
+  pillars := make([][4]int32, 4)
+  view.FindPillars(230, 255, 150, 220, 0, 120, pillars)
+  if !reflect.DeepEqual(pillars, goldPillars) {
+    t.Errorf("Incorrect view pillars: %v vs %v\n", pillars, goldPillars)
+  }
+
+  goldPuddle := make([][2]int32, 100 * 100)
+  goldSize := RgbaFindPuddle(packed, 100, 100, 230, 255, 150, 220, 0, 120, 0,
+      goldPuddle)
+  puddle := make([][2]int32, 100 * 100)
+  size := view.FindPuddle(230, 255, 150, 220, 0, 120, 0, puddle)
+  if size != goldSize || size == 0 {
+    t.Fatal("Incorrect view puddle size: ", size)
+  }
+  if !reflect.DeepEqual(puddle[:size], goldPuddle[:goldSize]) {
+    t.Error("View puddle pixels mismatch")
+  }
+
+  var materialized []byte
+  view.Materialize(&materialized)
+  if !bytes.Equal(materialized, packed) {
+    t.Error("View puddle marks do not match packed puddle marks")
+  }
+}